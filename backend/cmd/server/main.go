@@ -1,8 +1,10 @@
 package main
 
 import (
+	"backroom/internal/channels"
 	"backroom/internal/db"
 	"backroom/internal/handlers"
+	"backroom/internal/inventory"
 	"backroom/internal/models"
 	"log"
 	"net/http"
@@ -11,14 +13,42 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"gorm.io/gorm"
 )
 
 func main() {
 	// 1. Initialize DB
 	db.Init()
 
-	// Auto Migrate Supplier
-	db.DB.AutoMigrate(&models.Supplier{})
+	// Supplier mappings saved in an older format
+	if _, err := models.UpgradeMappings(db.DB); err != nil {
+		log.Fatal("Failed to upgrade supplier mappings:", err)
+	}
+
+	// Sales channels (seeds WooCommerce from env, migrates legacy woo_id)
+	if err := channels.Bootstrap(db.DB); err != nil {
+		log.Fatal("Failed to bootstrap sales channels:", err)
+	}
+
+	// Locations: ensure a default one and, on the first run, move existing stock into it
+	if n, err := inventory.BootstrapLocations(db.DB); err != nil {
+		log.Fatal("Failed to bootstrap stock locations:", err)
+	} else if n > 0 {
+		log.Printf("Moved stock of %d products into the default location", n)
+	}
+
+	// Opening balances: make a new movement ledger explain existing stock.
+	// Later drift is reported by GET /inventory/reconcile, never fixed here.
+	var opening []inventory.Discrepancy
+	if err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		opening, err = inventory.OpeningBalances(tx)
+		return err
+	}); err != nil {
+		log.Fatal("Failed to record opening balances:", err)
+	} else if len(opening) > 0 {
+		log.Printf("Recorded opening balance movements for %d products", len(opening))
+	}
 
 	// 2. Setup Router
 	r := chi.NewRouter()
@@ -31,7 +61,7 @@ func main() {
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins: []string{"*"}, // Adjust for production
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-User", "Idempotency-Key"},
 	}))
 
 	// 4. Routes
@@ -54,8 +84,14 @@ func main() {
 		r.Delete("/products/{id}", handlers.DeleteProductHandler)
 		r.Put("/products/{id}", handlers.UpdateProductHandler)
 		r.Put("/products/{id}/recrop", handlers.RecropHandler)
+		r.Get("/products/{id}/movements", handlers.GetProductMovementsHandler)
+		r.Post("/products/{id}/adjust", handlers.AdjustProductHandler)
+		r.Post("/products/sync", handlers.SyncProductHandler)
 
 		r.Post("/scan/item", handlers.ScanItemHandler)
+		r.Post("/scan/batch", handlers.BatchScanHandler)
+		r.Post("/scan/unscan", handlers.UnscanHandler)
+		r.Get("/scan/events", handlers.GetScanEventsHandler)
 
 		// Supplier Routes
 		r.Get("/suppliers", handlers.GetSuppliersHandler)
@@ -72,11 +108,81 @@ func main() {
 		r.Post("/ingest/trigger", handlers.TriggerProcessHandler)
 		r.Post("/ingest/clear", handlers.ClearProductsHandler)
 		r.Get("/sync/status", handlers.GetSyncStatusHandler)
+		r.Post("/sync/down", handlers.SyncDownHandler)
+		r.Get("/sync/runs", handlers.GetSyncRunsHandler)
+		r.Get("/sync/runs/{id}", handlers.GetSyncRunHandler)
+		r.Post("/sync/runs/{id}/retry", handlers.RetrySyncRunHandler)
+
+		// Sales Channels
+		r.Get("/channels", handlers.GetChannelsHandler)
+		r.Post("/channels", handlers.CreateChannelHandler)
+		r.Put("/channels/{id}", handlers.UpdateChannelHandler)
+		r.Post("/channels/{id}/webhook", handlers.ChannelWebhookHandler)
+		r.Get("/channels/orders", handlers.GetChannelOrdersHandler)
+		r.Post("/channels/orders/{id}/review", handlers.ReviewChannelOrderHandler)
+
+		// Locations
+		r.Get("/locations", handlers.GetLocationsHandler)
+		r.Post("/locations", handlers.CreateLocationHandler)
+		r.Put("/locations/{id}", handlers.UpdateLocationHandler)
+
+		// Transfers
+		r.Get("/transfers", handlers.GetTransfersHandler)
+		r.Post("/transfers", handlers.CreateTransferHandler)
+		r.Get("/transfers/{id}", handlers.GetTransferHandler)
+		r.Post("/transfers/{id}/pick", handlers.TransferPickHandler)
+		r.Post("/transfers/{id}/ship", handlers.ShipTransferHandler)
+		r.Post("/transfers/{id}/receive", handlers.TransferReceiveHandler)
+		r.Post("/transfers/{id}/complete", handlers.CompleteTransferHandler)
+		r.Post("/transfers/{id}/cancel", handlers.CancelTransferHandler)
 
 		// Inventory & Orders
 		r.Get("/inventory", handlers.GetInventoryHandler)
+		r.Get("/inventory/movements/export", handlers.ExportMovementsHandler)
+		r.Get("/inventory/reconcile", handlers.GetReconcileHandler)
+		r.Post("/inventory/reconcile", handlers.ReconcileHandler)
+		r.Post("/inventory/adjustments/upload", handlers.BulkAdjustHandler)
+		r.Get("/imports/reports/{id}", handlers.GetImportReportHandler)
 		r.Get("/orders", handlers.GetOrdersHandler)
 		r.Post("/orders", handlers.CreateOrderHandler)
+		r.Post("/orders/preview", handlers.PreviewOrderHandler)
+		r.Post("/orders/commit", handlers.CommitOrderHandler)
+		r.Get("/orders/{id}", handlers.GetOrderHandler)
+		r.Get("/orders/{id}/export", handlers.ExportOrderHandler)
+		r.Put("/orders/{id}", handlers.UpdateOrderHandler)
+		r.Post("/orders/{id}/lines", handlers.AddOrderLineHandler)
+		r.Put("/orders/{id}/lines/{lineId}", handlers.UpdateOrderLineHandler)
+		r.Delete("/orders/{id}/lines/{lineId}", handlers.DeleteOrderLineHandler)
+		r.Post("/orders/{id}/receiving", handlers.StartReceivingHandler)
+		r.Post("/orders/{id}/transition", handlers.TransitionOrderHandler)
+		r.Get("/orders/{id}/history", handlers.GetOrderHistoryHandler)
+		r.Get("/orders/{id}/revisions", handlers.GetOrderRevisionsHandler)
+		r.Get("/orders/{id}/revisions/{rev}", handlers.GetOrderRevisionHandler)
+		r.Get("/orders/{id}/discrepancies", handlers.GetOrderDiscrepanciesHandler)
+		r.Post("/orders/{id}/damaged", handlers.ReportDamagedHandler)
+		r.Post("/orders/{id}/claims", handlers.CreateClaimHandler)
+
+		// Supplier Claims
+		r.Get("/claims", handlers.GetClaimsHandler)
+		r.Get("/claims/{id}", handlers.GetClaimHandler)
+		r.Post("/claims/{id}/resolve", handlers.ResolveClaimHandler)
+
+		// Receiving Sessions
+		r.Get("/receiving", handlers.GetReceivingSessionsHandler)
+		r.Get("/receiving/{id}", handlers.GetReceivingSessionHandler)
+		r.Post("/receiving/{id}/scan", handlers.ReceivingScanHandler)
+		r.Put("/receiving/{id}/lines/{lineId}", handlers.UpdateReceivingLineHandler)
+		r.Post("/receiving/{id}/finalize", handlers.FinalizeReceivingHandler)
+		r.Post("/receiving/{id}/discard", handlers.DiscardReceivingHandler)
+
+		// Cycle Counts
+		r.Get("/counts", handlers.GetCountSessionsHandler)
+		r.Post("/counts", handlers.CreateCountSessionHandler)
+		r.Get("/counts/{id}", handlers.GetCountSessionHandler)
+		r.Post("/counts/{id}/scan", handlers.CountScanHandler)
+		r.Get("/counts/{id}/variance", handlers.GetCountVarianceHandler)
+		r.Post("/counts/{id}/approve", handlers.ApproveCountSessionHandler)
+		r.Post("/counts/{id}/cancel", handlers.CancelCountSessionHandler)
 	})
 
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
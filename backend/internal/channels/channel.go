@@ -0,0 +1,154 @@
+package channels
+
+import (
+	"backroom/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SaleLine is one sold product within a Sale
+type SaleLine struct {
+	SKU        string `json:"sku"`
+	ExternalID string `json:"external_id"` // Product ID in the channel, if known
+	Quantity   int    `json:"quantity"`
+}
+
+// Sale is an order placed in a channel
+type Sale struct {
+	OrderRef   string     `json:"order_ref"`
+	PlacedAt   time.Time  `json:"placed_at"`
+	ModifiedAt time.Time  `json:"modified_at"` // Last change in the channel; zero if unknown
+	Lines      []SaleLine `json:"lines"`
+}
+
+// ChangedAt is the time Sync Down keeps its high-water mark on: the last
+// modification when the channel reports one, the placement time otherwise
+func (s Sale) ChangedAt() time.Time {
+	if s.ModifiedAt.IsZero() {
+		return s.PlacedAt
+	}
+	return s.ModifiedAt
+}
+
+// ProductData is what the Sync Hub pushes for a product. ExternalID is empty
+// for products the channel does not know yet.
+type ProductData struct {
+	ExternalID string  `json:"external_id,omitempty"`
+	SKU        string  `json:"sku"`
+	Title      string  `json:"title,omitempty"`
+	Price      float64 `json:"price"`
+	Stock      int     `json:"stock"`
+	ImageURL   string  `json:"image_url,omitempty"`
+	Publish    bool    `json:"publish"`
+}
+
+// UpsertResult is the outcome of pushing one ProductData
+type UpsertResult struct {
+	ExternalID string
+	Existing   bool // A create matched a product the channel already had
+	Err        error
+}
+
+// Channel is a storefront or marketplace the Sync Hub talks to
+type Channel interface {
+	// ListSalesSince returns sale orders placed or changed after since. An
+	// order may be returned again on later calls; callers dedupe by OrderRef.
+	ListSalesSince(since time.Time) ([]Sale, error)
+	// UpsertProduct creates the product when ExternalID is empty; otherwise
+	// it only updates stock and price. Returns the channel's product ID.
+	UpsertProduct(p ProductData) (string, error)
+	// SetStock updates only the stock of a known product
+	SetStock(externalID string, qty int) error
+}
+
+// BatchUpserter is implemented by channels that can push many products per call
+type BatchUpserter interface {
+	UpsertProducts(items []ProductData) []UpsertResult
+}
+
+// UpsertAll pushes items using the channel's batch API when it has one
+func UpsertAll(ch Channel, items []ProductData) []UpsertResult {
+	if b, ok := ch.(BatchUpserter); ok {
+		return b.UpsertProducts(items)
+	}
+	results := make([]UpsertResult, len(items))
+	for i, item := range items {
+		id, err := ch.UpsertProduct(item)
+		results[i] = UpsertResult{ExternalID: id, Err: err}
+	}
+	return results
+}
+
+// ErrUnknownKind is returned by FromModel for unsupported channel kinds
+var ErrUnknownKind = errors.New("unknown channel kind")
+
+// FromModel builds the Channel implementation for a configured channel
+func FromModel(m models.SalesChannel) (Channel, error) {
+	switch m.Kind {
+	case models.ChannelKindWooCommerce:
+		if m.BaseURL == "" || m.APIKey == "" || m.APISecret == "" {
+			return nil, fmt.Errorf("channel %q: base_url, api_key and api_secret are required", m.Name)
+		}
+		return NewWooCommerce(m.BaseURL, m.APIKey, m.APISecret), nil
+	case models.ChannelKindWebhook:
+		if m.BaseURL == "" {
+			return nil, fmt.Errorf("channel %q: base_url is required", m.Name)
+		}
+		return NewWebhook(m.BaseURL, m.APIKey, m.Format), nil
+	}
+	return nil, fmt.Errorf("channel %q: %w %q", m.Name, ErrUnknownKind, m.Kind)
+}
+
+// Bootstrap seeds a WooCommerce channel from WOO_URL / WOO_CONSUMER_KEY /
+// WOO_CONSUMER_SECRET when none exists, and moves legacy products.woo_id
+// values and the old "woo_down" sync state onto that channel.
+func Bootstrap(db *gorm.DB) error {
+	var woo models.SalesChannel
+	err := db.Where("kind = ?", models.ChannelKindWooCommerce).Order("id asc").First(&woo).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		var legacy int64
+		if db.Migrator().HasColumn("products", "woo_id") {
+			db.Table("products").Where("woo_id IS NOT NULL").Count(&legacy)
+		}
+		if os.Getenv("WOO_URL") == "" && legacy == 0 {
+			return nil
+		}
+		woo = models.SalesChannel{
+			Name:      "WooCommerce",
+			Kind:      models.ChannelKindWooCommerce,
+			BaseURL:   os.Getenv("WOO_URL"),
+			APIKey:    os.Getenv("WOO_CONSUMER_KEY"),
+			APISecret: os.Getenv("WOO_CONSUMER_SECRET"),
+			Enabled:   os.Getenv("WOO_URL") != "",
+		}
+		if err := db.Create(&woo).Error; err != nil {
+			return err
+		}
+		log.Printf("Created sales channel %q from environment", woo.Name)
+	} else if err != nil {
+		return err
+	}
+
+	if db.Migrator().HasColumn("products", "woo_id") {
+		err := db.Exec(`
+			INSERT INTO product_channels (product_id, channel_id, external_id, created_at)
+			SELECT id, ?, woo_id::text, NOW() FROM products WHERE woo_id IS NOT NULL
+			ON CONFLICT DO NOTHING`, woo.ID).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.Model(&models.SyncState{}).Where("key = ?", "woo_down").
+		Update("key", SyncStateKey(woo.ID, models.SyncDirectionDown)).Error
+}
+
+// SyncStateKey is the SyncState key holding a channel's high-water mark
+func SyncStateKey(channelID uint, direction models.SyncDirection) string {
+	return fmt.Sprintf("channel:%d:%s", channelID, direction)
+}
@@ -0,0 +1,143 @@
+package channels
+
+import (
+	"backroom/internal/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventType is the kind of inbound order event
+type EventType string
+
+const (
+	OrderCreated   EventType = "order.created"
+	OrderCancelled EventType = "order.cancelled"
+	OrderCompleted EventType = "order.completed"
+)
+
+// OrderEvent is a storefront order notification, normalized across channels
+type OrderEvent struct {
+	Type EventType `json:"event"`
+	Sale
+}
+
+// WebhookParser is implemented by channels that can decode their own webhook
+// payloads. ok is false for deliveries that carry no order event (pings,
+// unrelated topics or statuses).
+type WebhookParser interface {
+	ParseOrderEvent(header http.Header, body []byte) (event OrderEvent, ok bool, err error)
+}
+
+// SignatureSettings returns the header and encoding used to verify a
+// channel's webhooks, applying the per-kind defaults
+func SignatureSettings(m models.SalesChannel) (header, encoding string) {
+	header, encoding = m.SignatureHeader, strings.ToLower(m.SignatureEncoding)
+	switch m.Kind {
+	case models.ChannelKindWooCommerce:
+		if header == "" {
+			header = "X-WC-Webhook-Signature"
+		}
+		if encoding == "" {
+			encoding = "base64"
+		}
+	default:
+		if header == "" {
+			header = "X-Signature"
+		}
+		if encoding == "" {
+			encoding = "hex"
+		}
+	}
+	return header, encoding
+}
+
+// VerifySignature checks an HMAC-SHA256 of body against the received signature
+func VerifySignature(secret, signature, encoding string, body []byte) bool {
+	if secret == "" || signature == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	var received []byte
+	var err error
+	signature = strings.TrimPrefix(strings.TrimSpace(signature), "sha256=")
+	if encoding == "hex" {
+		received, err = hex.DecodeString(signature)
+	} else {
+		received, err = base64.StdEncoding.DecodeString(signature)
+	}
+	return err == nil && hmac.Equal(expected, received)
+}
+
+// ParseOrderEvent maps WooCommerce order.created / order.updated /
+// order.deleted deliveries to order events based on the order status
+func (c *WooCommerce) ParseOrderEvent(header http.Header, body []byte) (OrderEvent, bool, error) {
+	topic := header.Get("X-WC-Webhook-Topic")
+	if !strings.HasPrefix(topic, "order.") {
+		return OrderEvent{}, false, nil // e.g. the ping sent when the webhook is created
+	}
+
+	var order struct {
+		ID             int    `json:"id"`
+		Status         string `json:"status"`
+		DateCreatedGMT string `json:"date_created_gmt"`
+		LineItems      []struct {
+			ProductID int    `json:"product_id"`
+			SKU       string `json:"sku"`
+			Quantity  int    `json:"quantity"`
+		} `json:"line_items"`
+	}
+	if err := json.Unmarshal(body, &order); err != nil {
+		return OrderEvent{}, false, fmt.Errorf("woocommerce webhook: %w", err)
+	}
+
+	event := OrderEvent{Sale: Sale{OrderRef: strconv.Itoa(order.ID)}}
+	switch {
+	case topic == "order.deleted" || order.Status == "cancelled" || order.Status == "refunded" || order.Status == "failed":
+		event.Type = OrderCancelled
+	case order.Status == "completed":
+		event.Type = OrderCompleted
+	case topic == "order.created" || order.Status == "pending" || order.Status == "processing" || order.Status == "on-hold":
+		event.Type = OrderCreated
+	default:
+		return OrderEvent{}, false, nil
+	}
+
+	event.PlacedAt, _ = time.ParseInLocation("2006-01-02T15:04:05", order.DateCreatedGMT, time.UTC)
+	for _, line := range order.LineItems {
+		sl := SaleLine{SKU: line.SKU, Quantity: line.Quantity}
+		if line.ProductID > 0 {
+			sl.ExternalID = strconv.Itoa(line.ProductID)
+		}
+		event.Lines = append(event.Lines, sl)
+	}
+	return event, true, nil
+}
+
+// ParseOrderEvent decodes the generic JSON envelope:
+// {"event": "order.created", "order_ref": "...", "placed_at": "...", "lines": [...]}
+func (c *Webhook) ParseOrderEvent(header http.Header, body []byte) (OrderEvent, bool, error) {
+	var event OrderEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return event, false, fmt.Errorf("webhook: %w", err)
+	}
+	switch event.Type {
+	case OrderCreated, OrderCancelled, OrderCompleted:
+	default:
+		return event, false, nil
+	}
+	if event.OrderRef == "" {
+		return event, false, fmt.Errorf("webhook: order_ref is required")
+	}
+	return event, true, nil
+}
@@ -0,0 +1,106 @@
+package channels
+
+import (
+	"backroom/internal/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"testing"
+)
+
+// TestVerifySignature checks both encodings, the "sha256=" prefix and
+// that an empty secret never verifies
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	sum := mac.Sum(nil)
+	b64, hx := base64.StdEncoding.EncodeToString(sum), hex.EncodeToString(sum)
+
+	tests := []struct {
+		name, secret, signature, encoding string
+		want                              bool
+	}{
+		{"base64", "s3cret", b64, "base64", true},
+		{"hex", "s3cret", hx, "hex", true},
+		{"hex with prefix", "s3cret", "sha256=" + hx, "hex", true},
+		{"wrong secret", "other", b64, "base64", false},
+		{"wrong encoding", "s3cret", hx, "base64", false},
+		{"no secret", "", b64, "base64", false},
+		{"no signature", "s3cret", "", "base64", false},
+	}
+	for _, tt := range tests {
+		if got := VerifySignature(tt.secret, tt.signature, tt.encoding, body); got != tt.want {
+			t.Errorf("%s: verified %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	header, encoding := SignatureSettings(models.SalesChannel{Kind: models.ChannelKindWooCommerce})
+	if header != "X-WC-Webhook-Signature" || encoding != "base64" {
+		t.Errorf("woocommerce defaults = %s, %s", header, encoding)
+	}
+	header, encoding = SignatureSettings(models.SalesChannel{SignatureHeader: "X-Hub", SignatureEncoding: "HEX"})
+	if header != "X-Hub" || encoding != "hex" {
+		t.Errorf("configured settings = %s, %s", header, encoding)
+	}
+}
+
+// TestWooCommerceParseOrderEvent maps webhook topics and order statuses to
+// order events
+func TestWooCommerceParseOrderEvent(t *testing.T) {
+	order := func(status string) []byte {
+		return []byte(`{"id": 42, "status": "` + status + `", "date_created_gmt": "2026-03-01T12:00:00",
+			"line_items": [{"product_id": 7, "sku": "A-1", "quantity": 2}, {"sku": "B-2", "quantity": 1}]}`)
+	}
+	tests := []struct {
+		topic, status string
+		want          EventType
+		ok            bool
+	}{
+		{"order.created", "pending", OrderCreated, true},
+		{"order.updated", "processing", OrderCreated, true},
+		{"order.updated", "completed", OrderCompleted, true},
+		{"order.updated", "refunded", OrderCancelled, true},
+		{"order.deleted", "processing", OrderCancelled, true},
+		{"order.updated", "checkout-draft", "", false},
+		{"action.woocommerce_ping", "", "", false},
+	}
+	woo := NewWooCommerce("http://store", "ck", "cs")
+	for _, tt := range tests {
+		header := http.Header{}
+		header.Set("X-WC-Webhook-Topic", tt.topic)
+		event, ok, err := woo.ParseOrderEvent(header, order(tt.status))
+		if err != nil || ok != tt.ok || event.Type != tt.want {
+			t.Errorf("%s/%s: %s, %v, %v; want %s, %v", tt.topic, tt.status, event.Type, ok, err, tt.want, tt.ok)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("X-WC-Webhook-Topic", "order.created")
+	event, _, _ := woo.ParseOrderEvent(header, order("pending"))
+	if event.OrderRef != "42" || event.PlacedAt.IsZero() || len(event.Lines) != 2 ||
+		event.Lines[0].ExternalID != "7" || event.Lines[1].ExternalID != "" || event.Lines[1].SKU != "B-2" {
+		t.Errorf("event = %+v", event)
+	}
+	if _, _, err := woo.ParseOrderEvent(header, []byte("not json")); err == nil {
+		t.Error("invalid body was accepted")
+	}
+}
+
+// TestWebhookParseOrderEvent checks the generic JSON envelope
+func TestWebhookParseOrderEvent(t *testing.T) {
+	c := NewWebhook("http://store", "token", "json")
+	event, ok, err := c.ParseOrderEvent(nil, []byte(`{"event": "order.completed", "order_ref": "R-1",
+		"lines": [{"sku": "A-1", "quantity": 3}]}`))
+	if err != nil || !ok || event.Type != OrderCompleted || event.OrderRef != "R-1" || event.Lines[0].Quantity != 3 {
+		t.Errorf("event = %+v, %v, %v", event, ok, err)
+	}
+	if _, ok, err := c.ParseOrderEvent(nil, []byte(`{"event": "order.refreshed", "order_ref": "R-1"}`)); ok || err != nil {
+		t.Errorf("unknown event: %v, %v", ok, err)
+	}
+	if _, _, err := c.ParseOrderEvent(nil, []byte(`{"event": "order.created"}`)); err == nil {
+		t.Error("event without order_ref was accepted")
+	}
+}
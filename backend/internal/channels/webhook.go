@@ -0,0 +1,186 @@
+package channels
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook is a generic channel for shops without a native integration. It
+// talks to three endpoints under BaseURL, in JSON or CSV:
+//
+//	GET  {base}/sales?since=RFC3339 -> sales (CSV: order_ref,placed_at,sku,external_id,quantity)
+//	POST {base}/products            <- ProductData, responds {"external_id": "..."} (CSV: echoes SKU)
+//	POST {base}/stock               <- {"external_id","quantity"}
+type Webhook struct {
+	BaseURL string
+	Token   string // Sent as a Bearer token when set
+	Format  string // "json" (default) | "csv"
+	HTTP    *http.Client
+}
+
+// NewWebhook builds a webhook channel; format defaults to JSON
+func NewWebhook(baseURL, token, format string) *Webhook {
+	format = strings.ToLower(format)
+	if format != "csv" {
+		format = "json"
+	}
+	return &Webhook{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		Format:  format,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *Webhook) ListSalesSince(since time.Time) ([]Sale, error) {
+	q := url.Values{}
+	q.Set("since", since.UTC().Format(time.RFC3339))
+	body, err := c.do(http.MethodGet, "/sales?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Format == "csv" {
+		return parseSalesCSV(body)
+	}
+	var sales []Sale
+	if err := json.Unmarshal(body, &sales); err != nil {
+		return nil, fmt.Errorf("webhook: decoding sales: %w", err)
+	}
+	return sales, nil
+}
+
+func (c *Webhook) UpsertProduct(p ProductData) (string, error) {
+	var payload []byte
+	if c.Format == "csv" {
+		payload = encodeCSV(
+			[]string{"external_id", "sku", "title", "price", "stock", "image_url", "publish"},
+			[]string{p.ExternalID, p.SKU, p.Title, strconv.FormatFloat(p.Price, 'f', 2, 64),
+				strconv.Itoa(p.Stock), p.ImageURL, strconv.FormatBool(p.Publish)},
+		)
+	} else {
+		payload, _ = json.Marshal(p)
+	}
+
+	body, err := c.do(http.MethodPost, "/products", payload)
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		ExternalID string `json:"external_id"`
+	}
+	if json.Unmarshal(body, &resp) == nil && resp.ExternalID != "" {
+		return resp.ExternalID, nil
+	}
+	if p.ExternalID != "" {
+		return p.ExternalID, nil
+	}
+	return p.SKU, nil // Endpoints without their own IDs are keyed on SKU
+}
+
+func (c *Webhook) SetStock(externalID string, qty int) error {
+	var payload []byte
+	if c.Format == "csv" {
+		payload = encodeCSV([]string{"external_id", "quantity"}, []string{externalID, strconv.Itoa(qty)})
+	} else {
+		payload, _ = json.Marshal(map[string]interface{}{"external_id": externalID, "quantity": qty})
+	}
+	_, err := c.do(http.MethodPost, "/stock", payload)
+	return err
+}
+
+func (c *Webhook) do(method, path string, payload []byte) ([]byte, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	contentType := "application/json"
+	if c.Format == "csv" {
+		contentType = "text/csv"
+	}
+	req.Header.Set("Accept", contentType)
+	if payload != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("webhook: %s %s: %d %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// parseSalesCSV groups rows of order_ref,placed_at,sku,external_id,quantity into sales
+func parseSalesCSV(data []byte) ([]Sale, error) {
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("webhook: reading sales CSV: %w", err)
+	}
+
+	var sales []Sale
+	index := make(map[string]int)
+	for i, row := range rows {
+		if i == 0 && len(row) > 0 && strings.EqualFold(strings.TrimSpace(row[0]), "order_ref") {
+			continue // Header
+		}
+		if len(row) < 5 {
+			return nil, fmt.Errorf("webhook: sales CSV row %d has %d columns, want 5", i+1, len(row))
+		}
+		placedAt, err := time.Parse(time.RFC3339, strings.TrimSpace(row[1]))
+		if err != nil {
+			return nil, fmt.Errorf("webhook: sales CSV row %d: invalid placed_at", i+1)
+		}
+		qty, err := strconv.Atoi(strings.TrimSpace(row[4]))
+		if err != nil {
+			return nil, fmt.Errorf("webhook: sales CSV row %d: invalid quantity", i+1)
+		}
+
+		ref := strings.TrimSpace(row[0])
+		n, ok := index[ref]
+		if !ok {
+			n = len(sales)
+			index[ref] = n
+			sales = append(sales, Sale{OrderRef: ref, PlacedAt: placedAt})
+		}
+		sales[n].Lines = append(sales[n].Lines, SaleLine{
+			SKU:        strings.TrimSpace(row[2]),
+			ExternalID: strings.TrimSpace(row[3]),
+			Quantity:   qty,
+		})
+	}
+	return sales, nil
+}
+
+func encodeCSV(header, row []string) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(header)
+	w.Write(row)
+	w.Flush()
+	return buf.Bytes()
+}
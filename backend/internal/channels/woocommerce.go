@@ -0,0 +1,198 @@
+package channels
+
+import (
+	"backroom/internal/woo"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WooCommerce is the Channel implementation backed by the wc/v3 REST API
+type WooCommerce struct {
+	Client *woo.Client
+}
+
+// NewWooCommerce builds a WooCommerce channel for the given store
+func NewWooCommerce(baseURL, key, secret string) *WooCommerce {
+	return &WooCommerce{Client: woo.NewClient(baseURL, key, secret)}
+}
+
+func (c *WooCommerce) ListSalesSince(since time.Time) ([]Sale, error) {
+	orders, err := c.Client.ListOrdersModifiedSince(since)
+	if err != nil {
+		return nil, err
+	}
+
+	sales := make([]Sale, 0, len(orders))
+	for _, o := range orders {
+		placedAt, err := o.CreatedAt()
+		if err != nil {
+			return nil, fmt.Errorf("order %d has an invalid date_created_gmt", o.ID)
+		}
+		modifiedAt, err := o.ModifiedAt()
+		if err != nil {
+			return nil, fmt.Errorf("order %d has an invalid date_modified_gmt", o.ID)
+		}
+		sale := Sale{OrderRef: strconv.Itoa(o.ID), PlacedAt: placedAt, ModifiedAt: modifiedAt}
+		for _, line := range o.LineItems {
+			sl := SaleLine{SKU: line.SKU, Quantity: line.Quantity}
+			if line.ProductID > 0 {
+				sl.ExternalID = strconv.Itoa(line.ProductID)
+			}
+			sale.Lines = append(sale.Lines, sl)
+		}
+		sales = append(sales, sale)
+	}
+	return sales, nil
+}
+
+func (c *WooCommerce) UpsertProduct(p ProductData) (string, error) {
+	result := c.UpsertProducts([]ProductData{p})[0]
+	return result.ExternalID, result.Err
+}
+
+func (c *WooCommerce) SetStock(externalID string, qty int) error {
+	id, err := strconv.Atoi(externalID)
+	if err != nil {
+		return fmt.Errorf("invalid WooCommerce product id %q", externalID)
+	}
+	manage := true
+	_, err = c.Client.UpdateProduct(id, woo.ProductInput{
+		ManageStock:   &manage,
+		StockQuantity: &qty,
+		StockStatus:   stockStatus(qty),
+	})
+	return err
+}
+
+// UpsertProducts sends creates and updates through /products/batch, chunked to the API limit
+func (c *WooCommerce) UpsertProducts(items []ProductData) []UpsertResult {
+	results := make([]UpsertResult, len(items))
+
+	for start := 0; start < len(items); start += woo.MaxBatchSize {
+		end := start + woo.MaxBatchSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		existing, lookupErr := c.existingProducts(items[start:end])
+
+		var batch woo.BatchRequest
+		var creates, updates []int
+		for i := start; i < end; i++ {
+			item := items[i]
+			if item.ExternalID == "" {
+				if lookupErr != nil {
+					results[i].Err = lookupErr
+					continue
+				}
+				// Created earlier but its ID was never stored: update it instead of duplicating it
+				if id, ok := existing[item.SKU]; ok {
+					item.ExternalID = strconv.Itoa(id)
+					results[i].Existing = true
+				}
+			}
+			input, err := productInput(item)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			if input.ID == 0 {
+				batch.Create = append(batch.Create, input)
+				creates = append(creates, i)
+			} else {
+				batch.Update = append(batch.Update, input)
+				updates = append(updates, i)
+			}
+		}
+		if len(creates)+len(updates) == 0 {
+			continue
+		}
+
+		resp, err := c.Client.BatchProducts(batch)
+		if err != nil {
+			for _, i := range append(creates, updates...) {
+				results[i].Err = err
+			}
+			continue
+		}
+		collectBatch(results, creates, resp.Create)
+		collectBatch(results, updates, resp.Update)
+	}
+	return results
+}
+
+// existingProducts looks up the SKUs of the items that would be created
+func (c *WooCommerce) existingProducts(items []ProductData) (map[string]int, error) {
+	var skus []string
+	for _, item := range items {
+		if item.ExternalID == "" && item.SKU != "" && !strings.Contains(item.SKU, ",") {
+			skus = append(skus, item.SKU)
+		}
+	}
+	found, err := c.Client.FindProductIDsBySKU(skus)
+	if err != nil {
+		return nil, fmt.Errorf("looking up existing SKUs: %w", err)
+	}
+	return found, nil
+}
+
+// productInput maps ProductData to a create payload (title, price, image,
+// status) or, for known products, to a stock-and-price-only update
+func productInput(p ProductData) (woo.ProductInput, error) {
+	manage := true
+	qty := p.Stock
+	input := woo.ProductInput{
+		RegularPrice:  strconv.FormatFloat(p.Price, 'f', 2, 64),
+		ManageStock:   &manage,
+		StockQuantity: &qty,
+		StockStatus:   stockStatus(qty),
+	}
+
+	if p.ExternalID != "" {
+		id, err := strconv.Atoi(p.ExternalID)
+		if err != nil {
+			return input, fmt.Errorf("invalid WooCommerce product id %q", p.ExternalID)
+		}
+		input.ID = id
+		return input, nil
+	}
+
+	input.Name = p.Title
+	input.Type = "simple"
+	input.SKU = p.SKU
+	input.Status = "draft"
+	if p.Publish {
+		input.Status = "publish"
+	}
+	if p.ImageURL != "" {
+		input.Images = []woo.Image{{Src: p.ImageURL}}
+	}
+	return input, nil
+}
+
+func collectBatch(results []UpsertResult, indexes []int, remote []woo.BatchItemResult) {
+	for n, i := range indexes {
+		if n >= len(remote) {
+			results[i].Err = fmt.Errorf("missing from WooCommerce response")
+			continue
+		}
+		if remote[n].Error != nil {
+			results[i].Err = fmt.Errorf("%s: %s", remote[n].Error.Code, remote[n].Error.Message)
+			continue
+		}
+		if remote[n].ID == 0 {
+			results[i].Err = fmt.Errorf("WooCommerce returned no ID")
+			continue
+		}
+		results[i].ExternalID = strconv.Itoa(remote[n].ID)
+	}
+}
+
+func stockStatus(qty int) string {
+	if qty <= 0 {
+		return "outofstock"
+	}
+	return "instock"
+}
@@ -0,0 +1,117 @@
+package channels
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestWooCommerceListSalesSince runs ListSalesSince against a fake store
+// and checks the modification filter, pagination and timestamp mapping
+func TestWooCommerceListSalesSince(t *testing.T) {
+	since := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	pages := [][]map[string]interface{}{
+		{{
+			"id": 10, "status": "processing",
+			"date_created_gmt": "2026-03-01T11:00:00", "date_modified_gmt": "2026-03-01T12:00:01",
+			"line_items": []map[string]interface{}{{"product_id": 7, "sku": "A-1", "quantity": 2}},
+		}},
+		{{
+			"id": 11, "status": "completed",
+			"date_created_gmt": "2026-03-01T12:00:00",
+			"line_items":       []map[string]interface{}{{"sku": "B-2", "quantity": 1}},
+		}},
+	}
+
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/wp-json/wc/v3/orders" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("after") != "" {
+			t.Errorf("unexpected created-date filter after=%s", q.Get("after"))
+		}
+		seen = append(seen, q.Get("modified_after"))
+		page, _ := strconv.Atoi(q.Get("page"))
+		w.Header().Set("X-WP-TotalPages", strconv.Itoa(len(pages)))
+		json.NewEncoder(w).Encode(pages[page-1])
+	}))
+	defer srv.Close()
+
+	sales, err := NewWooCommerce(srv.URL, "ck", "cs").ListSalesSince(since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 || seen[0] != "2026-03-01T12:00:00" {
+		t.Fatalf("modified_after per page = %v", seen)
+	}
+	if len(sales) != 2 {
+		t.Fatalf("got %d sales, want 2", len(sales))
+	}
+
+	first := sales[0]
+	if first.OrderRef != "10" || !first.ChangedAt().Equal(since.Add(time.Second)) {
+		t.Errorf("first sale = %+v", first)
+	}
+	if len(first.Lines) != 1 || first.Lines[0].ExternalID != "7" || first.Lines[0].Quantity != 2 {
+		t.Errorf("first sale lines = %+v", first.Lines)
+	}
+	// Never modified: the creation time stands in
+	if got := sales[1].ChangedAt(); !got.Equal(since) {
+		t.Errorf("second sale changed at %v, want %v", got, since)
+	}
+}
+
+// TestWooCommerceUpsertMatchesSKU checks that a product the store already
+// has under the same SKU is updated and linked instead of created twice, and
+// that new published products are not sent as drafts
+func TestWooCommerceUpsertMatchesSKU(t *testing.T) {
+	var batch struct {
+		Create []map[string]interface{} `json:"create"`
+		Update []map[string]interface{} `json:"update"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/wp-json/wc/v3/products":
+			if got := r.URL.Query().Get("sku"); got != "OLD-1,NEW-2" {
+				t.Errorf("sku lookup = %q", got)
+			}
+			json.NewEncoder(w).Encode([]map[string]interface{}{{"id": 41, "sku": "OLD-1"}})
+		case r.Method == http.MethodPost && r.URL.Path == "/wp-json/wc/v3/products/batch":
+			json.NewDecoder(r.Body).Decode(&batch)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"create": []map[string]interface{}{{"id": 42, "sku": "NEW-2"}},
+				"update": []map[string]interface{}{{"id": 41, "sku": "OLD-1"}},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	results := NewWooCommerce(srv.URL, "ck", "cs").UpsertProducts([]ProductData{
+		{SKU: "OLD-1", Title: "Old", Price: 10, Stock: 3, Publish: true},
+		{SKU: "NEW-2", Title: "New", Price: 12, Stock: 1, Publish: true},
+	})
+
+	if results[0].Err != nil || results[0].ExternalID != "41" || !results[0].Existing {
+		t.Errorf("existing SKU result = %+v", results[0])
+	}
+	if results[1].Err != nil || results[1].ExternalID != "42" || results[1].Existing {
+		t.Errorf("new SKU result = %+v", results[1])
+	}
+	if len(batch.Create) != 1 || len(batch.Update) != 1 {
+		t.Fatalf("batch sent %d creates and %d updates, want 1 and 1", len(batch.Create), len(batch.Update))
+	}
+	if batch.Create[0]["status"] != "publish" {
+		t.Errorf("create status = %v, want publish", batch.Create[0]["status"])
+	}
+	if id, _ := batch.Update[0]["id"].(float64); id != 41 {
+		t.Errorf("update id = %v, want 41", batch.Update[0]["id"])
+	}
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"backroom/internal/db"
+	"backroom/internal/inventory"
+	"backroom/internal/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// AdjustProductHandler corrects the stock of one product.
+// Body: {"delta": -2} or {"target": 10}, plus "reason_code" and optional
+// "note", "location_id" and "bin" (default location when omitted).
+func AdjustProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	var payload struct {
+		Delta      *int   `json:"delta"`
+		Target     *int   `json:"target"`
+		ReasonCode string `json:"reason_code"`
+		Note       string `json:"note"`
+		LocationID *uint  `json:"location_id"`
+		Bin        string `json:"bin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	adj := inventory.Adjustment{
+		ProductID:  id,
+		LocationID: payload.LocationID,
+		Bin:        payload.Bin,
+		Delta:      payload.Delta,
+		Target:     payload.Target,
+		ReasonCode: payload.ReasonCode,
+		Note:       payload.Note,
+		User:       requestUser(r),
+		Ref:        inventory.Ref{Type: models.RefUser},
+	}
+	if err := adj.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if payload.LocationID != nil {
+		if _, err := inventory.ResolveLocation(db.DB, payload.LocationID); err != nil {
+			http.Error(w, "Invalid location", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var movement models.InventoryMovement
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		movement, err = inventory.Adjust(tx, adj)
+		return err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, inventory.ErrAdjustmentSign) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to adjust stock: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var product models.Product
+	db.DB.First(&product, id)
+	response := map[string]interface{}{"product": product, "movement": nil}
+	if movement.ID != 0 {
+		response["movement"] = movement
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// BulkAdjustHandler applies adjustments from an uploaded sheet. The first row
+// is a header with the columns SKU (or Barcode), Delta or Target, Reason,
+// Note and optionally Location (code or name) and Bin. Every row is
+// validated first and the sheet is applied in a single transaction, so any
+// invalid row rejects the whole upload.
+func BulkAdjustHandler(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Invalid file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	f, err := excelize.OpenReader(file)
+	if err != nil {
+		http.Error(w, "Failed to read Excel", http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+
+	rows, err := f.GetRows(f.GetSheetName(0))
+	if err != nil || len(rows) == 0 {
+		http.Error(w, "Failed to get rows", http.StatusBadRequest)
+		return
+	}
+
+	// Locate columns by header name
+	cols := map[string]int{"code": -1, "delta": -1, "target": -1, "reason": -1, "note": -1, "location": -1, "bin": -1}
+	for i, h := range rows[0] {
+		switch strings.ToLower(strings.TrimSpace(h)) {
+		case "sku", "barcode", "code":
+			if cols["code"] < 0 {
+				cols["code"] = i
+			}
+		case "delta", "qty", "quantity":
+			cols["delta"] = i
+		case "target", "counted", "count":
+			cols["target"] = i
+		case "reason", "reason_code":
+			cols["reason"] = i
+		case "note", "notes":
+			cols["note"] = i
+		case "location", "location_code":
+			cols["location"] = i
+		case "bin":
+			cols["bin"] = i
+		}
+	}
+	if cols["code"] < 0 || (cols["delta"] < 0 && cols["target"] < 0) || cols["reason"] < 0 {
+		http.Error(w, "Sheet needs SKU, Delta or Target, and Reason columns", http.StatusBadRequest)
+		return
+	}
+	cell := func(row []string, key string) string {
+		if i := cols[key]; i >= 0 && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	type rowResult struct {
+		Row      int                       `json:"row"` // 1-based, as shown in Excel
+		Code     string                    `json:"code"`
+		Error    string                    `json:"error,omitempty"`
+		Movement *models.InventoryMovement `json:"movement,omitempty"`
+	}
+	var results []rowResult
+	var adjustments []inventory.Adjustment
+	var resultIdx []int
+	failed := 0
+	user := requestUser(r)
+
+	for i := 1; i < len(rows); i++ {
+		row := rows[i]
+		code := cell(row, "code")
+		if code == "" {
+			continue
+		}
+		res := rowResult{Row: i + 1, Code: code}
+
+		adj := inventory.Adjustment{
+			ReasonCode: cell(row, "reason"),
+			Note:       cell(row, "note"),
+			Bin:        cell(row, "bin"),
+			User:       user,
+			Ref:        inventory.Ref{Type: models.RefUser, ID: "bulk"},
+		}
+		if v := cell(row, "delta"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				adj.Delta = &n
+			} else {
+				res.Error = "Delta is not a whole number"
+			}
+		}
+		if v := cell(row, "target"); v != "" && res.Error == "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				adj.Target = &n
+			} else {
+				res.Error = "Target is not a whole number"
+			}
+		}
+		if res.Error == "" {
+			if err := adj.Validate(); err != nil {
+				res.Error = err.Error()
+			}
+		}
+		if v := cell(row, "location"); v != "" && res.Error == "" {
+			if loc, err := findLocation(db.DB, v); err == nil {
+				adj.LocationID = &loc.ID
+			} else {
+				res.Error = "Unknown location " + v
+			}
+		}
+		if res.Error == "" {
+			product, err := findProductByCode(db.DB, code)
+			if err != nil {
+				res.Error = "Product not found"
+			} else {
+				adj.ProductID = product.ID
+			}
+		}
+
+		if res.Error != "" {
+			failed++
+		} else {
+			adjustments = append(adjustments, adj)
+			resultIdx = append(resultIdx, len(results))
+		}
+		results = append(results, res)
+	}
+
+	if failed > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"applied": 0,
+			"failed":  failed,
+			"rows":    results,
+		})
+		return
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		for n, adj := range adjustments {
+			movement, err := inventory.Adjust(tx, adj)
+			if errors.Is(err, inventory.ErrAdjustmentSign) {
+				// A target that moves stock against its reason
+				results[resultIdx[n]].Error = err.Error()
+			}
+			if err != nil {
+				return err
+			}
+			if movement.ID != 0 {
+				results[resultIdx[n]].Movement = &movement
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, inventory.ErrAdjustmentSign) {
+		for i := range results {
+			results[i].Movement = nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"applied": 0,
+			"failed":  1,
+			"rows":    results,
+		})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to apply adjustments: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"applied": len(adjustments),
+		"failed":  0,
+		"rows":    results,
+	})
+}
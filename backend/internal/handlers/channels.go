@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"backroom/internal/channels"
+	"backroom/internal/db"
+	"backroom/internal/inventory"
+	"backroom/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errOrderNotInReview = errors.New("channel order is not awaiting cancellation review")
+
+// GetChannelsHandler - List configured sales channels
+func GetChannelsHandler(w http.ResponseWriter, r *http.Request) {
+	var list []models.SalesChannel
+	db.DB.Order("id asc").Find(&list)
+	for i := range list {
+		list[i].APISecret = ""
+		list[i].WebhookSecret = ""
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// CreateChannelHandler - Register a new sales channel
+func CreateChannelHandler(w http.ResponseWriter, r *http.Request) {
+	var channel models.SalesChannel
+	if err := json.NewDecoder(r.Body).Decode(&channel); err != nil {
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+	channel.ID = 0
+	channel.Kind = models.ChannelKind(strings.ToUpper(string(channel.Kind)))
+	if _, err := channels.FromModel(channel); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateChannelLocation(channel); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var count int64
+	db.DB.Model(&models.SalesChannel{}).Where("name = ?", channel.Name).Count(&count)
+	if count > 0 {
+		http.Error(w, "Channel with this name already exists", http.StatusConflict)
+		return
+	}
+
+	if err := db.DB.Create(&channel).Error; err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	channel.APISecret = ""
+	channel.WebhookSecret = ""
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(channel)
+}
+
+// UpdateChannelHandler - Update a channel; empty secrets keep the stored ones
+func UpdateChannelHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var channel models.SalesChannel
+	if err := db.DB.First(&channel, id).Error; err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	var updateData models.SalesChannel
+	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+
+	channel.Name = updateData.Name
+	channel.Kind = models.ChannelKind(strings.ToUpper(string(updateData.Kind)))
+	channel.BaseURL = updateData.BaseURL
+	channel.APIKey = updateData.APIKey
+	if updateData.APISecret != "" {
+		channel.APISecret = updateData.APISecret
+	}
+	if updateData.WebhookSecret != "" {
+		channel.WebhookSecret = updateData.WebhookSecret
+	}
+	channel.SignatureHeader = updateData.SignatureHeader
+	channel.SignatureEncoding = updateData.SignatureEncoding
+	channel.Format = updateData.Format
+	channel.LocationID = updateData.LocationID
+	channel.Enabled = updateData.Enabled
+
+	if _, err := channels.FromModel(channel); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateChannelLocation(channel); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db.DB.Save(&channel)
+	channel.APISecret = ""
+	channel.WebhookSecret = ""
+	json.NewEncoder(w).Encode(channel)
+}
+
+// validateChannelLocation checks that a channel's selling location exists
+func validateChannelLocation(channel models.SalesChannel) error {
+	if channel.LocationID == nil {
+		return nil
+	}
+	if _, err := inventory.ResolveLocation(db.DB, channel.LocationID); err != nil {
+		return fmt.Errorf("invalid location_id: %w", err)
+	}
+	return nil
+}
+
+// loadSyncChannels returns the enabled channels, or only channelID when given
+func loadSyncChannels(channelID string) ([]models.SalesChannel, error) {
+	var list []models.SalesChannel
+	query := db.DB.Where("enabled = ?", true).Order("id asc")
+	if channelID != "" {
+		query = query.Where("id = ?", channelID)
+	}
+	if err := query.Find(&list).Error; err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, errors.New("no enabled sales channels configured")
+	}
+	return list, nil
+}
+
+// GetChannelOrdersHandler lists storefront orders, newest first.
+// Filters: ?status= (e.g. CANCEL_REVIEW), ?channel_id=, ?limit= (default 100).
+func GetChannelOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := db.DB.Order("updated_at desc")
+	if status := q.Get("status"); status != "" {
+		query = query.Where("status = ?", strings.ToUpper(status))
+	}
+	if channelID := q.Get("channel_id"); channelID != "" {
+		query = query.Where("channel_id = ?", channelID)
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+
+	var list []models.ChannelOrder
+	if err := query.Limit(limit).Find(&list).Error; err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// ReviewChannelOrderHandler settles an order cancelled after fulfilment.
+// Body: {"action": "restock" | "keep", "note"}. Restock returns its units to
+// stock as RETURN movements; keep leaves them sold.
+func ReviewChannelOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	action := strings.ToLower(payload.Action)
+	if action != "restock" && action != "keep" {
+		http.Error(w, "action must be restock or keep", http.StatusBadRequest)
+		return
+	}
+	user := requestUser(r)
+
+	var order models.ChannelOrder
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, chi.URLParam(r, "id")).Error; err != nil {
+			return err
+		}
+		if order.Status != models.ChannelOrderCancelReview {
+			return errOrderNotInReview
+		}
+		if action == "keep" {
+			return tx.Model(&order).Update("status", models.ChannelOrderCancelKept).Error
+		}
+
+		var channel models.SalesChannel
+		if err := tx.First(&channel, order.ChannelID).Error; err != nil {
+			return err
+		}
+		ref := inventory.Ref{Type: models.RefChannelOrder, ID: fmt.Sprintf("%d:%s", order.ChannelID, order.ExternalRef)}
+		for _, line := range orderLines(order) {
+			if _, err := inventory.Apply(tx, inventory.Change{
+				ProductID:  line.ProductID,
+				Delta:      line.Quantity,
+				LocationID: channel.LocationID,
+				Reason:     models.MovementReturn,
+				Ref:        ref,
+				User:       user,
+				Note:       payload.Note,
+			}); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&order).Update("status", models.ChannelOrderReturned).Error
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Channel order not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errOrderNotInReview):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to review order: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(order)
+}
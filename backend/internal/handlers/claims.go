@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"backroom/internal/db"
+	"backroom/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errClaimResolution = errors.New("resolution must be CREDIT, REPLACEMENT or WRITTEN_OFF")
+	errPONotClaimable  = errors.New("purchase order must be received or closed before raising a claim")
+	errClaimDuplicate  = errors.New("an open claim already covers these lines")
+	errNothingToClaim  = errors.New("no discrepancies to claim")
+)
+
+// validResolution normalizes and checks a claim resolution
+func validResolution(s string) (models.ClaimResolution, bool) {
+	res := models.ClaimResolution(strings.ToUpper(strings.TrimSpace(s)))
+	switch res {
+	case models.ClaimCredit, models.ClaimReplacement, models.ClaimWrittenOff:
+		return res, true
+	}
+	return res, false
+}
+
+// CreateClaimHandler raises a supplier claim for a PO. Body: {"note",
+// "types": ["SHORT", "DAMAGED"]} claims those lines of the discrepancy report
+// (SHORT and DAMAGED by default); or {"lines": [{"type", "sku", "qty"}]}
+// claims an explicit list. The PO must be RECEIVED or CLOSED, and a line
+// (type and SKU) still unresolved on an open claim of the PO cannot be
+// claimed again.
+func CreateClaimHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Note  string             `json:"note"`
+		Types []string           `json:"types"`
+		Lines []models.ClaimLine `json:"lines"`
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
+			return
+		}
+	}
+
+	claim := models.SupplierClaim{
+		Status:    models.ClaimOpen,
+		Note:      payload.Note,
+		CreatedBy: requestUser(r),
+	}
+	for _, l := range payload.Lines {
+		if l.SKU == "" || l.Qty <= 0 {
+			http.Error(w, "Every line needs a sku and a positive qty", http.StatusBadRequest)
+			return
+		}
+		lineType := models.DiscrepancyType(strings.ToUpper(string(l.Type)))
+		switch lineType {
+		case models.DiscrepancyShort, models.DiscrepancyOver, models.DiscrepancyUnexpected, models.DiscrepancyDamaged:
+		default:
+			http.Error(w, "Line type must be SHORT, OVER, UNEXPECTED or DAMAGED", http.StatusBadRequest)
+			return
+		}
+		claim.Lines = append(claim.Lines, models.ClaimLine{
+			Type: lineType,
+			SKU:  l.SKU,
+			Qty:  l.Qty,
+			Note: l.Note,
+		})
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		// The PO lock serializes claims on it, so two cannot claim the same lines
+		var po models.PurchaseOrder
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&po, chi.URLParam(r, "id")).Error; err != nil {
+			return err
+		}
+		if po.Status != models.POStatusReceived && po.Status != models.POStatusClosed {
+			return fmt.Errorf("purchase order is %s: %w", po.Status, errPONotClaimable)
+		}
+		claim.POID = po.ID
+		claim.SupplierID = po.SupplierID
+		claim.SupplierName = po.SupplierName
+
+		if len(payload.Lines) == 0 {
+			types := map[models.DiscrepancyType]bool{models.DiscrepancyShort: true, models.DiscrepancyDamaged: true}
+			if len(payload.Types) > 0 {
+				types = map[models.DiscrepancyType]bool{}
+				for _, t := range payload.Types {
+					types[models.DiscrepancyType(strings.ToUpper(t))] = true
+				}
+			}
+			report, err := poDiscrepancies(tx, po)
+			if err != nil {
+				return err
+			}
+			for _, line := range report {
+				if types[line.Type] {
+					claim.Lines = append(claim.Lines, models.ClaimLine{Type: line.Type, SKU: line.SKU, Qty: line.Qty})
+				}
+			}
+		}
+		if len(claim.Lines) == 0 {
+			return errNothingToClaim
+		}
+
+		var open []models.ClaimLine
+		if err := tx.Joins("JOIN supplier_claims ON supplier_claims.id = claim_lines.claim_id").
+			Where("supplier_claims.po_id = ? AND supplier_claims.status = ? AND claim_lines.resolution = ''", po.ID, models.ClaimOpen).
+			Find(&open).Error; err != nil {
+			return err
+		}
+		var claimed []string
+		for _, line := range claim.Lines {
+			for _, o := range open {
+				if o.Type == line.Type && o.SKU == line.SKU {
+					claimed = append(claimed, fmt.Sprintf("%s %s (claim %d)", line.Type, line.SKU, o.ClaimID))
+					break
+				}
+			}
+		}
+		if len(claimed) > 0 {
+			return fmt.Errorf("%w: %s", errClaimDuplicate, strings.Join(claimed, ", "))
+		}
+		return tx.Create(&claim).Error
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errNothingToClaim):
+		http.Error(w, "No discrepancies to claim", http.StatusBadRequest)
+		return
+	case errors.Is(err, errPONotClaimable), errors.Is(err, errClaimDuplicate):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to create claim: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(claim)
+}
+
+// GetClaimsHandler lists claims, newest first; ?status=, ?po_id= and ?supplier_id= filter
+func GetClaimsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := db.DB.Preload("Lines").Order("created_at desc")
+	if v := q.Get("status"); v != "" {
+		query = query.Where("status = ?", strings.ToUpper(v))
+	}
+	if v := q.Get("po_id"); v != "" {
+		query = query.Where("po_id = ?", v)
+	}
+	if v := q.Get("supplier_id"); v != "" {
+		query = query.Where("supplier_id = ?", v)
+	}
+
+	var claims []models.SupplierClaim
+	if err := query.Find(&claims).Error; err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(claims)
+}
+
+// GetClaimHandler returns one claim with its lines
+func GetClaimHandler(w http.ResponseWriter, r *http.Request) {
+	var claim models.SupplierClaim
+	if err := db.DB.Preload("Lines").First(&claim, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Claim not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(claim)
+}
+
+// ResolveClaimHandler records how claim lines were settled.
+// Body: {"lines": [{"id", "resolution", "credit_amount", "note"}]} per line,
+// or {"resolution", "note"} for every line still unresolved. The claim is
+// RESOLVED once all its lines are.
+func ResolveClaimHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Resolution string `json:"resolution"`
+		Note       string `json:"note"`
+		Lines      []struct {
+			ID           uint    `json:"id"`
+			Resolution   string  `json:"resolution"`
+			CreditAmount float64 `json:"credit_amount"`
+			Note         string  `json:"note"`
+		} `json:"lines"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	user := requestUser(r)
+
+	var claim models.SupplierClaim
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&claim, chi.URLParam(r, "id")).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("claim_id = ?", claim.ID).Order("id").Find(&claim.Lines).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		resolve := func(line *models.ClaimLine, resolution string, credit float64, note string) error {
+			res, ok := validResolution(resolution)
+			if !ok {
+				return errClaimResolution
+			}
+			line.Resolution = res
+			line.CreditAmount = 0
+			if res == models.ClaimCredit {
+				line.CreditAmount = credit
+			}
+			if note != "" {
+				line.Note = note
+			}
+			line.ResolvedBy = user
+			line.ResolvedAt = &now
+			return tx.Save(line).Error
+		}
+
+		if len(payload.Lines) > 0 {
+			for _, l := range payload.Lines {
+				found := false
+				for i := range claim.Lines {
+					if claim.Lines[i].ID == l.ID {
+						if err := resolve(&claim.Lines[i], l.Resolution, l.CreditAmount, l.Note); err != nil {
+							return err
+						}
+						found = true
+					}
+				}
+				if !found {
+					return gorm.ErrRecordNotFound
+				}
+			}
+		} else {
+			for i := range claim.Lines {
+				if claim.Lines[i].Resolution != "" {
+					continue
+				}
+				if err := resolve(&claim.Lines[i], payload.Resolution, 0, payload.Note); err != nil {
+					return err
+				}
+			}
+		}
+
+		claim.CreditTotal = 0
+		claim.Status = models.ClaimResolved
+		for _, line := range claim.Lines {
+			claim.CreditTotal += line.CreditAmount
+			if line.Resolution == "" {
+				claim.Status = models.ClaimOpen
+			}
+		}
+		claim.ResolvedAt = nil
+		if claim.Status == models.ClaimResolved {
+			claim.ResolvedAt = &now
+		}
+		return tx.Model(&claim).Updates(map[string]interface{}{
+			"status":       claim.Status,
+			"credit_total": claim.CreditTotal,
+			"resolved_at":  claim.ResolvedAt,
+		}).Error
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Claim or line not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errClaimResolution):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Failed to resolve claim: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(claim)
+}
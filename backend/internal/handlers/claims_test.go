@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"backroom/internal/models"
+	"testing"
+)
+
+// TestValidResolution checks that resolutions are normalized before they are
+// checked
+func TestValidResolution(t *testing.T) {
+	tests := []struct {
+		in   string
+		want models.ClaimResolution
+		ok   bool
+	}{
+		{"CREDIT", models.ClaimCredit, true},
+		{" replacement ", models.ClaimReplacement, true},
+		{"written_off", models.ClaimWrittenOff, true},
+		{"REFUND", "REFUND", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := validResolution(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("validResolution(%q) = %s, %v; want %s, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
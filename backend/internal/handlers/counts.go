@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"backroom/internal/db"
+	"backroom/internal/inventory"
+	"backroom/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errSessionClosed = errors.New("count session is not open")
+
+// CreateCountSessionHandler opens a count session and snapshots the stock of
+// every product in scope.
+// Body: {"name", "brand", "supplier_id", "skus", "location_id"}.
+func CreateCountSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name       string   `json:"name"`
+		LocationID *uint    `json:"location_id"`
+		Brand      string   `json:"brand"`
+		SupplierID *uint    `json:"supplier_id"`
+		SKUs       []string `json:"skus"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.Brand == "" && payload.SupplierID == nil && len(payload.SKUs) == 0 {
+		http.Error(w, "Scope the session by brand, supplier_id or skus", http.StatusBadRequest)
+		return
+	}
+
+	if payload.LocationID != nil {
+		if _, err := inventory.ResolveLocation(db.DB, payload.LocationID); err != nil {
+			http.Error(w, "Invalid location: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	session := models.CountSession{
+		Name:       payload.Name,
+		LocationID: payload.LocationID,
+		Brand:      payload.Brand,
+		SupplierID: payload.SupplierID,
+		Status:     models.CountStatusOpen,
+		CreatedBy:  requestUser(r),
+	}
+	if len(payload.SKUs) > 0 {
+		session.SKUs, _ = json.Marshal(payload.SKUs)
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&models.Product{})
+		if payload.Brand != "" {
+			query = query.Where("brand = ?", payload.Brand)
+		}
+		if payload.SupplierID != nil {
+			query = query.Where("supplier_id = ?", *payload.SupplierID)
+		}
+		if len(payload.SKUs) > 0 {
+			query = query.Where("sku IN ?", payload.SKUs)
+		}
+
+		var products []models.Product
+		if err := query.Order("sku").Find(&products).Error; err != nil {
+			return err
+		}
+		if len(products) == 0 {
+			return errors.New("no products match the session scope")
+		}
+
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		for _, p := range products {
+			qty, err := inventory.LocationQty(tx, p.ID, session.LocationID)
+			if err != nil {
+				return err
+			}
+			session.Lines = append(session.Lines, models.CountLine{
+				SessionID:   session.ID,
+				ProductID:   p.ID,
+				SKU:         p.SKU,
+				Title:       p.Title,
+				ExpectedQty: qty,
+				QtyAtCount:  qty,
+			})
+		}
+		return tx.Create(&session.Lines).Error
+	})
+	if err != nil {
+		http.Error(w, "Failed to open session: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(session)
+}
+
+// GetCountSessionsHandler lists sessions, newest first; ?status= filters
+func GetCountSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	var sessions []models.CountSession
+	query := db.DB.Order("created_at desc")
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", strings.ToUpper(status))
+	}
+	if err := query.Find(&sessions).Error; err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// GetCountSessionHandler returns a session with its lines
+func GetCountSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadCountSession(w, r)
+	if !ok {
+		return
+	}
+	json.NewEncoder(w).Encode(session)
+}
+
+// CountScanHandler records a scanned or keyed count. Body: {"code", "qty"}.
+// By default the scan adds qty (1 if omitted) to the line; with "set": true
+// qty replaces the counted quantity, for keyed entry of a whole shelf.
+func CountScanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	var payload struct {
+		Code string `json:"code"`
+		Qty  *int   `json:"qty"`
+		Set  bool   `json:"set"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+	qty := 1
+	if payload.Qty != nil {
+		qty = *payload.Qty
+	}
+	if qty < 0 || (!payload.Set && qty == 0) {
+		http.Error(w, "Invalid qty", http.StatusBadRequest)
+		return
+	}
+
+	product, err := findProductByCode(db.DB, payload.Code)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "not_found",
+			"message": "Product not found in inventory: " + payload.Code,
+		})
+		return
+	}
+
+	var line models.CountLine
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var session models.CountSession
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&session, id).Error; err != nil {
+			return err
+		}
+		if session.Status != models.CountStatusOpen {
+			return errSessionClosed
+		}
+
+		// Stock as of this count; a sale after this point moves both the
+		// shelf and the system figure, so it must not show up as variance.
+		// Locking the product row holds off inventory.Apply meanwhile.
+		var locked models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").First(&locked, "id = ?", product.ID).Error; err != nil {
+			return err
+		}
+		currentQty, err := inventory.LocationQty(tx, product.ID, session.LocationID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("session_id = ? AND product_id = ?", session.ID, product.ID).First(&line).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Found on the shelf but outside the session scope
+			line = models.CountLine{
+				SessionID:   session.ID,
+				ProductID:   product.ID,
+				SKU:         product.SKU,
+				Title:       product.Title,
+				ExpectedQty: currentQty,
+				Unplanned:   true,
+			}
+		} else if err != nil {
+			return err
+		}
+
+		// Counting of a line starts on its first scan, or again on a keyed total
+		if line.Counted == nil || payload.Set {
+			line.QtyAtCount = currentQty
+			line.Counted = &qty
+		} else {
+			total := *line.Counted + qty
+			line.Counted = &total
+		}
+		now := time.Now()
+		line.CountedAt = &now
+		line.CountedBy = requestUser(r)
+		return tx.Save(&line).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errSessionClosed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to record count: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "counted",
+		"product": product,
+		"line":    line,
+	})
+}
+
+// CountVariance is one line of the variance report
+type CountVariance struct {
+	models.CountLine
+	CurrentQty int `json:"current_qty"` // System stock now
+	Moved      int `json:"moved"`       // Movements between session start and count
+	Variance   int `json:"variance"`    // Adjustment posted on approval
+}
+
+// countVariances computes the report for every line. Uncounted lines carry
+// no variance: a partial count only corrects what was actually counted.
+func countVariances(tx *gorm.DB, session models.CountSession) ([]CountVariance, error) {
+	report := make([]CountVariance, 0, len(session.Lines))
+	for _, line := range session.Lines {
+		qty, err := inventory.LocationQty(tx, line.ProductID, session.LocationID)
+		if err != nil {
+			return nil, err
+		}
+		v := CountVariance{CountLine: line, CurrentQty: qty}
+		if line.Counted != nil {
+			v.Moved = line.QtyAtCount - line.ExpectedQty
+			v.Variance = *line.Counted - line.QtyAtCount
+		}
+		report = append(report, v)
+	}
+	return report, nil
+}
+
+// GetCountVarianceHandler returns the variance report of a session
+func GetCountVarianceHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadCountSession(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := countVariances(db.DB, session)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	counted, withVariance, net := 0, 0, 0
+	for _, v := range report {
+		if v.Counted != nil {
+			counted++
+		}
+		if v.Variance != 0 {
+			withVariance++
+			net += v.Variance
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session":       session,
+		"lines":         report,
+		"total_lines":   len(report),
+		"counted_lines": counted,
+		"variance_rows": withVariance,
+		"net_variance":  net,
+	})
+}
+
+// ApproveCountSessionHandler posts one COUNT movement per counted line with
+// variance and closes the session, all in one transaction.
+func ApproveCountSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	user := requestUser(r)
+
+	var movements []models.InventoryMovement
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var session models.CountSession
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Lines").First(&session, id).Error; err != nil {
+			return err
+		}
+		if session.Status != models.CountStatusOpen {
+			return errSessionClosed
+		}
+
+		report, err := countVariances(tx, session)
+		if err != nil {
+			return err
+		}
+		for _, v := range report {
+			if v.Variance == 0 {
+				continue
+			}
+			m, err := inventory.Apply(tx, inventory.Change{
+				ProductID:  v.ProductID,
+				Delta:      v.Variance,
+				LocationID: session.LocationID,
+				Reason:     models.MovementCount,
+				Ref:        inventory.Ref{Type: models.RefCountSession, ID: strconv.FormatUint(uint64(session.ID), 10)},
+				User:       user,
+				Note:       fmt.Sprintf("Counted %d, system %d", *v.Counted, v.QtyAtCount),
+			})
+			if err != nil {
+				return err
+			}
+			movements = append(movements, m)
+		}
+
+		now := time.Now()
+		return tx.Model(&session).Updates(map[string]interface{}{
+			"status":      models.CountStatusApproved,
+			"approved_by": user,
+			"closed_at":   now,
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errSessionClosed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to approve session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    models.CountStatusApproved,
+		"movements": movements,
+	})
+}
+
+// CancelCountSessionHandler discards an open session without touching stock
+func CancelCountSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	res := db.DB.Model(&models.CountSession{}).
+		Where("id = ? AND status = ?", id, models.CountStatusOpen).
+		Updates(map[string]interface{}{"status": models.CountStatusCancelled, "closed_at": time.Now()})
+	if res.Error != nil {
+		http.Error(w, "DB Error: "+res.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "Session not found or not open", http.StatusConflict)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": string(models.CountStatusCancelled)})
+}
+
+// loadCountSession reads {id} from the URL and loads the session with its
+// lines, writing the error response itself when it fails.
+func loadCountSession(w http.ResponseWriter, r *http.Request) (models.CountSession, bool) {
+	var session models.CountSession
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return session, false
+	}
+	if err := db.DB.Preload("Lines", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("sku")
+	}).First(&session, id).Error; err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return session, false
+	}
+	return session, true
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"backroom/internal/db"
+	"backroom/internal/inventory"
+	"backroom/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// DiscrepancyLine is one row of a PO discrepancy report
+type DiscrepancyLine struct {
+	Type        models.DiscrepancyType `json:"type"`
+	SKU         string                 `json:"sku"`
+	Title       string                 `json:"title"`
+	QtyOrdered  int                    `json:"qty_ordered"`
+	QtyReceived int                    `json:"qty_received"`
+	Qty         int                    `json:"qty"` // Units short, over, unexpected or damaged
+}
+
+// poDiscrepancies compares a PO with what was received against it. Short and
+// over come from the PO items; unexpected and damaged units come from the
+// movement ledger (receipts and "damaged" adjustments referencing the PO).
+func poDiscrepancies(tx *gorm.DB, po models.PurchaseOrder) ([]DiscrepancyLine, error) {
+	var items []models.POItem
+	if err := tx.Preload("Product").Where("po_id = ?", po.ID).Order("sku").Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	var report []DiscrepancyLine
+	onPO := make(map[string]bool)
+	for _, item := range items {
+		onPO[item.SKU] = true
+		line := DiscrepancyLine{
+			SKU:         item.SKU,
+			Title:       item.Product.Title,
+			QtyOrdered:  item.QtyOrdered,
+			QtyReceived: item.QtyReceived,
+		}
+		switch {
+		case item.QtyReceived < item.QtyOrdered:
+			line.Type = models.DiscrepancyShort
+			line.Qty = item.QtyOrdered - item.QtyReceived
+		case item.QtyReceived > item.QtyOrdered:
+			line.Type = models.DiscrepancyOver
+			line.Qty = item.QtyReceived - item.QtyOrdered
+		default:
+			continue
+		}
+		report = append(report, line)
+	}
+
+	ref := strconv.FormatUint(uint64(po.ID), 10)
+	type skuQty struct {
+		SKU   string
+		Title string
+		Qty   int
+	}
+
+	var receipts []skuQty
+	if err := tx.Raw(`
+		SELECT m.sku, p.title, SUM(m.delta) AS qty
+		FROM inventory_movements m
+		LEFT JOIN products p ON p.id = m.product_id
+		WHERE m.ref_type = ? AND m.ref_id = ? AND m.reason = ?
+		GROUP BY m.sku, p.title
+		HAVING SUM(m.delta) > 0
+		ORDER BY m.sku`, models.RefPurchaseOrder, ref, models.MovementReceipt).Scan(&receipts).Error; err != nil {
+		return nil, err
+	}
+	for _, rq := range receipts {
+		if onPO[rq.SKU] {
+			continue
+		}
+		report = append(report, DiscrepancyLine{
+			Type:        models.DiscrepancyUnexpected,
+			SKU:         rq.SKU,
+			Title:       rq.Title,
+			QtyReceived: rq.Qty,
+			Qty:         rq.Qty,
+		})
+	}
+
+	var damaged []skuQty
+	if err := tx.Raw(`
+		SELECT m.sku, p.title, -SUM(m.delta) AS qty
+		FROM inventory_movements m
+		LEFT JOIN products p ON p.id = m.product_id
+		WHERE m.ref_type = ? AND m.ref_id = ? AND m.reason_code = ?
+		GROUP BY m.sku, p.title
+		HAVING SUM(m.delta) < 0
+		ORDER BY m.sku`, models.RefPurchaseOrder, ref, models.AdjustDamaged).Scan(&damaged).Error; err != nil {
+		return nil, err
+	}
+	for _, dq := range damaged {
+		line := DiscrepancyLine{Type: models.DiscrepancyDamaged, SKU: dq.SKU, Title: dq.Title, Qty: dq.Qty}
+		for _, item := range items {
+			if item.SKU == dq.SKU {
+				line.QtyOrdered, line.QtyReceived = item.QtyOrdered, item.QtyReceived
+			}
+		}
+		report = append(report, line)
+	}
+
+	sort.SliceStable(report, func(i, j int) bool { return report[i].Type < report[j].Type })
+	return report, nil
+}
+
+// GetOrderDiscrepanciesHandler returns the discrepancy report of a PO as
+// JSON, or as an XLSX download with ?format=xlsx
+func GetOrderDiscrepanciesHandler(w http.ResponseWriter, r *http.Request) {
+	var po models.PurchaseOrder
+	if err := db.DB.First(&po, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	report, err := poDiscrepancies(db.DB, po)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") != "xlsx" {
+		if report == nil {
+			report = []DiscrepancyLine{}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"order": po,
+			"lines": report,
+		})
+		return
+	}
+
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := "Discrepancies"
+	f.SetSheetName(f.GetSheetName(0), sheet)
+	f.SetSheetRow(sheet, "A1", &[]interface{}{"PO", po.ID, "Supplier", po.SupplierName, "Status", string(po.Status)})
+	f.SetSheetRow(sheet, "A3", &[]interface{}{"Type", "SKU", "Title", "Ordered", "Received", "Qty"})
+	for i, line := range report {
+		cell, _ := excelize.CoordinatesToCellName(1, i+4)
+		f.SetSheetRow(sheet, cell, &[]interface{}{
+			string(line.Type), line.SKU, line.Title, line.QtyOrdered, line.QtyReceived, line.Qty,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="po_%d_discrepancies.xlsx"`, po.ID))
+	f.Write(w)
+}
+
+// ReportDamagedHandler records units of a PO that arrived damaged. They are
+// taken out of stock with a "damaged" adjustment referencing the PO, which is
+// what the discrepancy report reads. Body: {"code", "qty", "note",
+// "location_id", "bin"}; qty must be positive.
+func ReportDamagedHandler(w http.ResponseWriter, r *http.Request) {
+	poID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	var payload struct {
+		Code       string `json:"code"`
+		Qty        int    `json:"qty"`
+		Note       string `json:"note"`
+		LocationID *uint  `json:"location_id"`
+		Bin        string `json:"bin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.Qty <= 0 {
+		http.Error(w, "Invalid qty", http.StatusBadRequest)
+		return
+	}
+
+	var po models.PurchaseOrder
+	if err := db.DB.First(&po, poID).Error; err != nil {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	product, err := findProductByCode(db.DB, payload.Code)
+	if err != nil {
+		http.Error(w, "Product not found in inventory: "+payload.Code, http.StatusNotFound)
+		return
+	}
+	if payload.LocationID != nil {
+		if _, err := inventory.ResolveLocation(db.DB, payload.LocationID); err != nil {
+			http.Error(w, "Invalid location", http.StatusBadRequest)
+			return
+		}
+	}
+
+	delta := -payload.Qty
+	var movement models.InventoryMovement
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		movement, err = inventory.Adjust(tx, inventory.Adjustment{
+			ProductID:  product.ID,
+			LocationID: payload.LocationID,
+			Bin:        payload.Bin,
+			Delta:      &delta,
+			ReasonCode: models.AdjustDamaged,
+			Note:       payload.Note,
+			User:       requestUser(r),
+			Ref:        inventory.Ref{Type: models.RefPurchaseOrder, ID: strconv.Itoa(poID)},
+		})
+		return err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Product not found in inventory: "+payload.Code, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to record damage: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(movement)
+}
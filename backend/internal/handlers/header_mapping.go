@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"backroom/internal/models"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// headerScanRows is how many rows are searched for the header row
+const headerScanRows = 20
+
+// mappingFields are the MappingConfig columns that can be matched by header
+// text, each with the header names suppliers use for it (normalized)
+var mappingFields = []struct {
+	Key      string
+	Synonyms []string
+}{
+	{"sku", []string{"sku", "codigo", "cod", "clave", "ref", "referencia", "modelo", "model", "articulo", "item", "item code", "part number", "no parte", "numero de parte"}},
+	{"barcode", []string{"barcode", "codigo de barras", "cod barras", "ean", "ean13", "upc", "gtin"}},
+	{"title", []string{"descripcion", "description", "nombre", "name", "producto", "product", "titulo", "title"}},
+	{"qty", []string{"qty", "cantidad", "cant", "quantity", "piezas", "pzas", "pz", "unidades", "units", "pedido"}},
+	{"cost", []string{"costo", "cost", "costo unitario", "unit cost", "precio unitario", "p unitario", "precio neto", "net price"}},
+	{"price", []string{"precio", "price", "pvp", "precio publico", "precio de venta", "msrp"}},
+	{"brand", []string{"marca", "brand", "fabricante", "manufacturer"}},
+}
+
+// mappingColumn points at the index field of MappingConfig for a key
+func mappingColumn(m *models.MappingConfig, key string) *int {
+	switch key {
+	case "sku":
+		return &m.ColSKU
+	case "title":
+		return &m.ColTitle
+	case "barcode":
+		return &m.ColBarcode
+	case "qty":
+		return &m.ColQty
+	case "price":
+		return &m.ColPrice
+	case "cost":
+		return &m.ColCost
+	case "brand":
+		return &m.ColBrand
+	}
+	return nil
+}
+
+var accentReplacer = strings.NewReplacer(
+	"á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n",
+	".", " ", ":", " ", "#", " ", "_", " ", "-", " ", "/", " ", "(", " ", ")", " ",
+)
+
+// normalizeHeader lower-cases a header and drops accents and punctuation,
+// so "Código:" and "CODIGO" compare equal
+func normalizeHeader(s string) string {
+	return strings.Join(strings.Fields(accentReplacer.Replace(strings.ToLower(s))), " ")
+}
+
+// headerScore rates how well a header cell names a field: 1 for an exact
+// synonym, 0.6 when a synonym is one of its words ("Código SAP"), else 0
+func headerScore(cell string, synonyms []string) float64 {
+	h := normalizeHeader(cell)
+	if h == "" {
+		return 0
+	}
+	best := 0.0
+	for _, syn := range synonyms {
+		if h == syn {
+			return 1
+		}
+		if strings.HasPrefix(h, syn+" ") || strings.HasSuffix(h, " "+syn) || strings.Contains(h, " "+syn+" ") {
+			best = 0.6
+		}
+	}
+	return best
+}
+
+// FieldSuggestion is the column proposed for one mapping field
+type FieldSuggestion struct {
+	Column     int     `json:"column"`
+	Header     string  `json:"header"`
+	Confidence float64 `json:"confidence"` // 0-1
+}
+
+// MappingSuggestion is a mapping guessed from the headers of a sheet
+type MappingSuggestion struct {
+	HeaderRow           int                        `json:"header_row"`
+	HeaderRowConfidence float64                    `json:"header_row_confidence"`
+	Fields              map[string]FieldSuggestion `json:"fields"`
+	Mapping             models.MappingConfig       `json:"mapping"` // Ready to save
+}
+
+// matchHeaders assigns header cells to fields, best scores first and each
+// column to at most one field
+func matchHeaders(header []string) map[string]FieldSuggestion {
+	type candidate struct {
+		key   string
+		col   int
+		score float64
+	}
+	var candidates []candidate
+	for _, f := range mappingFields {
+		for col, cell := range header {
+			if score := headerScore(cell, f.Synonyms); score > 0 {
+				candidates = append(candidates, candidate{f.Key, col, score})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+
+	fields := make(map[string]FieldSuggestion)
+	usedCols := make(map[int]bool)
+	for _, c := range candidates {
+		if _, done := fields[c.key]; done || usedCols[c.col] {
+			continue
+		}
+		usedCols[c.col] = true
+		fields[c.key] = FieldSuggestion{Column: c.col, Header: strings.TrimSpace(header[c.col]), Confidence: c.score}
+	}
+	return fields
+}
+
+// suggestMapping finds the header row among the first rows of a sheet, the
+// one whose cells name the most fields, and proposes a column for each field
+func suggestMapping(rows [][]string) MappingSuggestion {
+	best := MappingSuggestion{Fields: map[string]FieldSuggestion{}}
+	bestScore := 0.0
+	for i := 0; i < len(rows) && i < headerScanRows; i++ {
+		fields := matchHeaders(rows[i])
+		score := 0.0
+		for _, f := range fields {
+			score += f.Confidence
+		}
+		// The SKU column is what makes a row a header worth using
+		if _, ok := fields["sku"]; ok {
+			score += 1
+		}
+		if score > bestScore {
+			bestScore = score
+			best = MappingSuggestion{HeaderRow: i, Fields: fields}
+		}
+	}
+
+	// Confident when the SKU and quantity columns are found by exact name
+	best.HeaderRowConfidence = bestScore / 3
+	if best.HeaderRowConfidence > 1 {
+		best.HeaderRowConfidence = 1
+	}
+
+	// Fields without a column are left unmapped (-1) rather than guessed
+	best.Mapping = models.MappingConfig{HeaderRow: best.HeaderRow, Columns: map[string]string{}, Version: models.MappingVersion}
+	for _, f := range mappingFields {
+		*mappingColumn(&best.Mapping, f.Key) = -1
+	}
+	for key, f := range best.Fields {
+		*mappingColumn(&best.Mapping, key) = f.Column
+		best.Mapping.Columns[key] = f.Header
+	}
+	return best
+}
+
+// findNamedColumn finds the column of header titled name, falling back to
+// the synonyms of the field. synonym is set when the column was found by one.
+func findNamedColumn(header []string, key, name string) (col int, synonym bool) {
+	want := normalizeHeader(name)
+	for col, cell := range header {
+		if normalizeHeader(cell) == want {
+			return col, false
+		}
+	}
+	for _, f := range mappingFields {
+		if f.Key != key {
+			continue
+		}
+		for col, cell := range header {
+			if headerScore(cell, f.Synonyms) == 1 {
+				return col, true
+			}
+		}
+	}
+	return -1, false
+}
+
+// resolveMapping turns the header names of a mapping into column indexes
+// for one file, so inserted or moved columns are still found. The header
+// row is the first one that has the most named columns. A column found by a
+// synonym instead of its saved name is used with a warning; names that are
+// not in the file at all fall back to their saved index, also with a
+// warning. Mappings without names are returned as they are.
+func resolveMapping(m models.MappingConfig, rows [][]string) (models.MappingConfig, []string) {
+	if len(m.Columns) == 0 {
+		return m, nil
+	}
+	warnings := []string{}
+
+	keys := make([]string, 0, len(m.Columns))
+	for key := range m.Columns {
+		if mappingColumn(&m, key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	headerRow, bestFound := m.HeaderRow, 0
+	count := func(i int) int {
+		found := 0
+		for _, key := range keys {
+			if col, _ := findNamedColumn(rows[i], key, m.Columns[key]); col >= 0 {
+				found++
+			}
+		}
+		return found
+	}
+	if m.HeaderRow < len(rows) {
+		bestFound = count(m.HeaderRow)
+	}
+	for i := 0; i < len(rows) && i < headerScanRows && bestFound < len(keys); i++ {
+		if found := count(i); found > bestFound {
+			headerRow, bestFound = i, found
+		}
+	}
+	if bestFound == 0 {
+		return m, append(warnings, "None of the mapped column names were found; using saved column positions")
+	}
+	if headerRow != m.HeaderRow {
+		warnings = append(warnings, fmt.Sprintf("Header found on row %d instead of row %d", headerRow+1, m.HeaderRow+1))
+	}
+	m.HeaderRow = headerRow
+
+	for _, key := range keys {
+		col := mappingColumn(&m, key)
+		found, synonym := findNamedColumn(rows[headerRow], key, m.Columns[key])
+		switch {
+		case found < 0 && *col < 0:
+			warnings = append(warnings, fmt.Sprintf("Column %q (%s) not found; left unmapped", m.Columns[key], key))
+			continue
+		case found < 0:
+			warnings = append(warnings, fmt.Sprintf("Column %q (%s) not found; using column %d", m.Columns[key], key, *col+1))
+			continue
+		case synonym:
+			warnings = append(warnings, fmt.Sprintf("Column %q (%s) not found; using column %d, %q", m.Columns[key], key, found+1, strings.TrimSpace(rows[headerRow][found])))
+		}
+		*col = found
+	}
+	return m, warnings
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"backroom/internal/models"
+	"strings"
+	"testing"
+)
+
+// TestSuggestMapping checks that the header row is found below a title and
+// that fields without a matching header are left unmapped
+func TestSuggestMapping(t *testing.T) {
+	rows := [][]string{
+		{"Lista de precios 2026"},
+		{},
+		{"Código", "Descripción", "Cant.", "Precio Unitario", "Marca"},
+		{"A-1", "Widget", "3", "10.50", "Acme"},
+	}
+	s := suggestMapping(rows)
+	if s.HeaderRow != 2 {
+		t.Fatalf("header row = %d, want 2", s.HeaderRow)
+	}
+	if s.HeaderRowConfidence != 1 {
+		t.Errorf("header row confidence = %v, want 1", s.HeaderRowConfidence)
+	}
+	m := s.Mapping
+	want := map[string]int{"sku": 0, "title": 1, "qty": 2, "cost": 3, "brand": 4, "price": -1, "barcode": -1}
+	for key, col := range want {
+		if got := *mappingColumn(&m, key); got != col {
+			t.Errorf("%s column = %d, want %d", key, got, col)
+		}
+	}
+	if m.Columns["sku"] != "Código" || m.Columns["cost"] != "Precio Unitario" {
+		t.Errorf("column names = %v", m.Columns)
+	}
+	if _, ok := m.Columns["price"]; ok {
+		t.Errorf("unmapped price has a column name %q", m.Columns["price"])
+	}
+	if m.HeaderRow != 2 || m.Version != models.MappingVersion {
+		t.Errorf("mapping header row %d, version %d", m.HeaderRow, m.Version)
+	}
+
+	// Nothing that looks like a header: row 0, every field unmapped
+	s = suggestMapping([][]string{{"1", "2"}, {"3", "4"}})
+	if s.HeaderRow != 0 || len(s.Fields) != 0 || s.Mapping.ColSKU != -1 || s.Mapping.ColQty != -1 {
+		t.Errorf("headerless sheet: %+v", s)
+	}
+}
+
+// TestResolveMapping covers moved columns and header rows, synonym matches
+// and names missing from the file, with the warnings each one reports
+func TestResolveMapping(t *testing.T) {
+	saved := func(headerRow int, cols map[string]int, names map[string]string) models.MappingConfig {
+		m := models.MappingConfig{HeaderRow: headerRow, ColSKU: -1, ColTitle: -1, ColBarcode: -1, ColQty: -1, ColPrice: -1, ColBrand: -1, ColCost: -1, Columns: names}
+		for key, col := range cols {
+			*mappingColumn(&m, key) = col
+		}
+		return m
+	}
+
+	tests := []struct {
+		name      string
+		mapping   models.MappingConfig
+		rows      [][]string
+		headerRow int
+		cols      map[string]int
+		warnings  []string // Substrings, one per expected warning
+	}{
+		{
+			name:    "positions only",
+			mapping: saved(0, map[string]int{"sku": 1, "qty": 2}, nil),
+			rows:    [][]string{{"x", "Código", "Cantidad"}},
+			cols:    map[string]int{"sku": 1, "qty": 2},
+		},
+		{
+			name:    "moved columns",
+			mapping: saved(0, map[string]int{"sku": 0, "qty": 1}, map[string]string{"sku": "Código", "qty": "Cantidad"}),
+			rows:    [][]string{{"Marca", "CODIGO", "Cantidad"}},
+			cols:    map[string]int{"sku": 1, "qty": 2},
+		},
+		{
+			name:      "moved header row",
+			mapping:   saved(0, map[string]int{"sku": 0, "qty": 1}, map[string]string{"sku": "Código", "qty": "Cantidad"}),
+			rows:      [][]string{{"Pedido 123"}, {"Código", "Cantidad"}},
+			headerRow: 1,
+			cols:      map[string]int{"sku": 0, "qty": 1},
+			warnings:  []string{"Header found on row 2 instead of row 1"},
+		},
+		{
+			name:     "synonym",
+			mapping:  saved(0, map[string]int{"sku": 0, "qty": 1}, map[string]string{"sku": "Código", "qty": "Cantidad"}),
+			rows:     [][]string{{"Código", "Descripción", "Qty"}},
+			cols:     map[string]int{"sku": 0, "qty": 2},
+			warnings: []string{`Column "Cantidad" (qty) not found; using column 3, "Qty"`},
+		},
+		{
+			name:     "missing with saved position",
+			mapping:  saved(0, map[string]int{"sku": 0, "barcode": 3}, map[string]string{"sku": "Código", "barcode": "Código de barras"}),
+			rows:     [][]string{{"Código", "Cant"}},
+			cols:     map[string]int{"sku": 0, "barcode": 3},
+			warnings: []string{`Column "Código de barras" (barcode) not found; using column 4`},
+		},
+		{
+			name:     "missing and unmapped",
+			mapping:  saved(0, map[string]int{"sku": 0}, map[string]string{"sku": "Código", "cost": "Costo"}),
+			rows:     [][]string{{"Código", "Cant"}},
+			cols:     map[string]int{"sku": 0, "cost": -1},
+			warnings: []string{`Column "Costo" (cost) not found; left unmapped`},
+		},
+		{
+			name:     "no names found",
+			mapping:  saved(0, map[string]int{"sku": 0, "qty": 1}, map[string]string{"sku": "Código", "qty": "Cantidad"}),
+			rows:     [][]string{{"1", "2"}},
+			cols:     map[string]int{"sku": 0, "qty": 1},
+			warnings: []string{"None of the mapped column names were found"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, warnings := resolveMapping(tt.mapping, tt.rows)
+			if m.HeaderRow != tt.headerRow {
+				t.Errorf("header row = %d, want %d", m.HeaderRow, tt.headerRow)
+			}
+			for key, col := range tt.cols {
+				if got := *mappingColumn(&m, key); got != col {
+					t.Errorf("%s column = %d, want %d", key, got, col)
+				}
+			}
+			if len(warnings) != len(tt.warnings) {
+				t.Fatalf("warnings = %q, want %d", warnings, len(tt.warnings))
+			}
+			for i, want := range tt.warnings {
+				if !strings.Contains(warnings[i], want) {
+					t.Errorf("warning %d = %q, want it to contain %q", i, warnings[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+)
+
+// importReports keeps annotated copies of imported files for download
+var importReports = struct {
+	sync.Mutex
+	byID map[string]importReport
+}{byID: make(map[string]importReport)}
+
+const importReportTTL = time.Hour
+
+type importReport struct {
+	name    string
+	data    []byte
+	expires time.Time
+}
+
+// rejectedRows keeps the rows that were rejected as invalid
+func rejectedRows(rows []skippedRow) []skippedRow {
+	rejected := []skippedRow{}
+	for _, row := range rows {
+		if row.Error {
+			rejected = append(rejected, row)
+		}
+	}
+	return rejected
+}
+
+// annotateSheet returns a copy of an XLSX file whose sheet has an extra
+// "Import Error" column with the reason of each rejected row
+func annotateSheet(src []byte, sheet string, headerRow int, rejected []skippedRow) ([]byte, error) {
+	f, err := excelize.OpenReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows, err := f.GetRows(sheet)
+	if err != nil {
+		return nil, err
+	}
+	col := 1
+	for _, row := range rows {
+		if len(row)+1 > col {
+			col = len(row) + 1
+		}
+	}
+	bold, _ := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	flagged, _ := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Color: "9C0006"},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"FFC7CE"}},
+	})
+
+	cell, _ := excelize.CoordinatesToCellName(col, headerRow+1)
+	f.SetCellValue(sheet, cell, "Import Error")
+	f.SetCellStyle(sheet, cell, cell, bold)
+	for _, r := range rejected {
+		cell, _ := excelize.CoordinatesToCellName(col, r.Row)
+		f.SetCellValue(sheet, cell, r.Reason)
+		f.SetCellStyle(sheet, cell, cell, flagged)
+	}
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// saveImportReport annotates the rejected rows of an imported file and
+// returns the URL to download it, or "" when there is nothing to report.
+// CSV files come back as XLSX.
+func saveImportReport(fileName string, s *sheetData, headerRow int, rows []skippedRow) string {
+	rejected := rejectedRows(rows)
+	if len(rejected) == 0 || s == nil {
+		return ""
+	}
+	src := s.workbook()
+	if src == nil {
+		return ""
+	}
+	data, err := annotateSheet(src, s.Sheet, headerRow, rejected)
+	if err != nil {
+		return ""
+	}
+
+	name := strings.TrimSuffix(fileName, filepath.Ext(fileName)) + "_errors.xlsx"
+	id := uuid.NewString()
+	importReports.Lock()
+	for k, rep := range importReports.byID {
+		if time.Now().After(rep.expires) {
+			delete(importReports.byID, k)
+		}
+	}
+	importReports.byID[id] = importReport{name: name, data: data, expires: time.Now().Add(importReportTTL)}
+	importReports.Unlock()
+	return "/api/imports/reports/" + id
+}
+
+// GetImportReportHandler downloads an annotated import file
+func GetImportReportHandler(w http.ResponseWriter, r *http.Request) {
+	importReports.Lock()
+	rep, ok := importReports.byID[chi.URLParam(r, "id")]
+	importReports.Unlock()
+	if !ok || time.Now().After(rep.expires) {
+		http.Error(w, "Report not found or expired", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, rep.name))
+	w.Write(rep.data)
+}
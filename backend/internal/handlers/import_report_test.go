@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"backroom/internal/models"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/xuri/excelize/v2"
+)
+
+// TestParseOrderRows checks which rows become lines, which are left out on
+// purpose and which are rejected as invalid
+func TestParseOrderRows(t *testing.T) {
+	mapping := models.MappingConfig{HeaderRow: 0, ColSKU: 0, ColQty: 1, ColTitle: 2, ColBarcode: 3, ColCost: 4, ColPrice: -1, ColBrand: -1}
+	rows := [][]string{
+		{"SKU", "Qty", "Title", "EAN", "Cost"},
+		{"A-1", "3", "Widget", "7.501234567890E+12", "$1,200.50"},
+		{"A-2", "2.0", "", "", ""},
+		{"", "", "", "", ""},
+		{"", "4", "No SKU"},
+		{"A-3", "many"},
+		{"A-4", "-1"},
+		{"A-5", "0"},
+		{"A-6", "1", "", "", "free"},
+		{"A-7", "1", "", "", "-3"},
+	}
+	lines, skipped := parseOrderRows(rows, mapping)
+
+	if len(lines) != 2 {
+		t.Fatalf("lines = %+v", lines)
+	}
+	first := lines[0]
+	if first.Row != 2 || first.SKU != "A-1" || first.Qty != 3 || first.Title != "Widget" ||
+		first.Barcode != "7501234567890" || first.UnitCost != 1200.5 {
+		t.Errorf("first line = %+v", first)
+	}
+	if second := lines[1]; second.Qty != 2 || second.Title != "Imported A-2" || second.UnitCost != 0 {
+		t.Errorf("second line = %+v", second)
+	}
+
+	want := []skippedRow{
+		{Row: 5, Reason: "missing SKU", Error: true},
+		{Row: 6, SKU: "A-3", Reason: `unparseable qty "many"`, Error: true},
+		{Row: 7, SKU: "A-4", Reason: "negative qty", Error: true},
+		{Row: 8, SKU: "A-5", Reason: "zero qty"},
+		{Row: 9, SKU: "A-6", Reason: `invalid unit cost "free"`, Error: true},
+		{Row: 10, SKU: "A-7", Reason: `invalid unit cost "-3"`, Error: true},
+	}
+	if len(skipped) != len(want) {
+		t.Fatalf("skipped = %+v", skipped)
+	}
+	for i := range want {
+		if skipped[i] != want[i] {
+			t.Errorf("skipped %d = %+v, want %+v", i, skipped[i], want[i])
+		}
+	}
+	if rejected := rejectedRows(skipped); len(rejected) != 5 {
+		t.Errorf("rejected rows = %+v, want the 5 errors", rejected)
+	}
+}
+
+// TestSaveImportReport checks that a CSV upload comes back as an XLSX copy
+// with the reason next to each rejected row, and that there is no report
+// without rejected rows
+func TestSaveImportReport(t *testing.T) {
+	s, err := readSheet([]byte("sku;qty\nA-1;2\nA-2;x\n"), "pedido.csv", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []skippedRow{{Row: 3, SKU: "A-2", Reason: `unparseable qty "x"`, Error: true}}
+	if url := saveImportReport("pedido.csv", s, 0, []skippedRow{{Row: 2, Reason: "zero qty"}}); url != "" {
+		t.Errorf("report without rejected rows: %s", url)
+	}
+	url := saveImportReport("pedido.csv", s, 0, rows)
+	if !strings.HasPrefix(url, "/api/imports/reports/") {
+		t.Fatalf("report url = %q", url)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/api/imports/reports/{id}", GetImportReportHandler)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Header().Get("Content-Disposition"), "pedido_errors.xlsx") {
+		t.Fatalf("download: %d %v", rec.Code, rec.Header())
+	}
+
+	f, err := excelize.OpenReader(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := f.GetRows(s.Sheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0][2] != "Import Error" || got[2][2] != `unparseable qty "x"` || len(got[1]) != 2 {
+		t.Errorf("annotated rows = %q", got)
+	}
+}
@@ -268,6 +268,9 @@ func TriggerProcessHandler(w http.ResponseWriter, r *http.Request) {
 // ClearProductsHandler deletes all products (Drafts)
 func ClearProductsHandler(w http.ResponseWriter, r *http.Request) {
 	db.DB.Exec("DELETE FROM products")
+	// Ledger rows of the deleted products
+	db.DB.Exec("DELETE FROM inventory_movements")
+	db.DB.Exec("DELETE FROM stock_balances")
 	db.DB.Exec("DELETE FROM source_files") // Optional: clear history too? User said "clean what is loaded"
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "cleared"})
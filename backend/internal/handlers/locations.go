@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"backroom/internal/db"
+	"backroom/internal/models"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// findLocation resolves a location by code or name, case-insensitively
+func findLocation(tx *gorm.DB, ref string) (models.Location, error) {
+	var loc models.Location
+	err := tx.Where("LOWER(code) = LOWER(?) OR LOWER(name) = LOWER(?)", ref, ref).First(&loc).Error
+	return loc, err
+}
+
+// GetLocationsHandler - List locations with the units held in each
+func GetLocationsHandler(w http.ResponseWriter, r *http.Request) {
+	type LocationItem struct {
+		models.Location
+		TotalQty int `json:"total_qty"`
+		Products int `json:"products"` // Products with non-zero stock
+	}
+	var list []LocationItem
+	err := db.DB.Raw(`
+		SELECT l.*,
+			COALESCE(SUM(b.qty), 0) AS total_qty,
+			COUNT(DISTINCT b.product_id) FILTER (WHERE b.qty <> 0) AS products
+		FROM locations l
+		LEFT JOIN stock_balances b ON b.location_id = l.id
+		GROUP BY l.id
+		ORDER BY l.is_default DESC, l.name`).Scan(&list).Error
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// CreateLocationHandler - Add a stock location
+func CreateLocationHandler(w http.ResponseWriter, r *http.Request) {
+	var loc models.Location
+	if err := json.NewDecoder(r.Body).Decode(&loc); err != nil {
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+	loc.ID = 0
+	loc.Name = strings.TrimSpace(loc.Name)
+	loc.Code = strings.ToUpper(strings.TrimSpace(loc.Code))
+	if loc.Name == "" || loc.Code == "" {
+		http.Error(w, "Name and code are required", http.StatusBadRequest)
+		return
+	}
+
+	var count int64
+	db.DB.Model(&models.Location{}).Where("name = ? OR code = ?", loc.Name, loc.Code).Count(&count)
+	if count > 0 {
+		http.Error(w, "Location with this name or code already exists", http.StatusConflict)
+		return
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if loc.IsDefault {
+			if err := tx.Model(&models.Location{}).Where("is_default = ?", true).Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&loc).Error
+	})
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(loc)
+}
+
+// UpdateLocationHandler - Rename a location or make it the default
+func UpdateLocationHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var loc models.Location
+	if err := db.DB.First(&loc, id).Error; err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	var updateData models.Location
+	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+	if name := strings.TrimSpace(updateData.Name); name != "" {
+		loc.Name = name
+	}
+	if code := strings.TrimSpace(updateData.Code); code != "" {
+		loc.Code = strings.ToUpper(code)
+	}
+	// There is always exactly one default; it can be moved but not cleared
+	makeDefault := updateData.IsDefault && !loc.IsDefault
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if makeDefault {
+			if err := tx.Model(&models.Location{}).Where("is_default = ?", true).Update("is_default", false).Error; err != nil {
+				return err
+			}
+			loc.IsDefault = true
+		}
+		return tx.Save(&loc).Error
+	})
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(loc)
+}
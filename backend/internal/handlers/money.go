@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var errInvalidCurrency = errors.New("currency must be a 3-letter ISO 4217 code")
+
+// defaultCurrency is used for POs whose supplier has no currency set
+func defaultCurrency() string {
+	if c, _ := normalizeCurrency(os.Getenv("DEFAULT_CURRENCY")); c != "" {
+		return c
+	}
+	return "MXN"
+}
+
+// normalizeCurrency upper-cases an ISO 4217 code. Empty stays empty; anything
+// but three letters is errInvalidCurrency.
+func normalizeCurrency(c string) (string, error) {
+	c = strings.ToUpper(strings.TrimSpace(c))
+	if c == "" {
+		return "", nil
+	}
+	if len(c) != 3 {
+		return "", errInvalidCurrency
+	}
+	for _, r := range c {
+		if r < 'A' || r > 'Z' {
+			return "", errInvalidCurrency
+		}
+	}
+	return c, nil
+}
+
+// parseAmount reads a spreadsheet money cell like "$1,234.50"
+func parseAmount(s string) (float64, bool) {
+	s = strings.ReplaceAll(s, "$", "")
+	s = strings.ReplaceAll(s, ",", "")
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return v, err == nil
+}
+
+// validateCost checks the cost fields of a PO line
+func validateCost(unitCost, discountPct, taxRate float64) error {
+	switch {
+	case unitCost < 0:
+		return errors.New("unit_cost cannot be negative")
+	case discountPct < 0 || discountPct > 100:
+		return errors.New("discount_pct must be between 0 and 100")
+	case taxRate < 0:
+		return errors.New("tax_rate cannot be negative")
+	}
+	return nil
+}
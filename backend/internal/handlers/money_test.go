@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestNormalizeCurrency accepts three letters in any case and nothing else
+func TestNormalizeCurrency(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      error
+	}{
+		{"mxn", "MXN", nil},
+		{" usd ", "USD", nil},
+		{"", "", nil},
+		{"US", "", errInvalidCurrency},
+		{"USDT", "", errInvalidCurrency},
+		{"U$D", "", errInvalidCurrency},
+		{"ÉUR", "", errInvalidCurrency},
+	}
+	for _, tt := range tests {
+		got, err := normalizeCurrency(tt.in)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("normalizeCurrency(%q) = %q, %v; want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+// TestParseAmount reads money cells with a currency sign and thousands separators
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"12.5", 12.5, true},
+		{"$1,234.50", 1234.5, true},
+		{" $ 99 ", 99, true},
+		{"-3", -3, true},
+		{"", 0, false},
+		{"n/a", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseAmount(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseAmount(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestValidateCost checks the ranges of the cost fields of a PO line
+func TestValidateCost(t *testing.T) {
+	tests := []struct {
+		name                        string
+		unitCost, discountPct, rate float64
+		ok                          bool
+	}{
+		{"plain", 10, 0, 16, true},
+		{"free with full discount", 0, 100, 0, true},
+		{"negative cost", -1, 0, 0, false},
+		{"discount over 100", 10, 101, 0, false},
+		{"negative discount", 10, -5, 0, false},
+		{"negative tax", 10, 0, -16, false},
+	}
+	for _, tt := range tests {
+		if err := validateCost(tt.unitCost, tt.discountPct, tt.rate); (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"backroom/internal/db"
+	"backroom/internal/inventory"
+	"backroom/internal/models"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// requestUser identifies the operator for the movement ledger (X-User header)
+func requestUser(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get("X-User"))
+}
+
+// GetProductMovementsHandler returns the ledger of a single product, newest first
+func GetProductMovementsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	var product models.Product
+	if err := db.DB.First(&product, id).Error; err != nil {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	var movements []models.InventoryMovement
+	db.DB.Where("product_id = ?", id).Order("id desc").Find(&movements)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"product":   product,
+		"movements": movements,
+	})
+}
+
+// ExportMovementsHandler downloads the movement ledger as XLSX (or CSV with ?format=csv).
+// Filters: from, to (YYYY-MM-DD, inclusive), sku, reason.
+func ExportMovementsHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := movementQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var movements []models.InventoryMovement
+	if err := query.Order("created_at asc, id asc").Find(&movements).Error; err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var locations []models.Location
+	db.DB.Find(&locations)
+	locationNames := make(map[uint]string, len(locations))
+	for _, l := range locations {
+		locationNames[l.ID] = l.Name
+	}
+
+	header := []string{"Date", "SKU", "Product ID", "Delta", "Balance After", "Reason", "Reason Code", "Location", "Bin", "Ref Type", "Ref ID", "User", "Note"}
+	rowOf := func(m models.InventoryMovement) []interface{} {
+		return []interface{}{
+			m.CreatedAt.Format("2006-01-02 15:04:05"), m.SKU, m.ProductID.String(), m.Delta,
+			m.BalanceAfter, string(m.Reason), m.ReasonCode, locationNames[m.LocationID], m.Bin,
+			m.RefType, m.RefID, m.User, m.Note,
+		}
+	}
+	filename := "inventory_movements_" + time.Now().Format("20060102")
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		for _, m := range movements {
+			record := make([]string, 0, len(header))
+			for _, v := range rowOf(m) {
+				record = append(record, fmt.Sprint(v))
+			}
+			cw.Write(record)
+		}
+		cw.Flush()
+		return
+	}
+
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := "Movements"
+	f.SetSheetName(f.GetSheetName(0), sheet)
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		http.Error(w, "Failed to build XLSX", http.StatusInternalServerError)
+		return
+	}
+	headerRow := make([]interface{}, len(header))
+	for i, h := range header {
+		headerRow[i] = h
+	}
+	sw.SetRow("A1", headerRow)
+	for i, m := range movements {
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		sw.SetRow(cell, rowOf(m))
+	}
+	if err := sw.Flush(); err != nil {
+		http.Error(w, "Failed to build XLSX", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.xlsx"`)
+	f.Write(w)
+}
+
+// movementQuery builds the ledger query from the export filters
+func movementQuery(r *http.Request) (*gorm.DB, error) {
+	q := r.URL.Query()
+	query := db.DB.Model(&models.InventoryMovement{})
+	if from := q.Get("from"); from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from date (use YYYY-MM-DD)")
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if to := q.Get("to"); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to date (use YYYY-MM-DD)")
+		}
+		query = query.Where("created_at < ?", t.AddDate(0, 0, 1))
+	}
+	if sku := q.Get("sku"); sku != "" {
+		query = query.Where("sku = ?", sku)
+	}
+	if reason := q.Get("reason"); reason != "" {
+		query = query.Where("reason = ?", strings.ToUpper(reason))
+	}
+	return query, nil
+}
+
+// GetReconcileHandler lists products whose StockOnHand disagrees with the ledger
+func GetReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := inventory.Discrepancies(db.DB)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":         len(list),
+		"discrepancies": list,
+	})
+}
+
+// ReconcileHandler records adjustment movements so the ledger explains current
+// StockOnHand, and tops up the default location so the balances sum to it
+func ReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	var list []inventory.Discrepancy
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		list, err = inventory.Reconcile(tx, requestUser(r), "Reconciliation")
+		return err
+	})
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"reconciled":    len(list),
+		"discrepancies": list,
+	})
+}
@@ -0,0 +1,532 @@
+package handlers
+
+import (
+	"backroom/internal/db"
+	"backroom/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errPONotEditable = errors.New("purchase order can only be edited while PENDING")
+	errLineReceived  = errors.New("line has received units and cannot be removed")
+	errLineExists    = errors.New("SKU is already on this purchase order")
+	errOrderInput    = errors.New("invalid input")
+	errNoProduct     = errors.New("product not found")
+)
+
+// OrderLineInput is one PO line sent through the JSON API
+type OrderLineInput struct {
+	SKU         string  `json:"sku"`
+	Qty         int     `json:"qty"`
+	UnitCost    float64 `json:"unit_cost"`
+	DiscountPct float64 `json:"discount_pct"`
+	TaxRate     float64 `json:"tax_rate"`
+	Currency    string  `json:"currency"` // Defaults to the PO currency
+	Barcode     string  `json:"barcode"`  // Used when the product is created
+	Title       string  `json:"title"`    // Used when the product is created
+}
+
+// poItem builds the PO line for in, inheriting the PO currency. in.Currency
+// must already be valid.
+func (in OrderLineInput) poItem(po models.PurchaseOrder) models.POItem {
+	currency, _ := normalizeCurrency(in.Currency)
+	if currency == "" {
+		currency = po.Currency
+	}
+	return models.POItem{
+		POID:        po.ID,
+		SKU:         in.SKU,
+		QtyOrdered:  in.Qty,
+		Status:      models.POItemStatusPending,
+		UnitCost:    in.UnitCost,
+		DiscountPct: in.DiscountPct,
+		TaxRate:     in.TaxRate,
+		Currency:    currency,
+	}
+}
+
+// OrderLineError explains why a line was rejected
+type OrderLineError struct {
+	Line  int    `json:"line"` // 1-based position in the request
+	SKU   string `json:"sku"`
+	Error string `json:"error"`
+}
+
+// parseDate accepts YYYY-MM-DD or RFC 3339; empty means no date
+func parseDate(s string) (*time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid date %q, use YYYY-MM-DD", s)
+}
+
+// resolveOrderLine finds the product of a PO line. A missing SKU becomes a
+// DRAFT product tied to the supplier when create is set, like the Excel import.
+func resolveOrderLine(tx *gorm.DB, supplier *models.Supplier, in OrderLineInput, create bool) (models.Product, bool, error) {
+	var product models.Product
+	err := tx.Where("sku = ?", in.SKU).First(&product).Error
+	if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) {
+		return product, false, err
+	}
+	if !create {
+		return product, false, errNoProduct
+	}
+
+	title := in.Title
+	if title == "" {
+		title = in.SKU
+	}
+	product = models.Product{
+		SKU:     in.SKU,
+		Barcode: in.Barcode,
+		Title:   title,
+		Status:  models.StatusDraft,
+	}
+	if supplier != nil {
+		product.SupplierID = &supplier.ID
+	}
+	return product, true, tx.Create(&product).Error
+}
+
+// createOrderFromJSON creates a PO from a JSON body, for POST /orders with
+// Content-Type application/json. Body: {"supplier_id" or "supplier_name",
+// "currency", "expected_delivery", "notes", "create_missing",
+// "lines": [OrderLineInput]}.
+// Every line is validated first; any invalid line rejects the whole order.
+func createOrderFromJSON(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		SupplierID       *uint            `json:"supplier_id"`
+		SupplierName     string           `json:"supplier_name"`
+		Currency         string           `json:"currency"` // Defaults to the supplier's
+		ExpectedDelivery string           `json:"expected_delivery"`
+		Notes            string           `json:"notes"`
+		CreateMissing    bool             `json:"create_missing"`
+		Lines            []OrderLineInput `json:"lines"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	var supplier *models.Supplier
+	if payload.SupplierID != nil {
+		supplier = &models.Supplier{}
+		if err := db.DB.First(supplier, *payload.SupplierID).Error; err != nil {
+			http.Error(w, "Supplier not found", http.StatusNotFound)
+			return
+		}
+		payload.SupplierName = supplier.Name
+	} else if payload.SupplierName != "" {
+		// Link to the supplier of that name when there is one
+		var s models.Supplier
+		if err := db.DB.Where("name = ?", strings.TrimSpace(payload.SupplierName)).First(&s).Error; err == nil {
+			supplier = &s
+		}
+	}
+	if strings.TrimSpace(payload.SupplierName) == "" {
+		http.Error(w, "supplier_id or supplier_name required", http.StatusBadRequest)
+		return
+	}
+	expected, err := parseDate(payload.ExpectedDelivery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	currency, err := normalizeCurrency(payload.Currency)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if currency == "" && supplier != nil {
+		currency, _ = normalizeCurrency(supplier.Currency)
+	}
+	if currency == "" {
+		currency = defaultCurrency()
+	}
+
+	po := models.PurchaseOrder{
+		SupplierName:     strings.TrimSpace(payload.SupplierName),
+		Status:           models.POStatusPending,
+		Revision:         1,
+		Currency:         currency,
+		Notes:            payload.Notes,
+		ExpectedDelivery: expected,
+	}
+	if supplier != nil {
+		po.SupplierID = &supplier.ID
+	}
+	var created []string
+	var lineErrors []OrderLineError
+	errInvalidLines := errors.New("invalid lines")
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		seen := make(map[string]bool)
+		for i, in := range payload.Lines {
+			in.SKU = strings.TrimSpace(in.SKU)
+			switch {
+			case in.SKU == "":
+				lineErrors = append(lineErrors, OrderLineError{Line: i + 1, Error: "sku is required"})
+				continue
+			case in.Qty <= 0:
+				lineErrors = append(lineErrors, OrderLineError{Line: i + 1, SKU: in.SKU, Error: "qty must be positive"})
+				continue
+			case seen[in.SKU]:
+				lineErrors = append(lineErrors, OrderLineError{Line: i + 1, SKU: in.SKU, Error: "duplicate sku"})
+				continue
+			}
+			if err := validateCost(in.UnitCost, in.DiscountPct, in.TaxRate); err != nil {
+				lineErrors = append(lineErrors, OrderLineError{Line: i + 1, SKU: in.SKU, Error: err.Error()})
+				continue
+			}
+			if _, err := normalizeCurrency(in.Currency); err != nil {
+				lineErrors = append(lineErrors, OrderLineError{Line: i + 1, SKU: in.SKU, Error: err.Error()})
+				continue
+			}
+			seen[in.SKU] = true
+
+			_, isNew, err := resolveOrderLine(tx, supplier, in, payload.CreateMissing)
+			if err != nil {
+				lineErrors = append(lineErrors, OrderLineError{Line: i + 1, SKU: in.SKU, Error: err.Error()})
+				continue
+			}
+			if isNew {
+				created = append(created, in.SKU)
+			}
+			po.Items = append(po.Items, in.poItem(po))
+		}
+		if len(lineErrors) > 0 {
+			return errInvalidLines
+		}
+		if len(po.Items) == 0 {
+			lineErrors = append(lineErrors, OrderLineError{Error: "at least one line is required"})
+			return errInvalidLines
+		}
+		if err := tx.Create(&po).Error; err != nil {
+			return err
+		}
+		return saveRevision(tx, po, po.Items, []models.POLineChange{}, requestUser(r), "", po.CreatedAt)
+	})
+	if errors.Is(err, errInvalidLines) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": lineErrors})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create PO: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	po.ComputeTotals()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"po_id":            po.ID,
+		"order":            po,
+		"created_products": created,
+		"action":           "created",
+	})
+}
+
+// GetOrderHandler returns one PO with its items
+func GetOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var po models.PurchaseOrder
+	if err := db.DB.Preload("Items.Product").First(&po, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	po.ComputeTotals()
+	json.NewEncoder(w).Encode(po)
+}
+
+// lockEditableOrder locks a PO for editing; only PENDING orders are editable
+func lockEditableOrder(tx *gorm.DB, id string) (models.PurchaseOrder, error) {
+	var po models.PurchaseOrder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&po, id).Error; err != nil {
+		return po, err
+	}
+	if po.Status != models.POStatusPending {
+		return po, errPONotEditable
+	}
+	return po, nil
+}
+
+// orderEditError writes the response for an edit error: 400 for bad input,
+// 404 and 409 for the edit sentinels and 500 for anything else
+func orderEditError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case errors.Is(err, errPONotEditable), errors.Is(err, errLineReceived), errors.Is(err, errLineExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errOrderInput), errors.Is(err, errNoProduct):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to update order: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// UpdateOrderHandler edits the header of a PENDING PO.
+// Body: {"supplier_id", "supplier_name", "currency", "expected_delivery",
+// "notes"}; omitted fields keep their value and an empty expected_delivery
+// clears it. supplier_name can only be set on POs without a linked supplier.
+// Changing the currency moves the lines that used the old one along with it.
+func UpdateOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		SupplierID       *uint   `json:"supplier_id"`
+		SupplierName     *string `json:"supplier_name"`
+		Currency         *string `json:"currency"`
+		ExpectedDelivery *string `json:"expected_delivery"`
+		Notes            *string `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	var po models.PurchaseOrder
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if po, err = lockEditableOrder(tx, chi.URLParam(r, "id")); err != nil {
+			return err
+		}
+		updates := map[string]interface{}{}
+		if payload.SupplierID != nil {
+			var supplier models.Supplier
+			if err := tx.First(&supplier, *payload.SupplierID).Error; err != nil {
+				return fmt.Errorf("%w: supplier %d not found", errOrderInput, *payload.SupplierID)
+			}
+			updates["supplier_id"] = supplier.ID
+			updates["supplier_name"] = supplier.Name
+		} else if payload.SupplierName != nil && strings.TrimSpace(*payload.SupplierName) != "" {
+			if po.SupplierID != nil {
+				return fmt.Errorf("%w: supplier_name follows the linked supplier; set supplier_id instead", errOrderInput)
+			}
+			updates["supplier_name"] = strings.TrimSpace(*payload.SupplierName)
+		}
+		if payload.ExpectedDelivery != nil {
+			expected, err := parseDate(*payload.ExpectedDelivery)
+			if err != nil {
+				return fmt.Errorf("%w: %v", errOrderInput, err)
+			}
+			updates["expected_delivery"] = expected
+		}
+		if payload.Notes != nil {
+			updates["notes"] = *payload.Notes
+		}
+		if payload.Currency != nil {
+			c, err := normalizeCurrency(*payload.Currency)
+			if err != nil {
+				return fmt.Errorf("%w: %v", errOrderInput, err)
+			}
+			if c != "" && c != po.Currency {
+				updates["currency"] = c
+				if err := tx.Model(&models.POItem{}).
+					Where("po_id = ? AND (currency = ? OR currency = '')", po.ID, po.Currency).
+					Update("currency", c).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(&po).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Preload("Items").First(&po, po.ID).Error
+	})
+	if err != nil {
+		orderEditError(w, err)
+		return
+	}
+	po.ComputeTotals()
+	json.NewEncoder(w).Encode(po)
+}
+
+// AddOrderLineHandler adds a line to a PENDING PO. Like the other line
+// edits, it is recorded as a new revision of the PO.
+// Body: OrderLineInput plus "create_missing".
+func AddOrderLineHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		OrderLineInput
+		CreateMissing bool `json:"create_missing"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	payload.SKU = strings.TrimSpace(payload.SKU)
+	if payload.SKU == "" || payload.Qty <= 0 {
+		http.Error(w, "sku and a positive qty are required", http.StatusBadRequest)
+		return
+	}
+	if err := validateCost(payload.UnitCost, payload.DiscountPct, payload.TaxRate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := normalizeCurrency(payload.Currency); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var item models.POItem
+	var isNew bool
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		po, err := lockEditableOrder(tx, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		var current []models.POItem
+		if err := tx.Where("po_id = ?", po.ID).Order("id").Find(&current).Error; err != nil {
+			return err
+		}
+		for _, c := range current {
+			if c.SKU == payload.SKU {
+				return errLineExists
+			}
+		}
+
+		supplier := orderSupplier(tx, po)
+		if _, isNew, err = resolveOrderLine(tx, supplier, payload.OrderLineInput, payload.CreateMissing); err != nil {
+			return fmt.Errorf("%s: %w", payload.SKU, err)
+		}
+
+		item = payload.poItem(po)
+		if err := tx.Create(&item).Error; err != nil {
+			return err
+		}
+		return reviseOrderLines(tx, &po, current, requestUser(r))
+	})
+	if err != nil {
+		orderEditError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"item":            item,
+		"product_created": isNew,
+	})
+}
+
+// UpdateOrderLineHandler changes the quantity or cost of a line.
+// Body: {"qty", "unit_cost", "discount_pct", "tax_rate", "currency"}, all optional.
+func UpdateOrderLineHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Qty         *int     `json:"qty"`
+		UnitCost    *float64 `json:"unit_cost"`
+		DiscountPct *float64 `json:"discount_pct"`
+		TaxRate     *float64 `json:"tax_rate"`
+		Currency    *string  `json:"currency"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.Qty != nil && *payload.Qty <= 0 {
+		http.Error(w, "qty must be positive", http.StatusBadRequest)
+		return
+	}
+	var currency string
+	if payload.Currency != nil {
+		var err error
+		if currency, err = normalizeCurrency(*payload.Currency); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var item models.POItem
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		po, err := lockEditableOrder(tx, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		var current []models.POItem
+		if err := tx.Where("po_id = ?", po.ID).Order("id").Find(&current).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("po_id = ?", po.ID).First(&item, chi.URLParam(r, "lineId")).Error; err != nil {
+			return err
+		}
+		if payload.Qty != nil {
+			item.QtyOrdered = *payload.Qty
+		}
+		if payload.UnitCost != nil {
+			item.UnitCost = *payload.UnitCost
+		}
+		if payload.DiscountPct != nil {
+			item.DiscountPct = *payload.DiscountPct
+		}
+		if payload.TaxRate != nil {
+			item.TaxRate = *payload.TaxRate
+		}
+		if currency != "" {
+			item.Currency = currency
+		}
+		if err := validateCost(item.UnitCost, item.DiscountPct, item.TaxRate); err != nil {
+			return fmt.Errorf("%w: %v", errOrderInput, err)
+		}
+		item.RecalculateStatus()
+		if err := tx.Model(&item).Updates(map[string]interface{}{
+			"qty_ordered":  item.QtyOrdered,
+			"status":       item.Status,
+			"unit_cost":    item.UnitCost,
+			"discount_pct": item.DiscountPct,
+			"tax_rate":     item.TaxRate,
+			"currency":     item.Currency,
+		}).Error; err != nil {
+			return err
+		}
+		return reviseOrderLines(tx, &po, current, requestUser(r))
+	})
+	if err != nil {
+		orderEditError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(item)
+}
+
+// DeleteOrderLineHandler removes a line that has nothing received
+func DeleteOrderLineHandler(w http.ResponseWriter, r *http.Request) {
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		po, err := lockEditableOrder(tx, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		var current []models.POItem
+		if err := tx.Where("po_id = ?", po.ID).Order("id").Find(&current).Error; err != nil {
+			return err
+		}
+		var item models.POItem
+		if err := tx.Where("po_id = ?", po.ID).First(&item, chi.URLParam(r, "lineId")).Error; err != nil {
+			return err
+		}
+		if item.QtyReceived > 0 {
+			return errLineReceived
+		}
+		if err := tx.Delete(&item).Error; err != nil {
+			return err
+		}
+		return reviseOrderLines(tx, &po, current, requestUser(r))
+	})
+	if err != nil {
+		orderEditError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
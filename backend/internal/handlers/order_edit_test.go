@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"backroom/internal/models"
+	"testing"
+	"time"
+)
+
+// TestParseDate checks the accepted date layouts
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string // RFC 3339, empty for no date
+		wantErr bool
+	}{
+		{"", "", false},
+		{"   ", "", false},
+		{"2026-03-05", "2026-03-05T00:00:00Z", false},
+		{" 2026-03-05 ", "2026-03-05T00:00:00Z", false},
+		{"2026-03-05T10:30:00-06:00", "2026-03-05T10:30:00-06:00", false},
+		{"05/03/2026", "", true},
+		{"2026-13-01", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseDate(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("date = %v, want none", got)
+				}
+				return
+			}
+			if got == nil || got.Format(time.RFC3339) != tt.want {
+				t.Errorf("date = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOrderLinePOItem checks that a line inherits the PO currency unless it
+// names its own
+func TestOrderLinePOItem(t *testing.T) {
+	po := models.PurchaseOrder{ID: 7, Currency: "MXN"}
+	tests := []struct {
+		name     string
+		currency string
+		want     string
+	}{
+		{"inherited", "", "MXN"},
+		{"own", "usd", "USD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := OrderLineInput{SKU: "A-1", Qty: 3, UnitCost: 10.5, DiscountPct: 5, TaxRate: 16, Currency: tt.currency}
+			item := in.poItem(po)
+			if item.Currency != tt.want {
+				t.Errorf("currency = %s, want %s", item.Currency, tt.want)
+			}
+			if item.POID != 7 || item.SKU != "A-1" || item.QtyOrdered != 3 || item.QtyReceived != 0 ||
+				item.Status != models.POItemStatusPending || item.UnitCost != 10.5 || item.DiscountPct != 5 || item.TaxRate != 16 {
+				t.Errorf("item = %+v", item)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"backroom/internal/db"
+	"backroom/internal/models"
+	"backroom/internal/pdf"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// exportColumn is one column of an exported PO
+type exportColumn struct {
+	Header  string
+	Field   string  // MappingConfig field key, supplier layout only
+	Index   int     // 0-based spreadsheet column
+	Width   float64 // PDF width in points
+	Numeric bool    // Right-aligned
+	Value   func(models.POItem) interface{}
+}
+
+func lineTotal(i models.POItem) float64 {
+	return float64(i.QtyOrdered) * i.NetUnitCost() * (1 + i.TaxRate/100)
+}
+
+var (
+	colSKU     = func(i models.POItem) interface{} { return i.SKU }
+	colBarcode = func(i models.POItem) interface{} { return i.Product.Barcode }
+	colTitle   = func(i models.POItem) interface{} { return i.Product.Title }
+	colBrand   = func(i models.POItem) interface{} { return i.Product.Brand }
+	colQty     = func(i models.POItem) interface{} { return i.QtyOrdered }
+	colCost    = func(i models.POItem) interface{} { return i.UnitCost }
+)
+
+// defaultExportColumns is our own PO layout
+func defaultExportColumns() []exportColumn {
+	cols := []exportColumn{
+		{Header: "SKU", Width: 75, Value: colSKU},
+		{Header: "Barcode", Width: 80, Value: colBarcode},
+		{Header: "Description", Width: 150, Value: colTitle},
+		{Header: "Qty", Width: 35, Numeric: true, Value: colQty},
+		{Header: "Unit Cost", Width: 60, Numeric: true, Value: colCost},
+		{Header: "Disc %", Width: 40, Numeric: true, Value: func(i models.POItem) interface{} { return i.DiscountPct }},
+		{Header: "Tax %", Width: 40, Numeric: true, Value: func(i models.POItem) interface{} { return i.TaxRate }},
+		{Header: "Total", Width: 60, Numeric: true, Value: func(i models.POItem) interface{} { return lineTotal(i) }},
+	}
+	for i := range cols {
+		cols[i].Index = i
+	}
+	return cols
+}
+
+// supplierExportColumns places the fields where the supplier's own sheets
+// have them, so the file reads like theirs and imports back with the same
+// MappingConfig. Unmapped optional columns (index -1) are left out.
+func supplierExportColumns(m models.MappingConfig) []exportColumn {
+	cols := []exportColumn{
+		{Header: "SKU", Field: "sku", Index: m.ColSKU, Width: 90, Value: colSKU},
+		{Header: "Qty", Field: "qty", Index: m.ColQty, Width: 45, Numeric: true, Value: colQty},
+	}
+	used := map[int]bool{m.ColSKU: true, m.ColQty: true}
+	optional := func(c exportColumn) {
+		if c.Index >= 0 && !used[c.Index] {
+			used[c.Index] = true
+			cols = append(cols, c)
+		}
+	}
+	optional(exportColumn{Header: "Description", Field: "title", Index: m.ColTitle, Value: colTitle})
+	optional(exportColumn{Header: "Barcode", Field: "barcode", Index: m.ColBarcode, Width: 90, Value: colBarcode})
+	optional(exportColumn{Header: "Brand", Field: "brand", Index: m.ColBrand, Width: 80, Value: colBrand})
+	if m.ColCost >= 0 {
+		optional(exportColumn{Header: "Cost", Field: "cost", Index: m.ColCost, Width: 70, Numeric: true, Value: colCost})
+	} else {
+		optional(exportColumn{Header: "Cost", Field: "price", Index: m.ColPrice, Width: 70, Numeric: true, Value: colCost})
+	}
+	sort.Slice(cols, func(i, j int) bool { return cols[i].Index < cols[j].Index })
+
+	// Use the supplier's own header names where the mapping has them
+	for i, c := range cols {
+		if name := m.Columns[c.Field]; name != "" {
+			cols[i].Header = name
+		}
+	}
+
+	// The description takes whatever width is left
+	rest := 540.0
+	for _, c := range cols {
+		rest -= c.Width
+	}
+	for i := range cols {
+		if cols[i].Width == 0 {
+			cols[i].Width = rest
+		}
+	}
+	return cols
+}
+
+// orderSupplier loads the supplier of a PO, or nil when it has none
+func orderSupplier(tx *gorm.DB, po models.PurchaseOrder) *models.Supplier {
+	if po.SupplierID == nil {
+		return nil
+	}
+	var supplier models.Supplier
+	if err := tx.First(&supplier, *po.SupplierID).Error; err != nil {
+		return nil
+	}
+	return &supplier
+}
+
+func supplierContacts(s *models.Supplier) []models.Contact {
+	var contacts []models.Contact
+	if s != nil && len(s.Contacts) > 0 {
+		json.Unmarshal(s.Contacts, &contacts)
+	}
+	return contacts
+}
+
+func formatDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+func cellText(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	case int:
+		return strconv.Itoa(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// ExportOrderHandler downloads a PO to send to the supplier.
+// ?format=xlsx (default) or pdf; ?layout=supplier uses the supplier's
+// MappingConfig column layout instead of ours.
+func ExportOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var po models.PurchaseOrder
+	if err := db.DB.Preload("Items", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Preload("Items.Product").First(&po, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	po.ComputeTotals()
+	supplier := orderSupplier(db.DB, po)
+
+	cols := defaultExportColumns()
+	headerRow := -1 // Our layout puts the PO details above the table
+	if r.URL.Query().Get("layout") == "supplier" {
+		var mapping models.MappingConfig
+		if supplier == nil || len(supplier.MappingConfig) == 0 || json.Unmarshal(supplier.MappingConfig, &mapping) != nil {
+			http.Error(w, "Supplier has no column mapping configured", http.StatusBadRequest)
+			return
+		}
+		if mapping.ColSKU < 0 || mapping.ColQty < 0 {
+			http.Error(w, "Supplier mapping has no SKU or quantity column", http.StatusBadRequest)
+			return
+		}
+		cols = supplierExportColumns(mapping)
+		headerRow = mapping.HeaderRow
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "xlsx":
+		writeOrderXLSX(w, po, supplier, cols, headerRow)
+	case "pdf":
+		writeOrderPDF(w, po, supplier, cols)
+	default:
+		http.Error(w, "format must be xlsx or pdf", http.StatusBadRequest)
+	}
+}
+
+// orderDetails are the label/value pairs printed above the lines
+func orderDetails(po models.PurchaseOrder, supplier *models.Supplier) [][2]string {
+	details := [][2]string{
+		{"Purchase Order", fmt.Sprintf("#%d", po.ID)},
+		{"Date", po.CreatedAt.Format("2006-01-02")},
+		{"Supplier", po.SupplierName},
+	}
+	for _, c := range supplierContacts(supplier) {
+		label := c.Label
+		if label == "" {
+			label = c.Type
+		}
+		details = append(details, [2]string{label, c.Value})
+	}
+	if po.ExpectedDelivery != nil {
+		details = append(details, [2]string{"Expected Delivery", formatDate(po.ExpectedDelivery)})
+	}
+	if po.Notes != "" {
+		details = append(details, [2]string{"Notes", po.Notes})
+	}
+	return details
+}
+
+func writeOrderXLSX(w http.ResponseWriter, po models.PurchaseOrder, supplier *models.Supplier, cols []exportColumn, headerRow int) {
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := "Purchase Order"
+	f.SetSheetName(f.GetSheetName(0), sheet)
+	bold, _ := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	money, _ := f.NewStyle(&excelize.Style{NumFmt: 4}) // #,##0.00
+
+	// Details go above the table in our layout, or on their own sheet when
+	// the supplier's header row position must be kept
+	detailSheet, row := sheet, 1
+	if headerRow >= 0 {
+		detailSheet = "Details"
+		f.NewSheet(detailSheet)
+	}
+	for _, d := range orderDetails(po, supplier) {
+		cell, _ := excelize.CoordinatesToCellName(1, row)
+		f.SetSheetRow(detailSheet, cell, &[]interface{}{d[0], d[1]})
+		f.SetCellStyle(detailSheet, cell, cell, bold)
+		row++
+	}
+	if headerRow < 0 {
+		headerRow = row // One blank row, 0-based
+	}
+
+	// Table
+	headerLine := headerRow + 1
+	for _, c := range cols {
+		cell, _ := excelize.CoordinatesToCellName(c.Index+1, headerLine)
+		f.SetCellValue(sheet, cell, c.Header)
+		f.SetCellStyle(sheet, cell, cell, bold)
+	}
+	for i, item := range po.Items {
+		for _, c := range cols {
+			cell, _ := excelize.CoordinatesToCellName(c.Index+1, headerLine+1+i)
+			v := c.Value(item)
+			f.SetCellValue(sheet, cell, v)
+			if _, ok := v.(float64); ok {
+				f.SetCellStyle(sheet, cell, cell, money)
+			}
+		}
+	}
+
+	// Totals go below the lines, or below the details on their own sheet
+	if detailSheet == sheet {
+		row = headerLine + len(po.Items) + 2
+	} else {
+		row++
+	}
+	for _, t := range po.Totals {
+		for _, v := range [][2]interface{}{
+			{"Subtotal " + t.Currency, t.Subtotal},
+			{"Discount " + t.Currency, t.Discount},
+			{"Tax " + t.Currency, t.Tax},
+			{"Total " + t.Currency, t.Total},
+		} {
+			label, _ := excelize.CoordinatesToCellName(1, row)
+			value, _ := excelize.CoordinatesToCellName(2, row)
+			f.SetSheetRow(detailSheet, label, &[]interface{}{v[0], v[1]})
+			f.SetCellStyle(detailSheet, label, label, bold)
+			f.SetCellStyle(detailSheet, value, value, money)
+			row++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="po_%d.xlsx"`, po.ID))
+	f.Write(w)
+}
+
+func writeOrderPDF(w http.ResponseWriter, po models.PurchaseOrder, supplier *models.Supplier, cols []exportColumn) {
+	const (
+		margin  = 36.0
+		size    = 9.0
+		rowH    = 14.0
+		bottom  = pdf.PageHeight - margin
+		colPad  = 4.0
+		labelW  = 100.0
+		titleSz = 16.0
+	)
+	doc := pdf.New()
+	doc.AddPage()
+
+	y := margin + titleSz
+	doc.Text(margin, y, titleSz, true, fmt.Sprintf("Purchase Order #%d", po.ID))
+	y += rowH * 1.5
+	for _, d := range orderDetails(po, supplier)[1:] {
+		doc.Text(margin, y, size, true, d[0])
+		doc.Text(margin+labelW, y, size, false, pdf.Fit(d[1], size, false, pdf.PageWidth-2*margin-labelW))
+		y += rowH
+	}
+	y += rowH
+
+	tableHeader := func() {
+		doc.FillRect(margin, y-size-3, pdf.PageWidth-2*margin, rowH, 0.9)
+		x := margin
+		for _, c := range cols {
+			if c.Numeric {
+				doc.TextRight(x+c.Width-colPad, y, size, true, c.Header)
+			} else {
+				doc.Text(x+colPad, y, size, true, c.Header)
+			}
+			x += c.Width
+		}
+		y += rowH
+	}
+	tableHeader()
+	for _, item := range po.Items {
+		if y > bottom {
+			doc.AddPage()
+			y = margin + size
+			tableHeader()
+		}
+		x := margin
+		for _, c := range cols {
+			text := pdf.Fit(cellText(c.Value(item)), size, false, c.Width-2*colPad)
+			if c.Numeric {
+				doc.TextRight(x+c.Width-colPad, y, size, false, text)
+			} else {
+				doc.Text(x+colPad, y, size, false, text)
+			}
+			x += c.Width
+		}
+		doc.Line(margin, y+4, pdf.PageWidth-margin, y+4, 0.25)
+		y += rowH
+	}
+
+	// Totals, right-aligned under the table
+	y += rowH / 2
+	right := pdf.PageWidth - margin - colPad
+	for _, t := range po.Totals {
+		for _, v := range []struct {
+			label  string
+			amount float64
+			bold   bool
+		}{
+			{"Subtotal", t.Subtotal, false},
+			{"Discount", -t.Discount, false},
+			{"Tax", t.Tax, false},
+			{"Total " + t.Currency, t.Total, true},
+		} {
+			if y > bottom {
+				doc.AddPage()
+				y = margin + size
+			}
+			doc.TextRight(right-90, y, size, v.bold, v.label)
+			doc.TextRight(right, y, size, v.bold, cellText(v.amount))
+			y += rowH
+		}
+		y += rowH / 2
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="po_%d.pdf"`, po.ID))
+	doc.WriteTo(w)
+}
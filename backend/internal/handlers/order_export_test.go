@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"backroom/internal/models"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestSupplierExportColumns checks that the supplier layout follows the
+// mapping: column order, header names, unmapped and clashing columns left
+// out, and the price column standing in for an unmapped cost
+func TestSupplierExportColumns(t *testing.T) {
+	unmapped := models.MappingConfig{ColSKU: -1, ColTitle: -1, ColBarcode: -1, ColQty: -1, ColPrice: -1, ColBrand: -1, ColCost: -1}
+	with := func(f func(*models.MappingConfig)) models.MappingConfig {
+		m := unmapped
+		f(&m)
+		return m
+	}
+
+	tests := []struct {
+		name    string
+		mapping models.MappingConfig
+		want    string // field@index:header, by index
+	}{
+		{
+			name: "supplier order and names",
+			mapping: with(func(m *models.MappingConfig) {
+				m.ColQty, m.ColTitle, m.ColSKU, m.ColPrice, m.ColCost = 0, 1, 2, 3, 4
+				m.Columns = map[string]string{"sku": "Clave", "qty": "Piezas"}
+			}),
+			want: "qty@0:Piezas title@1:Description sku@2:Clave cost@4:Cost",
+		},
+		{
+			name: "price stands in for cost",
+			mapping: with(func(m *models.MappingConfig) {
+				m.ColSKU, m.ColQty, m.ColBrand, m.ColPrice = 0, 1, 2, 5
+				m.Columns = map[string]string{"price": "Precio"}
+			}),
+			want: "sku@0:SKU qty@1:Qty brand@2:Brand price@5:Precio",
+		},
+		{
+			name: "column 0 and clashes",
+			mapping: with(func(m *models.MappingConfig) {
+				m.ColBarcode, m.ColSKU, m.ColQty, m.ColTitle, m.ColBrand = 0, 1, 2, 1, 2
+			}),
+			want: "barcode@0:Barcode sku@1:SKU qty@2:Qty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cols := supplierExportColumns(tt.mapping)
+			got := make([]string, len(cols))
+			width := 0.0
+			for i, c := range cols {
+				got[i] = fmt.Sprintf("%s@%d:%s", c.Field, c.Index, c.Header)
+				width += c.Width
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("columns = %s, want %s", strings.Join(got, " "), tt.want)
+			}
+			if width > 540 {
+				t.Errorf("columns are %v points wide", width)
+			}
+		})
+	}
+}
@@ -0,0 +1,548 @@
+package handlers
+
+import (
+	"backroom/internal/db"
+	"backroom/internal/inventory"
+	"backroom/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errPreviewStale    = errors.New("the purchase order changed after the preview; preview the file again")
+	errImportDuplicate = errors.New("a purchase order from this file already exists")
+)
+
+// orderImportLine is one PO line read from a supplier file
+type orderImportLine struct {
+	Row         int     `json:"row"` // 1-based spreadsheet row
+	SKU         string  `json:"sku"`
+	Qty         int     `json:"qty"`
+	UnitCost    float64 `json:"unit_cost"`
+	Barcode     string  `json:"barcode"`
+	Title       string  `json:"title"`
+	Exists      bool    `json:"exists"`       // false: a DRAFT product will be created
+	FillBarcode bool    `json:"fill_barcode"` // The existing product gets this barcode
+}
+
+// skippedRow is a file row that was not imported. Error rows were
+// rejected as invalid; the others were left out on purpose (e.g. zero qty).
+type skippedRow struct {
+	Row    int    `json:"row"`
+	SKU    string `json:"sku,omitempty"`
+	Reason string `json:"reason"`
+	Error  bool   `json:"error"`
+}
+
+// orderImport is a parsed PO file, ready to be written
+type orderImport struct {
+	SupplierID   uint              `json:"supplier_id"`
+	SupplierName string            `json:"supplier_name"`
+	FileName     string            `json:"file_name"`
+	Currency     string            `json:"currency"`
+	Lines        []orderImportLine `json:"lines"`
+	Skipped      []skippedRow      `json:"skipped"`
+	Warnings     []string          `json:"mapping_warnings"` // From resolving header names
+	mapping      models.MappingConfig
+	file         *sheetData
+}
+
+// orderImportOptions are the choices made when writing an import
+type orderImportOptions struct {
+	Overwrite     bool   // Replace the PO imported from the same file
+	ReceivedLines string // Passed on to overwriteOrder
+	Strict        bool   // Write nothing if any row was rejected
+	User          string
+	Revision      *int // With Overwrite: the PO revision previewed (0 if there was no PO); nil skips the check
+}
+
+// errorReport saves the annotated copy of the file, see saveImportReport
+func (imp *orderImport) errorReport() string {
+	return saveImportReport(imp.FileName, imp.file, imp.mapping.HeaderRow, imp.Skipped)
+}
+
+func (imp *orderImport) items() []models.POItem {
+	items := make([]models.POItem, len(imp.Lines))
+	for i, l := range imp.Lines {
+		items[i] = models.POItem{
+			SKU:        l.SKU,
+			QtyOrdered: l.Qty,
+			Status:     models.POItemStatusPending,
+			UnitCost:   l.UnitCost,
+			Currency:   imp.Currency,
+		}
+	}
+	return items
+}
+
+// noLinesError is the response when a file has no usable lines
+func (imp *orderImport) noLinesError(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":            fmt.Sprintf("No valid items found using Supplier Mapping (Head:%d, SKU:%d, Qty:%d).", imp.mapping.HeaderRow, imp.mapping.ColSKU, imp.mapping.ColQty),
+		"skipped":          imp.Skipped,
+		"mapping_warnings": imp.Warnings,
+		"error_report":     imp.errorReport(),
+	})
+}
+
+// cellAt returns the trimmed cell of a row, "" when out of bounds
+func cellAt(row []string, col int) string {
+	if col < 0 || col >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[col])
+}
+
+// parseBarcode undoes Excel's scientific notation for long numeric codes
+func parseBarcode(raw string) string {
+	if fVal, err := strconv.ParseFloat(raw, 64); err == nil && strings.Contains(raw, "E") {
+		return strconv.FormatFloat(fVal, 'f', 0, 64)
+	}
+	return raw
+}
+
+// parseOrderRows reads PO lines from sheet rows with a supplier mapping
+func parseOrderRows(rows [][]string, mapping models.MappingConfig) ([]orderImportLine, []skippedRow) {
+	lines := []orderImportLine{}
+	skipped := []skippedRow{}
+
+	for i := mapping.HeaderRow + 1; i < len(rows); i++ {
+		row := rows[i]
+		sku := cellAt(row, mapping.ColSKU)
+		if sku == "" {
+			// Blank rows are not worth reporting
+			if strings.TrimSpace(strings.Join(row, "")) != "" {
+				skipped = append(skipped, skippedRow{Row: i + 1, Reason: "missing SKU", Error: true})
+			}
+			continue
+		}
+
+		qty := 0
+		if val := cellAt(row, mapping.ColQty); val != "" {
+			if q, err := strconv.Atoi(val); err == nil {
+				qty = q
+			} else if fVal, err := strconv.ParseFloat(val, 64); err == nil {
+				qty = int(fVal)
+			} else {
+				skipped = append(skipped, skippedRow{Row: i + 1, SKU: sku, Reason: fmt.Sprintf("unparseable qty %q", val), Error: true})
+				continue
+			}
+		}
+		if qty < 0 {
+			skipped = append(skipped, skippedRow{Row: i + 1, SKU: sku, Reason: "negative qty", Error: true})
+			continue
+		}
+		if qty == 0 {
+			skipped = append(skipped, skippedRow{Row: i + 1, SKU: sku, Reason: "zero qty"})
+			continue
+		}
+
+		line := orderImportLine{Row: i + 1, SKU: sku, Qty: qty, Title: "Imported " + sku}
+		if mapping.ColBarcode >= 0 {
+			line.Barcode = parseBarcode(cellAt(row, mapping.ColBarcode))
+		}
+		if title := cellAt(row, mapping.ColTitle); title != "" {
+			line.Title = title
+		}
+		if raw := cellAt(row, mapping.ColCost); mapping.ColCost >= 0 && raw != "" {
+			val, ok := parseAmount(raw)
+			if !ok || val < 0 {
+				skipped = append(skipped, skippedRow{Row: i + 1, SKU: sku, Reason: fmt.Sprintf("invalid unit cost %q", raw), Error: true})
+				continue
+			}
+			line.UnitCost = val
+		}
+		lines = append(lines, line)
+	}
+	return lines, skipped
+}
+
+// loadOrderImport parses the PO file (XLSX, CSV or TSV) of a multipart
+// request (supplier_id, file) with the supplier's MappingConfig. It reads the database but never
+// writes to it. On error the returned status is the HTTP status to send.
+func loadOrderImport(r *http.Request) (*orderImport, int, error) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB
+		return nil, http.StatusBadRequest, errors.New("file too big")
+	}
+	supplierID := r.FormValue("supplier_id")
+	if supplierID == "" {
+		return nil, http.StatusBadRequest, errors.New("supplier ID required")
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("invalid file")
+	}
+	defer file.Close()
+	source, err := io.ReadAll(file)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("invalid file")
+	}
+
+	var supplier models.Supplier
+	if err := db.DB.First(&supplier, "id = ?", supplierID).Error; err != nil {
+		return nil, http.StatusNotFound, errors.New("supplier not found")
+	}
+	if len(supplier.MappingConfig) == 0 {
+		return nil, http.StatusBadRequest, errors.New("supplier has no template/mapping defined; configure the supplier first")
+	}
+	imp := &orderImport{
+		SupplierID:   supplier.ID,
+		SupplierName: supplier.Name,
+		FileName:     header.Filename,
+	}
+	if imp.Currency, _ = normalizeCurrency(supplier.Currency); imp.Currency == "" {
+		imp.Currency = defaultCurrency()
+	}
+	if err := json.Unmarshal(supplier.MappingConfig, &imp.mapping); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("invalid mapping config in supplier")
+	}
+
+	if imp.file, err = readSheet(source, header.Filename, imp.mapping.Sheet); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	imp.mapping, imp.Warnings = resolveMapping(imp.mapping, imp.file.Rows)
+	imp.Lines, imp.Skipped = parseOrderRows(imp.file.Rows, imp.mapping)
+
+	// Which SKUs exist, and which would get a barcode filled in
+	skus := make([]string, len(imp.Lines))
+	for i, l := range imp.Lines {
+		skus[i] = l.SKU
+	}
+	var existing []models.Product
+	if len(skus) > 0 {
+		db.DB.Select("sku", "barcode").Where("sku IN ?", skus).Find(&existing)
+	}
+	barcodes := make(map[string]string, len(existing))
+	for _, p := range existing {
+		barcodes[p.SKU] = p.Barcode
+	}
+	for i := range imp.Lines {
+		l := &imp.Lines[i]
+		current, ok := barcodes[l.SKU]
+		l.Exists = ok
+		l.FillBarcode = ok && current == "" && l.Barcode != ""
+	}
+	return imp, http.StatusOK, nil
+}
+
+// importProducts creates the DRAFT products of missing SKUs and fills empty
+// barcodes, returning the found_skus_list and missing_skus of the response
+func importProducts(tx *gorm.DB, imp *orderImport) ([]string, []string, error) {
+	found := []string{}
+	missing := []string{}
+	for _, l := range imp.Lines {
+		var product models.Product
+		err := tx.Where("sku = ?", l.SKU).First(&product).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			missing = append(missing, l.SKU)
+			product = models.Product{
+				SKU:        l.SKU,
+				Barcode:    l.Barcode,
+				Title:      l.Title,
+				SupplierID: &imp.SupplierID,
+				Status:     models.StatusDraft,
+			}
+			if err := tx.Create(&product).Error; err != nil {
+				return nil, nil, fmt.Errorf("row %d (%s): %w", l.Row, l.SKU, err)
+			}
+			found = append(found, l.SKU+" (created)")
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if product.Barcode == "" && l.Barcode != "" {
+			product.Barcode = l.Barcode
+			if err := tx.Omit(inventory.StockColumns...).Save(&product).Error; err != nil {
+				return nil, nil, fmt.Errorf("row %d (%s): %w", l.Row, l.SKU, err)
+			}
+		}
+		found = append(found, l.SKU)
+	}
+	return found, missing, nil
+}
+
+// findImportDuplicate returns the PO already created from the same file
+func findImportDuplicate(tx *gorm.DB, imp *orderImport) (*models.PurchaseOrder, error) {
+	var po models.PurchaseOrder
+	err := tx.Where("supplier_id = ? AND file_name = ?", imp.SupplierID, imp.FileName).First(&po).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &po, nil
+}
+
+// importDuplicateError is the 409 for a file imported before, sent unless
+// overwrite is explicitly requested
+func importDuplicateError(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"duplicate": true,
+		"message":   "A Purchase Order from this file already exists.",
+	})
+}
+
+// applyOrderImport writes a parsed PO file in one transaction: the DRAFT
+// products and a new PO as revision 1, or with Overwrite a new revision of
+// the PO from the same file (PENDING POs only). Rejected rows are reported
+// with a link to the annotated file. Reports whether the import was written.
+func applyOrderImport(w http.ResponseWriter, imp *orderImport, opts orderImportOptions) bool {
+	rejected := rejectedRows(imp.Skipped)
+	if opts.Strict && len(rejected) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":        fmt.Sprintf("%d rows were rejected; nothing was imported.", len(rejected)),
+			"skipped":      imp.Skipped,
+			"error_report": imp.errorReport(),
+		})
+		return false
+	}
+
+	existing, err := findImportDuplicate(db.DB, imp)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if existing != nil && !opts.Overwrite {
+		importDuplicateError(w)
+		return false
+	}
+
+	var found, missing []string
+	var changes []models.POLineChange
+	po := models.PurchaseOrder{
+		SupplierID:   &imp.SupplierID,
+		SupplierName: imp.SupplierName,
+		FileName:     imp.FileName,
+		Status:       models.POStatusPending,
+		Revision:     1,
+		Currency:     imp.Currency,
+		Items:        imp.items(),
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		// Imports of one supplier run one at a time, so two commits of the
+		// same file cannot both find no duplicate and create a PO each
+		var supplier models.Supplier
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&supplier, imp.SupplierID).Error; err != nil {
+			return err
+		}
+		var err error
+		if existing, err = findImportDuplicate(tx, imp); err != nil {
+			return err
+		}
+		if existing != nil && !opts.Overwrite {
+			return errImportDuplicate
+		}
+		if found, missing, err = importProducts(tx, imp); err != nil {
+			return err
+		}
+		if existing == nil {
+			if err := tx.Create(&po).Error; err != nil {
+				return err
+			}
+			return saveRevision(tx, po, po.Items, []models.POLineChange{}, opts.User, "", po.CreatedAt)
+		}
+
+		// Lock the PO so receipts being finalized concurrently are either
+		// fully before or after the rewrite
+		po = *existing
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&po, po.ID).Error; err != nil {
+			return err
+		}
+		if opts.Revision != nil && *opts.Revision != po.Revision {
+			return errPreviewStale
+		}
+		changes, err = overwriteOrder(tx, &po, imp.items(), imp.FileName, opts.User, opts.ReceivedLines)
+		return err
+	})
+	if errors.Is(err, errReceivedLinesDropped) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"received_lines_dropped": droppedReceivedLines(changes),
+			"changes":                changes,
+			"message":                "The new file drops lines that already have received units. Resend with received_lines=keep or received_lines=drop.",
+		})
+		return false
+	}
+	if errors.Is(err, errImportDuplicate) {
+		importDuplicateError(w)
+		return false
+	}
+	if errors.Is(err, errPreviewStale) {
+		http.Error(w, fmt.Sprintf("Purchase order %d is at revision %d: %v", po.ID, po.Revision, err), http.StatusConflict)
+		return false
+	}
+	if errors.Is(err, errPONotEditable) {
+		http.Error(w, fmt.Sprintf("Purchase order %d is %s: %v", po.ID, po.Status, err), http.StatusConflict)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Failed to save PO: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	summary := map[string]interface{}{
+		"po_id":            po.ID,
+		"items_count":      len(po.Items),
+		"found_skus":       len(found),
+		"found_skus_list":  found,
+		"missing_skus":     missing,
+		"skipped":          imp.Skipped,
+		"rejected_rows":    len(rejected),
+		"mapping_warnings": imp.Warnings,
+		"error_report":     imp.errorReport(),
+		"action":           "created",
+	}
+	if existing != nil {
+		summary["action"] = "updated"
+		summary["revision"] = po.Revision
+		summary["changes"] = changes
+	}
+	json.NewEncoder(w).Encode(summary)
+	return true
+}
+
+// orderPreviews holds previewed imports until they are committed. Tokens
+// live in this process's memory only: a restart loses them, and with more
+// than one backend instance a commit must reach the one that previewed.
+var orderPreviews = struct {
+	sync.Mutex
+	byToken map[string]orderPreview
+}{byToken: make(map[string]orderPreview)}
+
+const orderPreviewTTL = 30 * time.Minute
+
+type orderPreview struct {
+	imp      *orderImport
+	revision int // Of the PO the file would overwrite; 0 if there was none
+	expires  time.Time
+}
+
+// PreviewOrderHandler is a dry run of the Excel PO import: the same
+// multipart form as CreateOrderHandler, answered with the lines it would
+// create, the SKUs that would become DRAFT products, the skipped rows and
+// whether the file was imported before, without writing anything. The
+// returned token commits exactly this result via CommitOrderHandler.
+func PreviewOrderHandler(w http.ResponseWriter, r *http.Request) {
+	imp, status, err := loadOrderImport(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if len(imp.Lines) == 0 {
+		imp.noLinesError(w)
+		return
+	}
+
+	createSKUs := []string{}
+	for _, l := range imp.Lines {
+		if !l.Exists {
+			createSKUs = append(createSKUs, l.SKU)
+		}
+	}
+	response := map[string]interface{}{
+		"import":       imp,
+		"create_skus":  createSKUs,
+		"duplicate":    nil,
+		"error_report": imp.errorReport(),
+	}
+
+	existing, err := findImportDuplicate(db.DB, imp)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	revision := 0
+	if existing != nil {
+		revision = existing.Revision
+		// What an overwrite would change
+		var current []models.POItem
+		db.DB.Where("po_id = ?", existing.ID).Order("id").Find(&current)
+		changes := diffPOLines(current, imp.items())
+		response["duplicate"] = map[string]interface{}{
+			"po_id":                  existing.ID,
+			"status":                 existing.Status,
+			"editable":               existing.Status == models.POStatusPending, // Overwrite allowed
+			"revision":               existing.Revision,
+			"changes":                changes,
+			"received_lines_dropped": droppedReceivedLines(changes),
+		}
+	}
+
+	token := uuid.NewString()
+	expires := time.Now().Add(orderPreviewTTL)
+	orderPreviews.Lock()
+	for t, p := range orderPreviews.byToken {
+		if time.Now().After(p.expires) {
+			delete(orderPreviews.byToken, t)
+		}
+	}
+	orderPreviews.byToken[token] = orderPreview{imp: imp, revision: revision, expires: expires}
+	orderPreviews.Unlock()
+
+	response["token"] = token
+	response["expires_at"] = expires
+	json.NewEncoder(w).Encode(response)
+}
+
+// CommitOrderHandler writes a previewed import.
+// Body: {"token", "overwrite", "received_lines", "strict"}. The token is
+// claimed for the length of the commit, so a repeated request gets 404, and
+// stays valid when the commit is refused, e.g. to retry with overwrite. An
+// overwrite is refused with 409 if the PO was changed after the preview.
+func CommitOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Token         string `json:"token"`
+		Overwrite     bool   `json:"overwrite"`
+		ReceivedLines string `json:"received_lines"`
+		Strict        bool   `json:"strict"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	orderPreviews.Lock()
+	preview, ok := orderPreviews.byToken[payload.Token]
+	delete(orderPreviews.byToken, payload.Token)
+	if ok && time.Now().After(preview.expires) {
+		ok = false
+	}
+	orderPreviews.Unlock()
+	if !ok {
+		http.Error(w, "Preview not found or expired", http.StatusNotFound)
+		return
+	}
+
+	opts := orderImportOptions{
+		Overwrite:     payload.Overwrite,
+		ReceivedLines: payload.ReceivedLines,
+		Strict:        payload.Strict,
+		User:          requestUser(r),
+		Revision:      &preview.revision,
+	}
+	if !applyOrderImport(w, preview.imp, opts) {
+		orderPreviews.Lock()
+		orderPreviews.byToken[payload.Token] = preview
+		orderPreviews.Unlock()
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCommitOrderToken checks that a commit claims its preview token, that
+// a refused commit puts it back for a retry and that expired or unknown
+// tokens are not found. A strict commit of a file with rejected rows is
+// refused before anything is written.
+func TestCommitOrderToken(t *testing.T) {
+	imp := &orderImport{
+		FileName: "pedido.xlsx",
+		Lines:    []orderImportLine{{Row: 2, SKU: "A-1", Qty: 3}},
+		Skipped:  []skippedRow{{Row: 3, SKU: "A-2", Reason: "Invalid qty", Error: true}},
+	}
+	orderPreviews.Lock()
+	orderPreviews.byToken["valid"] = orderPreview{imp: imp, expires: time.Now().Add(time.Minute)}
+	orderPreviews.byToken["expired"] = orderPreview{imp: imp, expires: time.Now().Add(-time.Minute)}
+	orderPreviews.Unlock()
+	defer func() {
+		orderPreviews.Lock()
+		delete(orderPreviews.byToken, "valid")
+		delete(orderPreviews.byToken, "expired")
+		orderPreviews.Unlock()
+	}()
+
+	commit := func(body string) int {
+		w := httptest.NewRecorder()
+		CommitOrderHandler(w, httptest.NewRequest(http.MethodPost, "/orders/commit", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := commit(`{"token": "valid", "strict": true}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("strict commit = %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+	orderPreviews.Lock()
+	_, kept := orderPreviews.byToken["valid"]
+	orderPreviews.Unlock()
+	if !kept {
+		t.Error("token of a refused commit was not put back")
+	}
+	if code := commit(`{"token": "valid", "strict": true}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("retried strict commit = %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"expired", `{"token": "expired"}`, http.StatusNotFound},
+		{"unknown", `{"token": "nope"}`, http.StatusNotFound},
+		{"bad payload", `{"token": `, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := commit(tt.body); code != tt.want {
+				t.Errorf("commit = %d, want %d", code, tt.want)
+			}
+		})
+	}
+	orderPreviews.Lock()
+	_, kept = orderPreviews.byToken["expired"]
+	orderPreviews.Unlock()
+	if kept {
+		t.Error("expired token is still stored")
+	}
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"backroom/internal/db"
+	"backroom/internal/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+var errReceivedLinesDropped = errors.New("lines with received units are missing from the new file")
+
+// What an overwrite does with received lines missing from the new file
+const (
+	receivedLinesKeep = "keep" // Keep them, ordered = received
+	receivedLinesDrop = "drop" // Delete them anyway
+)
+
+func revisionLines(items []models.POItem) []models.PORevisionLine {
+	lines := make([]models.PORevisionLine, len(items))
+	for i, item := range items {
+		lines[i] = models.PORevisionLine{
+			SKU:         item.SKU,
+			QtyOrdered:  item.QtyOrdered,
+			QtyReceived: item.QtyReceived,
+			UnitCost:    item.UnitCost,
+			DiscountPct: item.DiscountPct,
+			TaxRate:     item.TaxRate,
+			Currency:    item.Currency,
+		}
+	}
+	return lines
+}
+
+// diffPOLines compares the current lines of a PO with incoming ones by SKU.
+// Unchanged lines are left out.
+func diffPOLines(current, incoming []models.POItem) []models.POLineChange {
+	bySKU := make(map[string]models.POItem, len(current))
+	for _, item := range current {
+		bySKU[item.SKU] = item
+	}
+
+	changes := []models.POLineChange{}
+	seen := make(map[string]bool, len(incoming))
+	for _, in := range incoming {
+		seen[in.SKU] = true
+		cur, ok := bySKU[in.SKU]
+		if !ok {
+			changes = append(changes, models.POLineChange{
+				SKU: in.SKU, Type: models.POLineAdded, QtyAfter: in.QtyOrdered, CostAfter: in.UnitCost,
+			})
+			continue
+		}
+		if cur.QtyOrdered != in.QtyOrdered || cur.UnitCost != in.UnitCost ||
+			cur.DiscountPct != in.DiscountPct || cur.TaxRate != in.TaxRate {
+			changes = append(changes, models.POLineChange{
+				SKU: in.SKU, Type: models.POLineChanged,
+				QtyBefore: cur.QtyOrdered, QtyAfter: in.QtyOrdered, QtyReceived: cur.QtyReceived,
+				CostBefore: cur.UnitCost, CostAfter: in.UnitCost,
+			})
+		}
+	}
+	for _, cur := range current {
+		if !seen[cur.SKU] {
+			changes = append(changes, models.POLineChange{
+				SKU: cur.SKU, Type: models.POLineRemoved,
+				QtyBefore: cur.QtyOrdered, QtyReceived: cur.QtyReceived, CostBefore: cur.UnitCost,
+			})
+		}
+	}
+	return changes
+}
+
+// droppedReceivedLines lists the removed lines that have received units
+func droppedReceivedLines(changes []models.POLineChange) []models.POLineChange {
+	dropped := []models.POLineChange{}
+	for _, c := range changes {
+		if c.Type == models.POLineRemoved && c.QtyReceived > 0 {
+			dropped = append(dropped, c)
+		}
+	}
+	return dropped
+}
+
+// baseRevisionNote marks revision 1 of a PO created before revisions were
+// saved at creation
+const baseRevisionNote = "Lines as found at the first change; the PO as created was not recorded"
+
+func saveRevision(tx *gorm.DB, po models.PurchaseOrder, items []models.POItem, changes []models.POLineChange, user, note string, at time.Time) error {
+	lines, _ := json.Marshal(revisionLines(items))
+	diff, _ := json.Marshal(changes)
+	return tx.Create(&models.PORevision{
+		POID:      po.ID,
+		Revision:  po.Revision,
+		FileName:  po.FileName,
+		User:      user,
+		Note:      note,
+		Lines:     models.JSONB(lines),
+		Changes:   models.JSONB(diff),
+		CreatedAt: at,
+	}).Error
+}
+
+// baseRevision saves current as revision 1 of a PO that has no revisions,
+// one created before they were saved at creation. It is stamped with the
+// time it was taken and noted as such, since the original lines are unknown.
+func baseRevision(tx *gorm.DB, po *models.PurchaseOrder, current []models.POItem) error {
+	var count int64
+	if err := tx.Model(&models.PORevision{}).Where("po_id = ?", po.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	if po.Revision == 0 {
+		po.Revision = 1
+	}
+	return saveRevision(tx, *po, current, []models.POLineChange{}, "", baseRevisionNote, time.Now())
+}
+
+// nextRevision bumps the revision of a locked PO and saves after, its lines
+// once changed, as the new revision
+func nextRevision(tx *gorm.DB, po *models.PurchaseOrder, after []models.POItem, changes []models.POLineChange, user string) error {
+	po.Revision++
+	now := time.Now()
+	if err := saveRevision(tx, *po, after, changes, user, "", now); err != nil {
+		return err
+	}
+	return tx.Model(po).Updates(map[string]interface{}{
+		"revision":   po.Revision,
+		"file_name":  po.FileName,
+		"updated_at": now,
+	}).Error
+}
+
+// reviseOrderLines records a line edit of a locked PO as a new revision,
+// diffing its lines against current, as they were before the edit
+func reviseOrderLines(tx *gorm.DB, po *models.PurchaseOrder, current []models.POItem, user string) error {
+	if err := baseRevision(tx, po, current); err != nil {
+		return err
+	}
+	var after []models.POItem
+	if err := tx.Where("po_id = ?", po.ID).Order("id").Find(&after).Error; err != nil {
+		return err
+	}
+	changes := diffPOLines(current, after)
+	if len(changes) == 0 {
+		return tx.Model(po).Update("updated_at", time.Now()).Error
+	}
+	return nextRevision(tx, po, after, changes, user)
+}
+
+// overwriteOrder replaces the lines of a locked PENDING PO with items and
+// records the result as a new revision. Lines are updated in place, so
+// received quantities and line IDs survive. Received lines missing from
+// items need received set to "keep" or "drop"; otherwise
+// errReceivedLinesDropped is returned along with the diff. Other statuses
+// get errPONotEditable, as with manual line edits.
+func overwriteOrder(tx *gorm.DB, po *models.PurchaseOrder, items []models.POItem, fileName, user, received string) ([]models.POLineChange, error) {
+	if po.Status != models.POStatusPending {
+		return nil, errPONotEditable
+	}
+	var current []models.POItem
+	if err := tx.Where("po_id = ?", po.ID).Order("id").Find(&current).Error; err != nil {
+		return nil, err
+	}
+	if err := baseRevision(tx, po, current); err != nil {
+		return nil, err
+	}
+
+	changes := diffPOLines(current, items)
+	if len(droppedReceivedLines(changes)) > 0 && received != receivedLinesKeep && received != receivedLinesDrop {
+		return changes, errReceivedLinesDropped
+	}
+
+	bySKU := make(map[string]models.POItem, len(current))
+	for _, item := range current {
+		bySKU[item.SKU] = item
+	}
+	for _, in := range items {
+		cur, ok := bySKU[in.SKU]
+		if !ok {
+			in.POID = po.ID
+			in.RecalculateStatus()
+			if err := tx.Create(&in).Error; err != nil {
+				return nil, err
+			}
+			continue
+		}
+		cur.QtyOrdered = in.QtyOrdered
+		cur.UnitCost, cur.DiscountPct, cur.TaxRate = in.UnitCost, in.DiscountPct, in.TaxRate
+		if in.Currency != "" {
+			cur.Currency = in.Currency
+		}
+		cur.RecalculateStatus()
+		if err := tx.Model(&cur).Select("qty_ordered", "unit_cost", "discount_pct", "tax_rate", "currency", "status").
+			Updates(&cur).Error; err != nil {
+			return nil, err
+		}
+	}
+	for i, c := range changes {
+		if c.Type != models.POLineRemoved {
+			continue
+		}
+		cur := bySKU[c.SKU]
+		if cur.QtyReceived > 0 && received == receivedLinesKeep {
+			changes[i].Type = models.POLineKept
+			changes[i].QtyAfter = cur.QtyReceived
+			cur.QtyOrdered = cur.QtyReceived
+			cur.RecalculateStatus()
+			if err := tx.Model(&cur).Select("qty_ordered", "status").Updates(&cur).Error; err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := tx.Delete(&cur).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var after []models.POItem
+	if err := tx.Where("po_id = ?", po.ID).Order("id").Find(&after).Error; err != nil {
+		return nil, err
+	}
+	po.FileName = fileName
+	if err := nextRevision(tx, po, after, changes, user); err != nil {
+		return nil, err
+	}
+	po.Items = after
+	return changes, nil
+}
+
+// GetOrderRevisionsHandler lists the revisions of a PO, newest first
+func GetOrderRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	revisions := []models.PORevision{}
+	if err := db.DB.Where("po_id = ?", chi.URLParam(r, "id")).Order("revision desc").Find(&revisions).Error; err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// GetOrderRevisionHandler returns one revision of a PO by number
+func GetOrderRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	var revision models.PORevision
+	if err := db.DB.Where("po_id = ? AND revision = ?", chi.URLParam(r, "id"), chi.URLParam(r, "rev")).
+		First(&revision).Error; err != nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(revision)
+}
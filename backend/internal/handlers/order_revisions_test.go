@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"backroom/internal/models"
+	"testing"
+)
+
+// TestDiffPOLines checks added, changed and removed lines, that unchanged
+// ones are left out and that removed lines with receipts are flagged
+func TestDiffPOLines(t *testing.T) {
+	current := []models.POItem{
+		{SKU: "SAME", QtyOrdered: 5, UnitCost: 2},
+		{SKU: "QTY", QtyOrdered: 5, QtyReceived: 1, UnitCost: 2},
+		{SKU: "TAX", QtyOrdered: 1, UnitCost: 3},
+		{SKU: "GONE", QtyOrdered: 2, UnitCost: 4},
+		{SKU: "GONE-RECEIVED", QtyOrdered: 2, QtyReceived: 2, UnitCost: 4},
+	}
+	incoming := []models.POItem{
+		{SKU: "NEW", QtyOrdered: 7, UnitCost: 1.5},
+		{SKU: "SAME", QtyOrdered: 5, UnitCost: 2},
+		{SKU: "QTY", QtyOrdered: 8, UnitCost: 2.5},
+		{SKU: "TAX", QtyOrdered: 1, UnitCost: 3, TaxRate: 16},
+	}
+	want := []models.POLineChange{
+		{SKU: "NEW", Type: models.POLineAdded, QtyAfter: 7, CostAfter: 1.5},
+		{SKU: "QTY", Type: models.POLineChanged, QtyBefore: 5, QtyAfter: 8, QtyReceived: 1, CostBefore: 2, CostAfter: 2.5},
+		{SKU: "TAX", Type: models.POLineChanged, QtyBefore: 1, QtyAfter: 1, CostBefore: 3, CostAfter: 3},
+		{SKU: "GONE", Type: models.POLineRemoved, QtyBefore: 2, CostBefore: 4},
+		{SKU: "GONE-RECEIVED", Type: models.POLineRemoved, QtyBefore: 2, QtyReceived: 2, CostBefore: 4},
+	}
+
+	changes := diffPOLines(current, incoming)
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %+v", changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d = %+v, want %+v", i, changes[i], want[i])
+		}
+	}
+
+	dropped := droppedReceivedLines(changes)
+	if len(dropped) != 1 || dropped[0].SKU != "GONE-RECEIVED" {
+		t.Errorf("dropped received lines = %+v", dropped)
+	}
+	if same := diffPOLines(current, current); len(same) != 0 {
+		t.Errorf("diff of identical lines = %+v", same)
+	}
+}
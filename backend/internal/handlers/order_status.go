@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"backroom/internal/db"
+	"backroom/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errPOTransition   = errors.New("status transition not allowed")
+	errPOHasShortages = errors.New("purchase order has missing items; close with mode \"short\" to record them")
+	errPOHasReceipts  = errors.New("purchase order has received items; close it instead")
+	errPOOpenSession  = errors.New("purchase order has an open receiving session; finalize or discard it first")
+)
+
+// transitionPO moves a locked PO to a new status, stamps the time it entered
+// that status and records the change in its history
+func transitionPO(tx *gorm.DB, po *models.PurchaseOrder, to models.POStatus, user, note string) error {
+	if !po.Status.CanTransition(to) {
+		return fmt.Errorf("%s -> %s: %w", po.Status, to, errPOTransition)
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"status": to}
+	switch to {
+	case models.POStatusConfirmed:
+		updates["confirmed_at"] = now
+	case models.POStatusInTransit:
+		if po.ShippedAt == nil {
+			updates["shipped_at"] = now
+		}
+	case models.POStatusReceived:
+		updates["received_at"] = now
+	case models.POStatusClosed:
+		updates["closed_at"] = now
+	case models.POStatusCancelled:
+		updates["cancelled_at"] = now
+	}
+	if po.Status == models.POStatusClosed || po.Status == models.POStatusCancelled {
+		updates["reopened_at"] = now
+	}
+
+	from := po.Status
+	if err := tx.Model(po).Updates(updates).Error; err != nil {
+		return err
+	}
+	if err := tx.Create(&models.POStatusChange{POID: po.ID, From: from, To: to, User: user, Note: note}).Error; err != nil {
+		return err
+	}
+	return tx.First(po, po.ID).Error
+}
+
+// reopenTarget is the status a closed, cancelled or received PO goes back to:
+// the furthest point it reached before receiving
+func reopenTarget(po models.PurchaseOrder) models.POStatus {
+	switch {
+	case po.ShippedAt != nil:
+		return models.POStatusInTransit
+	case po.ConfirmedAt != nil:
+		return models.POStatusConfirmed
+	default:
+		return models.POStatusPending
+	}
+}
+
+// TransitionOrderHandler moves a PO through its lifecycle.
+// Body: {"action": "confirm" | "ship" | "close" | "cancel" | "reopen",
+// "mode", "note"}. Closing with missing items is refused unless mode is
+// "short", which records a shortage for every item not fully received.
+// Closing also records an overfill for every item received beyond its order.
+func TransitionOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Action string `json:"action"`
+		Mode   string `json:"mode"` // close: "strict" (default) | "short"
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	action := strings.ToLower(payload.Action)
+	user := requestUser(r)
+
+	var po models.PurchaseOrder
+	var shortages []models.POShortage
+	var overfills []models.POOverfill
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&po, chi.URLParam(r, "id")).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("po_id = ?", po.ID).Order("sku").Find(&po.Items).Error; err != nil {
+			return err
+		}
+
+		var openSessions int64
+		if err := tx.Model(&models.ReceivingSession{}).
+			Where("po_id = ? AND status = ?", po.ID, models.ReceivingOpen).Count(&openSessions).Error; err != nil {
+			return err
+		}
+
+		switch action {
+		case "confirm":
+			return transitionPO(tx, &po, models.POStatusConfirmed, user, payload.Note)
+
+		case "ship":
+			return transitionPO(tx, &po, models.POStatusInTransit, user, payload.Note)
+
+		case "close":
+			if openSessions > 0 {
+				return errPOOpenSession
+			}
+			for _, item := range po.Items {
+				if item.QtyReceived > item.QtyOrdered {
+					overfills = append(overfills, models.POOverfill{
+						POID:        po.ID,
+						POItemID:    item.ID,
+						SKU:         item.SKU,
+						QtyOrdered:  item.QtyOrdered,
+						QtyReceived: item.QtyReceived,
+						QtyOver:     item.QtyReceived - item.QtyOrdered,
+						User:        user,
+					})
+				}
+				if item.QtyReceived >= item.QtyOrdered {
+					continue
+				}
+				shortages = append(shortages, models.POShortage{
+					POID:        po.ID,
+					POItemID:    item.ID,
+					SKU:         item.SKU,
+					QtyOrdered:  item.QtyOrdered,
+					QtyReceived: item.QtyReceived,
+					QtyShort:    item.QtyOrdered - item.QtyReceived,
+					User:        user,
+				})
+			}
+			if len(shortages) > 0 {
+				if strings.ToLower(payload.Mode) != "short" {
+					return errPOHasShortages
+				}
+				if err := tx.Create(&shortages).Error; err != nil {
+					return err
+				}
+			}
+			if len(overfills) > 0 {
+				if err := tx.Create(&overfills).Error; err != nil {
+					return err
+				}
+			}
+			return transitionPO(tx, &po, models.POStatusClosed, user, payload.Note)
+
+		case "cancel":
+			if openSessions > 0 {
+				return errPOOpenSession
+			}
+			for _, item := range po.Items {
+				if item.QtyReceived > 0 {
+					return errPOHasReceipts
+				}
+			}
+			return transitionPO(tx, &po, models.POStatusCancelled, user, payload.Note)
+
+		case "reopen":
+			if po.Status.Receivable() {
+				return fmt.Errorf("purchase order is %s: %w", po.Status, errPOTransition)
+			}
+			if err := tx.Model(&models.POShortage{}).Where("po_id = ? AND voided = ?", po.ID, false).
+				Update("voided", true).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.POOverfill{}).Where("po_id = ? AND voided = ?", po.ID, false).
+				Update("voided", true).Error; err != nil {
+				return err
+			}
+			return transitionPO(tx, &po, reopenTarget(po), user, payload.Note)
+
+		default:
+			return fmt.Errorf("unknown action %q: %w", payload.Action, errPOTransition)
+		}
+	})
+
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errPOHasShortages):
+		// Discrepancy alert: the caller must confirm closing short
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":     err.Error(),
+			"shortages": shortages,
+		})
+		return
+	case errors.Is(err, errPOTransition), errors.Is(err, errPOHasReceipts), errors.Is(err, errPOOpenSession):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update order: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	db.DB.Where("po_id = ?", po.ID).Order("sku").Find(&po.Items)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"order":     po,
+		"shortages": shortages,
+		"overfills": overfills,
+	})
+}
+
+// GetOrderHistoryHandler returns the status changes, shortages and overfills of a PO
+func GetOrderHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var po models.PurchaseOrder
+	if err := db.DB.First(&po, id).Error; err != nil {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	var changes []models.POStatusChange
+	db.DB.Where("po_id = ?", po.ID).Order("created_at asc").Find(&changes)
+	var shortages []models.POShortage
+	db.DB.Where("po_id = ?", po.ID).Order("created_at asc").Find(&shortages)
+	var overfills []models.POOverfill
+	db.DB.Where("po_id = ?", po.ID).Order("created_at asc").Find(&overfills)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"order":     po,
+		"changes":   changes,
+		"shortages": shortages,
+		"overfills": overfills,
+	})
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"backroom/internal/models"
+	"testing"
+	"time"
+)
+
+// TestReopenTarget checks that a reopened PO goes back to the furthest
+// status it reached before receiving
+func TestReopenTarget(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		po   models.PurchaseOrder
+		want models.POStatus
+	}{
+		{"never confirmed", models.PurchaseOrder{Status: models.POStatusReceived}, models.POStatusPending},
+		{"confirmed", models.PurchaseOrder{Status: models.POStatusClosed, ConfirmedAt: &now}, models.POStatusConfirmed},
+		{"shipped", models.PurchaseOrder{Status: models.POStatusCancelled, ConfirmedAt: &now, ShippedAt: &now}, models.POStatusInTransit},
+		{"shipped unconfirmed", models.PurchaseOrder{Status: models.POStatusReceived, ShippedAt: &now}, models.POStatusInTransit},
+	}
+	for _, tt := range tests {
+		got := reopenTarget(tt.po)
+		if got != tt.want {
+			t.Errorf("%s: %s, want %s", tt.name, got, tt.want)
+		}
+		if !tt.po.Status.CanTransition(got) {
+			t.Errorf("%s: %s -> %s is not an allowed transition", tt.name, tt.po.Status, got)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"backroom/internal/channels"
 	"backroom/internal/db"
+	"backroom/internal/inventory"
 	"backroom/internal/models"
 	"encoding/json"
 	"image"
@@ -9,6 +11,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -41,6 +44,7 @@ func DeleteProductHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Delete from DB
 	db.DB.Delete(&product)
+	db.DB.Where("product_id = ?", product.ID).Delete(&models.StockBalance{})
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
@@ -81,7 +85,7 @@ func UpdateProductHandler(w http.ResponseWriter, r *http.Request) {
 		product.Status = payload.Status
 	}
 
-	db.DB.Save(&product)
+	db.DB.Omit(inventory.StockColumns...).Save(&product)
 	json.NewEncoder(w).Encode(product)
 }
 
@@ -219,7 +223,7 @@ func RecropHandler(w http.ResponseWriter, r *http.Request) {
 		newRect := []int{x, y, x + width, y + height}
 		rectBytes, _ := json.Marshal(newRect)
 		product.ImageRect = string(rectBytes)
-		db.DB.Save(&product)
+		db.DB.Omit(inventory.StockColumns...).Save(&product)
 	}
 
 	// Return new image URL (add query param to bust cache)
@@ -235,14 +239,78 @@ func RecropHandler(w http.ResponseWriter, r *http.Request) {
 
 // GetProductsHandler returns all products
 func GetProductsHandler(w http.ResponseWriter, r *http.Request) {
+	type ProductItem struct {
+		models.Product
+		Available int `json:"available"` // On hand - reserved
+	}
+
 	var products []models.Product
 	db.DB.Order("created_at desc").Find(&products)
-	json.NewEncoder(w).Encode(products)
+
+	results := make([]ProductItem, len(products))
+	for i, p := range products {
+		results[i] = ProductItem{Product: p, Available: p.Available()}
+	}
+	json.NewEncoder(w).Encode(results)
 }
 
-// SyncProductHandler stub
+// SyncProductHandler pushes products to every enabled sales channel (Sync Up).
+// Optional body: {"product_ids": [...], "channel_id": 1, "dry_run": true}; ?dry_run=true also works.
 func SyncProductHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotImplemented)
+	var payload struct {
+		ProductIDs []uuid.UUID `json:"product_ids"`
+		ChannelID  uint        `json:"channel_id"`
+		DryRun     bool        `json:"dry_run"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
+			return
+		}
+	}
+	dryRun := payload.DryRun || r.URL.Query().Get("dry_run") == "true"
+
+	channelFilter := r.URL.Query().Get("channel_id")
+	if payload.ChannelID > 0 {
+		channelFilter = strconv.FormatUint(uint64(payload.ChannelID), 10)
+	}
+	configured, err := loadSyncChannels(channelFilter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	query := db.DB.Order("created_at asc")
+	if len(payload.ProductIDs) > 0 {
+		query = query.Where("id IN ?", payload.ProductIDs)
+	}
+	var products []models.Product
+	if err := query.Find(&products).Error; err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	results := []SyncUpResult{}
+	for _, m := range configured {
+		result := SyncUpResult{ChannelID: m.ID, ChannelName: m.Name, DryRun: dryRun, Results: []SyncUpItem{}}
+		var ch channels.Channel
+		if !dryRun {
+			ch, err = channels.FromModel(m)
+		}
+		if err == nil {
+			result, err = RunSyncUp(m, ch, products, dryRun, nil)
+		}
+		if err != nil {
+			result.Error = err.Error()
+			err = nil
+		}
+		results = append(results, result)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dry_run":  dryRun,
+		"channels": results,
+	})
 }
 
 // CreateProductHandler saves a product from the preview (Draft)
@@ -284,7 +352,7 @@ func CreateProductHandler(w http.ResponseWriter, r *http.Request) {
 
 		existing.UpdatedAt = time.Now()
 
-		if err := db.DB.Save(&existing).Error; err != nil {
+		if err := db.DB.Omit(inventory.StockColumns...).Save(&existing).Error; err != nil {
 			http.Error(w, "Failed to update product: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -301,8 +369,29 @@ func CreateProductHandler(w http.ResponseWriter, r *http.Request) {
 			product.Status = models.StatusDraft
 		}
 
-		if result := db.DB.Create(&product); result.Error != nil {
-			http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		// Initial stock goes through the ledger like any other change
+		initialStock := product.StockOnHand
+		product.StockOnHand = 0
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&product).Error; err != nil {
+				return err
+			}
+			if initialStock == 0 {
+				return nil
+			}
+			movement, err := inventory.Apply(tx, inventory.Change{
+				ProductID: product.ID,
+				Delta:     initialStock,
+				Reason:    models.MovementAdjustment,
+				Ref:       inventory.Ref{Type: models.RefUser},
+				User:      requestUser(r),
+				Note:      "Initial stock",
+			})
+			product.StockOnHand = movement.BalanceAfter
+			return err
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		json.NewEncoder(w).Encode(product)
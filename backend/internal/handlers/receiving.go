@@ -0,0 +1,530 @@
+package handlers
+
+import (
+	"backroom/internal/db"
+	"backroom/internal/inventory"
+	"backroom/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errReceivingClosed = errors.New("receiving session is not open")
+	errPONotReceivable = errors.New("purchase order is not open for receiving")
+	errBelowZero       = errors.New("quantity would go below zero")
+)
+
+// receivingErrorStatus maps receiving errors to an HTTP status code
+func receivingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errReceivingClosed), errors.Is(err, errPONotReceivable), errors.Is(err, errPOTransition),
+		errors.Is(err, errScanReversed), errors.Is(err, errBelowZero):
+		return http.StatusConflict
+	case errors.Is(err, errInvalidLocation):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// receivingError writes the response for a receiving error
+func receivingError(w http.ResponseWriter, err error) {
+	http.Error(w, "Receiving failed: "+err.Error(), receivingErrorStatus(err))
+}
+
+// Lock order for receiving is always purchase order, then session, so
+// scans, finalize and unscan cannot deadlock each other.
+
+// openReceivingSession returns the OPEN session of a PO, creating one if
+// there is none. The PO row is locked so concurrent scans share one session,
+// and the session row so a scan cannot slip in while it is being finalized.
+func openReceivingSession(tx *gorm.DB, poID uint, locationID *uint, user string) (models.ReceivingSession, error) {
+	var session models.ReceivingSession
+	var po models.PurchaseOrder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&po, poID).Error; err != nil {
+		return session, fmt.Errorf("purchase order %d: %w", poID, err)
+	}
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("po_id = ? AND status = ?", poID, models.ReceivingOpen).First(&session).Error
+	if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) {
+		return session, err
+	}
+	if !po.Status.Receivable() {
+		return session, fmt.Errorf("purchase order %d is %s: %w", po.ID, po.Status, errPONotReceivable)
+	}
+	if locationID != nil {
+		if _, err := inventory.ResolveLocation(tx, locationID); err != nil {
+			return session, err
+		}
+	}
+
+	session = models.ReceivingSession{
+		POID:       poID,
+		LocationID: locationID,
+		Status:     models.ReceivingOpen,
+		CreatedBy:  user,
+	}
+	return session, tx.Create(&session).Error
+}
+
+// stageReceipt adds qty (negative to correct) to the product's staged line
+// with an atomic upsert, so concurrent scans of one SKU are all counted. A
+// negative qty larger than what is staged fails with errBelowZero instead of
+// being clamped, so every scan event matches the line exactly.
+func stageReceipt(tx *gorm.DB, session models.ReceivingSession, product models.Product, qty int, bin string) (models.ReceivingLine, error) {
+	var line models.ReceivingLine
+	if qty < 0 {
+		res := tx.Raw(`UPDATE receiving_lines SET qty = qty + ?, updated_at = ?
+			WHERE session_id = ? AND product_id = ? AND qty + ? >= 0 RETURNING *`,
+			qty, time.Now(), session.ID, product.ID, qty).Scan(&line)
+		if res.Error == nil && res.RowsAffected == 0 {
+			return line, fmt.Errorf("%s: removing %d: %w", product.SKU, -qty, errBelowZero)
+		}
+		return line, res.Error
+	}
+
+	var count int64
+	if err := tx.Model(&models.POItem{}).Where("po_id = ? AND sku = ?", session.POID, product.SKU).Count(&count).Error; err != nil {
+		return models.ReceivingLine{}, err
+	}
+
+	err := tx.Raw(`INSERT INTO receiving_lines (session_id, product_id, sku, bin, qty, on_po, updated_at)
+		VALUES (@session, @product, @sku, @bin, @qty, @on_po, @now)
+		ON CONFLICT (session_id, product_id) DO UPDATE SET
+			qty = receiving_lines.qty + EXCLUDED.qty,
+			bin = CASE WHEN EXCLUDED.bin <> '' THEN EXCLUDED.bin ELSE receiving_lines.bin END,
+			updated_at = EXCLUDED.updated_at
+		RETURNING *`, map[string]interface{}{
+		"session": session.ID,
+		"product": product.ID,
+		"sku":     product.SKU,
+		"bin":     bin,
+		"qty":     qty,
+		"on_po":   count > 0,
+		"now":     time.Now(),
+	}).Scan(&line).Error
+	return line, err
+}
+
+// addPOItemReceived adds delta to an item's QtyReceived under a row lock and
+// recalculates its status. Taking away more than was received fails with
+// errBelowZero rather than flooring, so QtyReceived and stock move together.
+func addPOItemReceived(tx *gorm.DB, item *models.POItem, delta int) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(item, item.ID).Error; err != nil {
+		return err
+	}
+	if item.QtyReceived+delta < 0 {
+		return fmt.Errorf("%s: %d received, removing %d: %w", item.SKU, item.QtyReceived, -delta, errBelowZero)
+	}
+	item.QtyReceived += delta
+	item.RecalculateStatus()
+	return tx.Model(item).Updates(map[string]interface{}{
+		"qty_received": item.QtyReceived,
+		"status":       item.Status,
+	}).Error
+}
+
+// lockReceivingWithPO locks a session's PO and then the session itself,
+// following the receiving lock order
+func lockReceivingWithPO(tx *gorm.DB, id string) (models.ReceivingSession, models.PurchaseOrder, error) {
+	var po models.PurchaseOrder
+	var session models.ReceivingSession
+	if err := tx.First(&session, id).Error; err != nil {
+		return session, po, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&po, session.POID).Error; err != nil {
+		return session, po, err
+	}
+	session, err := lockReceivingSession(tx, id)
+	return session, po, err
+}
+
+// lockReceivingSession loads an OPEN session with its lines, locked for the rest of tx
+func lockReceivingSession(tx *gorm.DB, id string) (models.ReceivingSession, error) {
+	var session models.ReceivingSession
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&session, id).Error; err != nil {
+		return session, err
+	}
+	if session.Status != models.ReceivingOpen {
+		return session, errReceivingClosed
+	}
+	err := tx.Where("session_id = ?", session.ID).Order("sku").Find(&session.Lines).Error
+	return session, err
+}
+
+// ReceivingItem compares one PO line with what is committed and staged
+type ReceivingItem struct {
+	SKU         string `json:"sku"`
+	QtyOrdered  int    `json:"qty_ordered"`
+	QtyReceived int    `json:"qty_received"` // Committed by earlier receipts
+	QtyStaged   int    `json:"qty_staged"`   // In this session
+	QtyMissing  int    `json:"qty_missing"`  // After this session is finalized
+}
+
+// receivingSummary builds the per-item view of a session against its PO
+func receivingSummary(tx *gorm.DB, session models.ReceivingSession) ([]ReceivingItem, error) {
+	var items []models.POItem
+	if err := tx.Where("po_id = ?", session.POID).Order("sku").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	staged := make(map[string]int)
+	for _, l := range session.Lines {
+		staged[l.SKU] += l.Qty
+	}
+
+	summary := make([]ReceivingItem, 0, len(items))
+	for _, item := range items {
+		ri := ReceivingItem{
+			SKU:         item.SKU,
+			QtyOrdered:  item.QtyOrdered,
+			QtyReceived: item.QtyReceived,
+			QtyStaged:   staged[item.SKU],
+		}
+		ri.QtyMissing = ri.QtyOrdered - ri.QtyReceived - ri.QtyStaged
+		if ri.QtyMissing < 0 {
+			ri.QtyMissing = 0
+		}
+		summary = append(summary, ri)
+	}
+	return summary, nil
+}
+
+// StartReceivingHandler opens a receiving session for a PO, or resumes the
+// open one. Body (optional): {"location_id"}.
+func StartReceivingHandler(w http.ResponseWriter, r *http.Request) {
+	poID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	var payload struct {
+		LocationID *uint `json:"location_id"`
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var session models.ReceivingSession
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		session, err = openReceivingSession(tx, uint(poID), payload.LocationID, requestUser(r))
+		return err
+	})
+	if err != nil {
+		receivingError(w, err)
+		return
+	}
+	db.DB.Where("session_id = ?", session.ID).Order("sku").Find(&session.Lines)
+	json.NewEncoder(w).Encode(session)
+}
+
+// GetReceivingSessionsHandler lists sessions; ?status= and ?po_id= filter
+func GetReceivingSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	var list []models.ReceivingSession
+	query := db.DB.Preload("Lines").Order("updated_at desc")
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", strings.ToUpper(status))
+	}
+	if poID := r.URL.Query().Get("po_id"); poID != "" {
+		query = query.Where("po_id = ?", poID)
+	}
+	if err := query.Find(&list).Error; err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetReceivingSessionHandler returns a session with its staged lines and the
+// PO items it is being received against
+func GetReceivingSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var session models.ReceivingSession
+	if err := db.DB.Preload("Lines").First(&session, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	summary, err := receivingSummary(db.DB, session)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session": session,
+		"items":   summary,
+	})
+}
+
+// ReceivingScanHandler stages a scan. Body: {"code", "qty", "bin"}; qty
+// defaults to 1, may be negative to take units back out and cannot be 0.
+// The scan is stored as a ScanEvent so it can be reversed with unscan.
+func ReceivingScanHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Code string `json:"code"`
+		Qty  *int   `json:"qty"`
+		Bin  string `json:"bin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+	qty := 1
+	if payload.Qty != nil {
+		qty = *payload.Qty
+	}
+	if qty == 0 {
+		http.Error(w, "Invalid qty", http.StatusBadRequest)
+		return
+	}
+
+	product, err := findProductByCode(db.DB, payload.Code)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "not_found",
+			"message": "Product not found in inventory: " + payload.Code,
+		})
+		return
+	}
+
+	var line models.ReceivingLine
+	var event models.ScanEvent
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		session, _, err := lockReceivingWithPO(tx, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		line, err = stageReceipt(tx, session, product, qty, payload.Bin)
+		if err != nil {
+			return err
+		}
+		locationID, err := inventory.ResolveLocation(tx, session.LocationID)
+		if err != nil {
+			return err
+		}
+		event = models.ScanEvent{
+			Code:       payload.Code,
+			ProductID:  product.ID,
+			SKU:        product.SKU,
+			Qty:        qty,
+			Kind:       models.ScanStaged,
+			POID:       &session.POID,
+			SessionID:  &session.ID,
+			LocationID: locationID,
+			Bin:        line.Bin,
+			User:       requestUser(r),
+		}
+		return tx.Create(&event).Error
+	})
+	if err != nil {
+		receivingError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":      "staged",
+		"product":     product,
+		"staged_line": line,
+		"scan_event":  event,
+	}
+	if !line.OnPO {
+		response["warning"] = "Item not found in this PO"
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// UpdateReceivingLineHandler corrects a staged line. Body: {"qty", "bin"};
+// a qty of 0 removes the line. A quantity change is stored as a CORRECTION
+// scan event for the difference, so it can be reversed with unscan and
+// later unscans still match the line.
+func UpdateReceivingLineHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Qty *int    `json:"qty"`
+		Bin *string `json:"bin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.Qty != nil && *payload.Qty < 0 {
+		http.Error(w, "Invalid qty", http.StatusBadRequest)
+		return
+	}
+
+	var line models.ReceivingLine
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		session, _, err := lockReceivingWithPO(tx, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("session_id = ?", session.ID).First(&line, chi.URLParam(r, "lineId")).Error; err != nil {
+			return err
+		}
+		if payload.Qty != nil && *payload.Qty != line.Qty {
+			locationID, err := inventory.ResolveLocation(tx, session.LocationID)
+			if err != nil {
+				return err
+			}
+			event := models.ScanEvent{
+				Code:       line.SKU,
+				ProductID:  line.ProductID,
+				SKU:        line.SKU,
+				Qty:        *payload.Qty - line.Qty,
+				Kind:       models.ScanCorrection,
+				POID:       &session.POID,
+				SessionID:  &session.ID,
+				LocationID: locationID,
+				Bin:        line.Bin,
+				User:       requestUser(r),
+			}
+			if err := tx.Create(&event).Error; err != nil {
+				return err
+			}
+		}
+		if payload.Qty != nil && *payload.Qty == 0 {
+			return tx.Delete(&line).Error
+		}
+		if payload.Qty != nil {
+			line.Qty = *payload.Qty
+		}
+		if payload.Bin != nil {
+			line.Bin = *payload.Bin
+		}
+		return tx.Save(&line).Error
+	})
+	if err != nil {
+		receivingError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(line)
+}
+
+// FinalizeReceivingHandler commits a session in one transaction: adds the
+// staged quantities to POItem.QtyReceived, receives them into stock, and
+// marks the PO RECEIVED once every item is complete. Items not on the PO
+// are still received into stock.
+func FinalizeReceivingHandler(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	var po models.PurchaseOrder
+	var session models.ReceivingSession
+	var unexpected []string
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		session, po, err = lockReceivingWithPO(tx, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		if !po.Status.Receivable() {
+			return fmt.Errorf("purchase order %d is %s: %w", po.ID, po.Status, errPONotReceivable)
+		}
+		if err := tx.Where("po_id = ?", po.ID).Find(&po.Items).Error; err != nil {
+			return err
+		}
+
+		ref := inventory.Ref{Type: models.RefPurchaseOrder, ID: strconv.FormatUint(uint64(po.ID), 10)}
+		for _, line := range session.Lines {
+			if line.Qty == 0 {
+				continue
+			}
+			note := "Receipt " + strconv.FormatUint(uint64(session.ID), 10)
+			matched := false
+			for i := range po.Items {
+				if po.Items[i].SKU != line.SKU {
+					continue
+				}
+				if err := addPOItemReceived(tx, &po.Items[i], line.Qty); err != nil {
+					return err
+				}
+				matched = true
+				break
+			}
+			if !matched {
+				unexpected = append(unexpected, line.SKU)
+				note += " (not on PO)"
+			}
+
+			if _, err := inventory.Apply(tx, inventory.Change{
+				ProductID:  line.ProductID,
+				Delta:      line.Qty,
+				LocationID: session.LocationID,
+				Bin:        line.Bin,
+				Reason:     models.MovementReceipt,
+				Ref:        ref,
+				User:       user,
+				Note:       note,
+			}); err != nil {
+				return err
+			}
+		}
+
+		complete := len(po.Items) > 0
+		for _, item := range po.Items {
+			if item.Status != models.POItemStatusCompleted && item.Status != models.POItemStatusOverfilled {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			note := "Receipt " + strconv.FormatUint(uint64(session.ID), 10) + " finalized"
+			if err := transitionPO(tx, &po, models.POStatusReceived, user, note); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		session.Status = models.ReceivingFinalized
+		session.FinalizedBy = user
+		session.ClosedAt = &now
+		return tx.Model(&session).Updates(map[string]interface{}{
+			"status":       session.Status,
+			"finalized_by": user,
+			"closed_at":    now,
+		}).Error
+	})
+	if err != nil {
+		receivingError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     session.Status,
+		"session":    session,
+		"po":         po,
+		"unexpected": unexpected,
+	})
+}
+
+// DiscardReceivingHandler abandons an OPEN session; nothing was committed
+func DiscardReceivingHandler(w http.ResponseWriter, r *http.Request) {
+	res := db.DB.Model(&models.ReceivingSession{}).
+		Where("id = ? AND status = ?", chi.URLParam(r, "id"), models.ReceivingOpen).
+		Updates(map[string]interface{}{"status": models.ReceivingDiscarded, "closed_at": time.Now()})
+	if res.Error != nil {
+		http.Error(w, "DB Error: "+res.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "Session not found or not open", http.StatusConflict)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": string(models.ReceivingDiscarded)})
+}
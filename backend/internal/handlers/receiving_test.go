@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// TestReceivingErrorStatus checks the status of each receiving error, also
+// when it is wrapped
+func TestReceivingErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{gorm.ErrRecordNotFound, http.StatusNotFound},
+		{errReceivingClosed, http.StatusConflict},
+		{errPONotReceivable, http.StatusConflict},
+		{errPOTransition, http.StatusConflict},
+		{errScanReversed, http.StatusConflict},
+		{fmt.Errorf("A-1: 2 in stock, removing 3: %w", errBelowZero), http.StatusConflict},
+		{fmt.Errorf("location 9: %w", errInvalidLocation), http.StatusBadRequest},
+		{errors.New("connection reset"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := receivingErrorStatus(tt.err); got != tt.want {
+			t.Errorf("receivingErrorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -2,40 +2,75 @@ package handlers
 
 import (
 	"backroom/internal/db"
+	"backroom/internal/inventory"
 	"backroom/internal/models"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// ScanItemHandler processes a scanned barcode/SKU
-func ScanItemHandler(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		Code        string `json:"code"`
-		POID        *uint  `json:"po_id,omitempty"` // Context: Receiving against this PO
-		SkipPOCheck bool   `json:"skip_po_check"`
-	}
+var (
+	errScanReversed    = errors.New("scan event was already reversed")
+	errInvalidLocation = errors.New("invalid location")
+)
 
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "Invalid payload", http.StatusBadRequest)
-		return
+// findProductByCode resolves a scanned or keyed code against SKU or Barcode
+func findProductByCode(tx *gorm.DB, code string) (models.Product, error) {
+	var product models.Product
+	err := tx.Where("sku = ? OR barcode = ?", code, code).First(&product).Error
+	return product, err
+}
+
+// ScanRequest is one receiving scan
+type ScanRequest struct {
+	ClientScanID string     `json:"client_scan_id,omitempty"` // Device-generated; repeats are ignored
+	ScannedAt    *time.Time `json:"scanned_at,omitempty"`
+	Code         string     `json:"code"`
+	Qty          int        `json:"qty,omitempty"`   // Units scanned at once; 1 if omitted
+	POID         *uint      `json:"po_id,omitempty"` // Context: Receiving against this PO
+	SkipPOCheck  bool       `json:"skip_po_check"`
+	LocationID   *uint      `json:"location_id,omitempty"` // Receive into; default location if omitted
+	Bin          string     `json:"bin,omitempty"`
+}
+
+// processScan runs one receiving scan and returns the HTTP status and the
+// response body. With a PO (given or resolved from the SKU) the units are
+// staged in the PO's receiving session; otherwise they are received into
+// stock straight away. Either way the scan is stored as a ScanEvent.
+// A ClientScanID that was already processed returns the original event with
+// status "duplicate" and changes nothing.
+func processScan(req ScanRequest, user string) (int, map[string]interface{}, error) {
+	if req.Code == "" {
+		return http.StatusBadRequest, nil, errors.New("code is required")
+	}
+	if response, ok := duplicateScan(req.ClientScanID); ok {
+		return http.StatusOK, response, nil
+	}
+	if req.Qty == 0 {
+		req.Qty = 1
+	}
+	if req.Qty < 0 {
+		return http.StatusBadRequest, nil, errors.New("qty must be positive; use unscan to reverse a scan")
 	}
 
-	if payload.Code == "" {
-		http.Error(w, "Code is required", http.StatusBadRequest)
-		return
+	locationID, err := inventory.ResolveLocation(db.DB, req.LocationID)
+	if err != nil {
+		return http.StatusBadRequest, nil, fmt.Errorf("%w: %v", errInvalidLocation, err)
 	}
 
-	var product models.Product
-	// Try to find by SKU OR Barcode
-	if err := db.DB.Where("sku = ? OR barcode = ?", payload.Code, payload.Code).First(&product).Error; err != nil {
+	product, err := findProductByCode(db.DB, req.Code)
+	if err != nil {
 		// Product not found in DB - Do NOT create. Return error explicitly.
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]interface{}{
+		return http.StatusNotFound, map[string]interface{}{
 			"status":  "not_found",
-			"message": "Product not found in inventory: " + payload.Code,
-		})
-		return
+			"message": "Product not found in inventory: " + req.Code,
+		}, nil
 	}
 
 	response := map[string]interface{}{
@@ -44,7 +79,7 @@ func ScanItemHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Try to resolve PO Context if not provided
-	if (payload.POID == nil || *payload.POID == 0) && !payload.SkipPOCheck {
+	if (req.POID == nil || *req.POID == 0) && !req.SkipPOCheck {
 		var pendingItems []models.POItem
 		db.DB.Where("sku = ? AND status IN (?, ?)", product.SKU, models.POItemStatusPending, models.POItemStatusPartial).Find(&pendingItems)
 
@@ -58,7 +93,7 @@ func ScanItemHandler(w http.ResponseWriter, r *http.Request) {
 		for _, item := range pendingItems {
 			var po models.PurchaseOrder
 			if err := db.DB.First(&po, item.POID).Error; err == nil {
-				if po.Status != models.POStatusReceived {
+				if po.Status.Receivable() {
 					options = append(options, poOption{
 						POID:         po.ID,
 						SupplierName: po.SupplierName,
@@ -71,43 +106,392 @@ func ScanItemHandler(w http.ResponseWriter, r *http.Request) {
 		if len(options) > 1 {
 			response["status"] = "multiple_pos"
 			response["po_options"] = options
-			json.NewEncoder(w).Encode(response)
-			return
+			return http.StatusOK, response, nil
 		} else if len(options) == 1 {
 			poid := options[0].POID
-			payload.POID = &poid
+			req.POID = &poid
 		}
 	}
 
-	// Logic: Receiving Mode - Update PO if applicable
-	if payload.POID != nil && *payload.POID > 0 {
-		var poItem models.POItem
-		// Find Item in PO
-		if err := db.DB.Where("po_id = ? AND sku = ?", *payload.POID, product.SKU).First(&poItem).Error; err != nil {
-			response["warning"] = "Item not found in this PO"
-		} else {
-			// Update PO Item
-			poItem.QtyReceived++
-
-			// Recalculate Status
-			if poItem.QtyReceived < poItem.QtyOrdered {
-				poItem.Status = models.POItemStatusPartial
-			} else if poItem.QtyReceived == poItem.QtyOrdered {
-				poItem.Status = models.POItemStatusCompleted
-			} else {
-				poItem.Status = models.POItemStatusOverfilled
-			}
+	event := models.ScanEvent{
+		ScannedAt:  req.ScannedAt,
+		Code:       req.Code,
+		ProductID:  product.ID,
+		SKU:        product.SKU,
+		Qty:        req.Qty,
+		LocationID: locationID,
+		Bin:        req.Bin,
+		User:       user,
+	}
+	if req.ClientScanID != "" {
+		event.ClientScanID = &req.ClientScanID
+	}
 
-			db.DB.Save(&poItem)
-			response["po_item"] = poItem
+	// Logic: Receiving Mode - stage the scan in the PO's receiving session.
+	// POItem.QtyReceived and stock only change on Finalize Receipt.
+	if req.POID != nil && *req.POID > 0 {
+		var session models.ReceivingSession
+		var line models.ReceivingLine
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			session, err = openReceivingSession(tx, *req.POID, req.LocationID, user)
+			if err != nil {
+				return err
+			}
+			line, err = stageReceipt(tx, session, product, req.Qty, req.Bin)
+			if err != nil {
+				return err
+			}
+			event.Kind = models.ScanStaged
+			event.POID = req.POID
+			event.SessionID = &session.ID
+			return tx.Create(&event).Error
+		})
+		if err != nil {
+			// A concurrent retry of the same scan won the unique index
+			if response, ok := duplicateScan(req.ClientScanID); ok {
+				return http.StatusOK, response, nil
+			}
+			return receivingErrorStatus(err), nil, err
+		}
+		if !line.OnPO {
+			response["warning"] = "Item not found in this PO"
 		}
+		response["session_id"] = session.ID
+		response["staged_line"] = line
+		response["scan_event"] = event
+		response["status"] = "staged"
+		return http.StatusOK, response, nil
 	}
 
-	// Update Global Stock
-	product.StockOnHand++
-	db.DB.Save(&product)
+	// No PO context: an ad-hoc receipt goes straight into stock
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := inventory.Apply(tx, inventory.Change{
+			ProductID:  product.ID,
+			Delta:      req.Qty,
+			LocationID: &locationID,
+			Bin:        req.Bin,
+			Reason:     models.MovementReceipt,
+			Ref:        inventory.Ref{Type: models.RefUser},
+			User:       user,
+			Note:       "Scan " + req.Code,
+		}); err != nil {
+			return err
+		}
+		event.Kind = models.ScanReceived
+		return tx.Create(&event).Error
+	})
+	if err != nil {
+		if response, ok := duplicateScan(req.ClientScanID); ok {
+			return http.StatusOK, response, nil
+		}
+		return http.StatusInternalServerError, nil, fmt.Errorf("failed to update stock: %w", err)
+	}
+	db.DB.First(&product, "id = ?", product.ID)
 	response["product"] = product
+	response["location_id"] = locationID
+	response["bin"] = req.Bin
+	response["scan_event"] = event
 	response["status"] = "received"
+	return http.StatusOK, response, nil
+}
+
+// duplicateScan returns the stored outcome of an already processed client scan ID
+func duplicateScan(clientScanID string) (map[string]interface{}, bool) {
+	if clientScanID == "" {
+		return nil, false
+	}
+	var event models.ScanEvent
+	if err := db.DB.Where("client_scan_id = ?", clientScanID).First(&event).Error; err != nil {
+		return nil, false
+	}
+	var product models.Product
+	db.DB.First(&product, "id = ?", event.ProductID)
+	return map[string]interface{}{
+		"status":     "duplicate",
+		"product":    product,
+		"session_id": event.SessionID,
+		"scan_event": event,
+	}, true
+}
+
+// ScanItemHandler processes a scanned barcode/SKU. The scan ID can be sent
+// as client_scan_id or in the Idempotency-Key header.
+func ScanItemHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ScanRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.ClientScanID == "" {
+		payload.ClientScanID = r.Header.Get("Idempotency-Key")
+	}
+
+	status, response, err := processScan(payload, requestUser(r))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if status != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetScanEventsHandler lists scan events, newest first.
+// Filters: ?po_id=, ?session_id=, ?sku=, ?limit= (default 100).
+func GetScanEventsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := db.DB.Order("created_at desc")
+	if v := q.Get("po_id"); v != "" {
+		query = query.Where("po_id = ?", v)
+	}
+	if v := q.Get("session_id"); v != "" {
+		query = query.Where("session_id = ?", v)
+	}
+	if v := q.Get("sku"); v != "" {
+		query = query.Where("sku = ?", v)
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+
+	var events []models.ScanEvent
+	if err := query.Limit(limit).Find(&events).Error; err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(events)
+}
+
+// BatchScanResult is the outcome of one scan of a batch
+type BatchScanResult struct {
+	Index        int                    `json:"index"`
+	ClientScanID string                 `json:"client_scan_id,omitempty"`
+	HTTPStatus   int                    `json:"http_status"`
+	Status       string                 `json:"status"` // staged | received | duplicate | multiple_pos | not_found | error
+	Flagged      bool                   `json:"flagged"`
+	FlagReason   string                 `json:"flag_reason,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+	Result       map[string]interface{} `json:"result,omitempty"`
+}
+
+// BatchScanHandler ingests scans queued on a device while offline, in order.
+// Body: {"scans": [ScanRequest...]}. Each scan gets its own result; scans
+// that resolve differently than when they were taken are flagged:
+//   - its PO was received or closed meanwhile: received into stock without PO
+//   - it now matches several open POs and needs one picked: not applied
+//   - its product is no longer found, or is not on the PO it was scanned for
+func BatchScanHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Scans []ScanRequest `json:"scans"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	user := requestUser(r)
+
+	results := make([]BatchScanResult, 0, len(payload.Scans))
+	counts := map[string]int{}
+	for i, req := range payload.Scans {
+		res := BatchScanResult{Index: i, ClientScanID: req.ClientScanID}
+
+		status, body, err := processScan(req, user)
+		if errors.Is(err, errPONotReceivable) && req.POID != nil {
+			res.Flagged = true
+			res.FlagReason = fmt.Sprintf("PO %d is no longer open for receiving; received without PO", *req.POID)
+			req.POID = nil
+			req.SkipPOCheck = true
+			status, body, err = processScan(req, user)
+		}
+
+		res.HTTPStatus = status
+		res.Result = body
+		if err != nil {
+			res.Status = "error"
+			res.Error = err.Error()
+		} else {
+			res.Status, _ = body["status"].(string)
+		}
+		switch res.Status {
+		case "multiple_pos":
+			res.Flagged = true
+			res.FlagReason = "Matches several open POs; resubmit with po_id"
+		case "not_found":
+			res.Flagged = true
+			res.FlagReason = "Product not found"
+		}
+		if warning, ok := body["warning"].(string); ok && !res.Flagged {
+			res.Flagged = true
+			res.FlagReason = warning
+		}
+
+		counts[res.Status]++
+		if res.Flagged {
+			counts["flagged"]++
+		}
+		results = append(results, res)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":   len(results),
+		"summary": counts,
+		"results": results,
+	})
+}
+
+// UnscanHandler reverses a scan event. Body: {"event_id"}.
+// A scan or correction still staged in an open session is taken out of the
+// session; one whose receipt was finalized rolls back POItem.QtyReceived, its
+// status and stock; an ad-hoc receipt takes the units back out of stock.
+// A reversal that would take a line, QtyReceived or stock below zero is
+// refused with 409.
+func UnscanHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		EventID uint `json:"event_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.EventID == 0 {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	user := requestUser(r)
+
+	response := map[string]interface{}{}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var event models.ScanEvent
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&event, payload.EventID).Error; err != nil {
+			return err
+		}
+		if event.ReversedAt != nil {
+			return errScanReversed
+		}
+
+		var err error
+		switch event.Kind {
+		case models.ScanStaged, models.ScanCorrection:
+			err = reverseStagedScan(tx, event, user, response)
+		default:
+			if err = takeBackStock(tx, event, &event.LocationID, event.Bin); err != nil {
+				return err
+			}
+			_, err = inventory.Apply(tx, inventory.Change{
+				ProductID:  event.ProductID,
+				Delta:      -event.Qty,
+				LocationID: &event.LocationID,
+				Bin:        event.Bin,
+				Reason:     models.MovementReceipt,
+				Ref:        inventory.Ref{Type: models.RefUser},
+				User:       user,
+				Note:       fmt.Sprintf("Unscan %d", event.ID),
+			})
+			response["reversed"] = "stock"
+		}
+		if err != nil {
+			return err
+		}
 
+		now := time.Now()
+		event.ReversedAt = &now
+		event.ReversedBy = user
+		response["scan_event"] = event
+		return tx.Model(&event).Updates(map[string]interface{}{"reversed_at": now, "reversed_by": user}).Error
+	})
+	if err != nil {
+		receivingError(w, err)
+		return
+	}
+	response["status"] = "reversed"
 	json.NewEncoder(w).Encode(response)
 }
+
+// takeBackStock checks that the location/bin still holds the units of a
+// receipt being reversed; ones already sold or moved cannot be unscanned
+func takeBackStock(tx *gorm.DB, event models.ScanEvent, locationID *uint, bin string) error {
+	qty, err := inventory.LockBinQty(tx, event.ProductID, locationID, bin)
+	if err != nil {
+		return err
+	}
+	if qty < event.Qty {
+		return fmt.Errorf("%s: %d in stock, removing %d: %w", event.SKU, qty, event.Qty, errBelowZero)
+	}
+	return nil
+}
+
+// reverseStagedScan undoes a STAGED scan or CORRECTION according to its
+// session's state
+func reverseStagedScan(tx *gorm.DB, event models.ScanEvent, user string, response map[string]interface{}) error {
+	var session models.ReceivingSession
+	if err := tx.First(&session, *event.SessionID).Error; err != nil {
+		return err
+	}
+	// Purchase order first, then session: the receiving lock order
+	var po models.PurchaseOrder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&po, session.POID).Error; err != nil {
+		return err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&session, session.ID).Error; err != nil {
+		return err
+	}
+
+	switch session.Status {
+	case models.ReceivingOpen:
+		// Still staged: nothing was committed yet
+		line, err := stageReceipt(tx, session, models.Product{ID: event.ProductID, SKU: event.SKU}, -event.Qty, "")
+		if err != nil {
+			return err
+		}
+		response["staged_line"] = line
+		response["reversed"] = "staged"
+		return nil
+
+	case models.ReceivingFinalized:
+		var item models.POItem
+		if err := tx.Where("po_id = ? AND sku = ?", po.ID, event.SKU).First(&item).Error; err == nil {
+			if err := addPOItemReceived(tx, &item, -event.Qty); err != nil {
+				return err
+			}
+			response["po_item"] = item
+
+			// The PO is no longer fully received
+			if po.Status == models.POStatusReceived &&
+				item.Status != models.POItemStatusCompleted && item.Status != models.POItemStatusOverfilled {
+				if err := transitionPO(tx, &po, reopenTarget(po), user, fmt.Sprintf("Unscan %d", event.ID)); err != nil {
+					return err
+				}
+			}
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		bin := event.Bin
+		var line models.ReceivingLine
+		if err := tx.Where("session_id = ? AND product_id = ?", session.ID, event.ProductID).First(&line).Error; err == nil {
+			bin = line.Bin
+		}
+		if err := takeBackStock(tx, event, session.LocationID, bin); err != nil {
+			return err
+		}
+		if _, err := inventory.Apply(tx, inventory.Change{
+			ProductID:  event.ProductID,
+			Delta:      -event.Qty,
+			LocationID: session.LocationID,
+			Bin:        bin,
+			Reason:     models.MovementReceipt,
+			Ref:        inventory.Ref{Type: models.RefPurchaseOrder, ID: strconv.FormatUint(uint64(po.ID), 10)},
+			User:       user,
+			Note:       fmt.Sprintf("Unscan %d", event.ID),
+		}); err != nil {
+			return err
+		}
+		response["reversed"] = "received"
+		return nil
+
+	default:
+		// Discarded session: the scan never reached stock
+		response["reversed"] = "discarded"
+		return nil
+	}
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"backroom/internal/channels"
+	"backroom/internal/models"
+	"backroom/internal/woo"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	raceWorkers = 8  // Parallel clients
+	raceScans   = 10 // Scans per client
+)
+
+// raceServer serves the stock-changing routes the race tests drive
+func raceServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	r := chi.NewRouter()
+	r.Put("/products/{id}", UpdateProductHandler)
+	r.Post("/products/{id}/adjust", AdjustProductHandler)
+	r.Post("/scan/item", ScanItemHandler)
+	r.Post("/scan/unscan", UnscanHandler)
+	r.Post("/orders/{id}/receiving", StartReceivingHandler)
+	r.Post("/receiving/{id}/scan", ReceivingScanHandler)
+	r.Post("/receiving/{id}/finalize", FinalizeReceivingHandler)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// apiCall sends body as JSON and decodes a 200 response into out. It only
+// reports through t.Errorf, so goroutines can use it.
+func apiCall(t *testing.T, srv *httptest.Server, method, path string, body, out interface{}) int {
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Errorf("%s %s: %v", method, path, err)
+		return 0
+	}
+	req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(data))
+	if err != nil {
+		t.Errorf("%s %s: %v", method, path, err)
+		return 0
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User", "race-test")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Errorf("%s %s: %v", method, path, err)
+		return 0
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Errorf("%s %s: decode: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// scanResponse is the part of a scan response the tests read
+type scanResponse struct {
+	Status    string           `json:"status"`
+	SessionID *uint            `json:"session_id"`
+	ScanEvent models.ScanEvent `json:"scan_event"`
+}
+
+// balanceTotal sums the location balances of a product
+func balanceTotal(t *testing.T, conn *gorm.DB, productID uuid.UUID) int {
+	t.Helper()
+	var total int
+	if err := conn.Model(&models.StockBalance{}).Select("COALESCE(SUM(qty), 0)").
+		Where("product_id = ?", productID).Scan(&total).Error; err != nil {
+		t.Fatalf("balance total: %v", err)
+	}
+	return total
+}
+
+// checkStock compares a product's stock, balances and ledger with want
+func checkStock(t *testing.T, conn *gorm.DB, productID uuid.UUID, want int) {
+	t.Helper()
+	var product models.Product
+	if err := conn.First(&product, "id = ?", productID).Error; err != nil {
+		t.Fatalf("load product: %v", err)
+	}
+	if product.StockOnHand != want {
+		t.Errorf("stock_on_hand = %d, want %d", product.StockOnHand, want)
+	}
+	if got := balanceTotal(t, conn, productID); got != want {
+		t.Errorf("location balances = %d, want %d", got, want)
+	}
+	if got := ledgerTotal(t, conn, productID, ""); got != want {
+		t.Errorf("ledger = %d, want %d", got, want)
+	}
+}
+
+// TestConcurrentScansAdjustmentsAndSync runs ad-hoc scans (each sent twice
+// at once with the same client scan ID), unscans, adjustments, product edits
+// and a Sync Down sale against one SKU at the same time, and checks that no
+// update was lost or applied twice
+func TestConcurrentScansAdjustmentsAndSync(t *testing.T) {
+	conn := testDB(t)
+	srv := raceServer(t)
+	product := testProduct(t, conn, 100)
+	const sold = 5
+
+	fake := &fakeWoo{}
+	wooSrv := httptest.NewServer(fake)
+	defer wooSrv.Close()
+	created := wooTime(time.Now().UTC().Add(-time.Hour))
+	fake.set(woo.Order{ID: 1, Status: "processing", DateCreatedGMT: created, DateModifiedGMT: created,
+		LineItems: []woo.LineItem{{SKU: product.SKU, Quantity: sold}}})
+	channel := models.SalesChannel{
+		Name:    "Woo race " + uuid.NewString()[:8],
+		Kind:    models.ChannelKindWooCommerce,
+		BaseURL: wooSrv.URL,
+		APIKey:  "ck",
+		Enabled: true,
+	}
+	if err := conn.Create(&channel).Error; err != nil {
+		t.Fatalf("create channel: %v", err)
+	}
+
+	var unscanned, rejected int64
+	var wg sync.WaitGroup
+	for w := 0; w < raceWorkers; w++ {
+		wg.Add(3)
+		go func(w int) {
+			defer wg.Done()
+			var first uint
+			for i := 0; i < raceScans; i++ {
+				body := map[string]interface{}{
+					"client_scan_id": fmt.Sprintf("%s-%d-%d", product.SKU, w, i),
+					"code":           product.SKU,
+					"skip_po_check":  true,
+				}
+				var resp [2]scanResponse
+				var dupes sync.WaitGroup
+				for a := range resp {
+					dupes.Add(1)
+					go func(a int) {
+						defer dupes.Done()
+						if status := apiCall(t, srv, http.MethodPost, "/scan/item", body, &resp[a]); status != http.StatusOK {
+							t.Errorf("scan: status %d", status)
+						}
+					}(a)
+				}
+				dupes.Wait()
+				if resp[0].ScanEvent.ID != resp[1].ScanEvent.ID {
+					t.Errorf("retried scan stored as events %d and %d", resp[0].ScanEvent.ID, resp[1].ScanEvent.ID)
+				}
+				if i == 0 {
+					first = resp[0].ScanEvent.ID
+				}
+			}
+
+			// Two clients reverse the same scan: only one may succeed
+			var unscans sync.WaitGroup
+			for a := 0; a < 2; a++ {
+				unscans.Add(1)
+				go func() {
+					defer unscans.Done()
+					switch status := apiCall(t, srv, http.MethodPost, "/scan/unscan", map[string]interface{}{"event_id": first}, nil); status {
+					case http.StatusOK:
+						atomic.AddInt64(&unscanned, 1)
+					case http.StatusConflict:
+						atomic.AddInt64(&rejected, 1)
+					default:
+						t.Errorf("unscan: status %d", status)
+					}
+				}()
+			}
+			unscans.Wait()
+		}(w)
+		go func() {
+			defer wg.Done()
+			body := map[string]interface{}{"delta": -1, "reason_code": models.AdjustDamaged, "note": "race test"}
+			if status := apiCall(t, srv, http.MethodPost, "/products/"+product.ID.String()+"/adjust", body, nil); status != http.StatusOK {
+				t.Errorf("adjust: status %d", status)
+			}
+		}()
+		go func(w int) {
+			defer wg.Done()
+			// Saves a product loaded before the other updates landed
+			body := map[string]interface{}{"title": fmt.Sprintf("Race test %d", w)}
+			if status := apiCall(t, srv, http.MethodPut, "/products/"+product.ID.String(), body, nil); status != http.StatusOK {
+				t.Errorf("update product: status %d", status)
+			}
+		}(w)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := RunSyncDown(conn, channel, channels.NewWooCommerce(wooSrv.URL, "ck", "cs")); err != nil {
+			t.Errorf("sync down: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	if unscanned != raceWorkers || rejected != raceWorkers {
+		t.Errorf("unscans: %d succeeded and %d rejected, want %d each", unscanned, rejected, raceWorkers)
+	}
+	received := raceWorkers*raceScans - raceWorkers
+	checkStock(t, conn, product.ID, 100+received-sold-raceWorkers)
+	if got := ledgerTotal(t, conn, product.ID, models.MovementReceipt); got != 100+received {
+		t.Errorf("receipt movements = %d, want %d", got, 100+received)
+	}
+	if got := ledgerTotal(t, conn, product.ID, models.MovementSale); got != -sold {
+		t.Errorf("sale movements = %d, want %d", got, -sold)
+	}
+	var events int64
+	conn.Model(&models.ScanEvent{}).Where("product_id = ?", product.ID).Count(&events)
+	if events != raceWorkers*raceScans {
+		t.Errorf("scan events = %d, want %d", events, raceWorkers*raceScans)
+	}
+}
+
+// TestConcurrentPOReceiving stages scans for a PO through both scan routes at
+// once, unscans some while the session is open, finalizes it from several
+// clients at once and then unscans finalized scans in parallel. The PO item's
+// received quantity, stock and ledger must match exactly at every step.
+func TestConcurrentPOReceiving(t *testing.T) {
+	conn := testDB(t)
+	srv := raceServer(t)
+	product := testProduct(t, conn, 0)
+
+	ordered := raceWorkers * (raceScans - 1)
+	po := models.PurchaseOrder{
+		SupplierName: "Race test",
+		Status:       models.POStatusPending,
+		Items:        []models.POItem{{SKU: product.SKU, QtyOrdered: ordered}},
+	}
+	if err := conn.Create(&po).Error; err != nil {
+		t.Fatalf("create PO: %v", err)
+	}
+	var session models.ReceivingSession
+	if status := apiCall(t, srv, http.MethodPost, fmt.Sprintf("/orders/%d/receiving", po.ID), nil, &session); status != http.StatusOK {
+		t.Fatalf("start receiving: status %d", status)
+	}
+
+	// Every client keeps its first scan and unscans its last one
+	firsts := make([]uint, raceWorkers)
+	var wg sync.WaitGroup
+	for w := 0; w < raceWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var last uint
+			for i := 0; i < raceScans; i++ {
+				var resp scanResponse
+				var status int
+				if i%2 == 0 {
+					body := map[string]interface{}{"code": product.SKU, "po_id": po.ID}
+					status = apiCall(t, srv, http.MethodPost, "/scan/item", body, &resp)
+				} else {
+					body := map[string]interface{}{"code": product.SKU}
+					status = apiCall(t, srv, http.MethodPost, fmt.Sprintf("/receiving/%d/scan", session.ID), body, &resp)
+				}
+				if status != http.StatusOK {
+					t.Errorf("scan: status %d", status)
+					continue
+				}
+				if resp.ScanEvent.SessionID == nil || *resp.ScanEvent.SessionID != session.ID {
+					t.Errorf("scan staged outside session %d", session.ID)
+				}
+				if i == 0 {
+					firsts[w] = resp.ScanEvent.ID
+				}
+				last = resp.ScanEvent.ID
+			}
+			if status := apiCall(t, srv, http.MethodPost, "/scan/unscan", map[string]interface{}{"event_id": last}, nil); status != http.StatusOK {
+				t.Errorf("unscan staged: status %d", status)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	var line models.ReceivingLine
+	if err := conn.Where("session_id = ? AND product_id = ?", session.ID, product.ID).First(&line).Error; err != nil {
+		t.Fatalf("load staged line: %v", err)
+	}
+	if line.Qty != ordered {
+		t.Fatalf("staged qty = %d, want %d", line.Qty, ordered)
+	}
+	body := map[string]interface{}{"code": product.SKU, "qty": -(ordered + 1)}
+	if status := apiCall(t, srv, http.MethodPost, fmt.Sprintf("/receiving/%d/scan", session.ID), body, nil); status != http.StatusConflict {
+		t.Errorf("taking more than staged: status %d, want %d", status, http.StatusConflict)
+	}
+
+	// Only one of several simultaneous finalizes may commit the session
+	var finalized int64
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch status := apiCall(t, srv, http.MethodPost, fmt.Sprintf("/receiving/%d/finalize", session.ID), nil, nil); status {
+			case http.StatusOK:
+				atomic.AddInt64(&finalized, 1)
+			case http.StatusConflict:
+			default:
+				t.Errorf("finalize: status %d", status)
+			}
+		}()
+	}
+	wg.Wait()
+	if finalized != 1 {
+		t.Fatalf("%d finalizes succeeded, want 1", finalized)
+	}
+
+	checkReceived := func(want int, status models.POStatus) {
+		t.Helper()
+		var item models.POItem
+		if err := conn.Where("po_id = ?", po.ID).First(&item).Error; err != nil {
+			t.Fatalf("load PO item: %v", err)
+		}
+		if item.QtyReceived != want {
+			t.Errorf("qty_received = %d, want %d", item.QtyReceived, want)
+		}
+		if err := conn.First(&po, po.ID).Error; err != nil {
+			t.Fatalf("load PO: %v", err)
+		}
+		if po.Status != status {
+			t.Errorf("PO status = %s, want %s", po.Status, status)
+		}
+		checkStock(t, conn, product.ID, want)
+		if got := ledgerTotal(t, conn, product.ID, models.MovementReceipt); got != want {
+			t.Errorf("receipt movements = %d, want %d", got, want)
+		}
+	}
+	checkReceived(ordered, models.POStatusReceived)
+
+	// Reversing finalized scans in parallel reopens the PO exactly once
+	for _, id := range firsts {
+		wg.Add(1)
+		go func(id uint) {
+			defer wg.Done()
+			if status := apiCall(t, srv, http.MethodPost, "/scan/unscan", map[string]interface{}{"event_id": id}, nil); status != http.StatusOK {
+				t.Errorf("unscan received: status %d", status)
+			}
+		}(id)
+	}
+	wg.Wait()
+	checkReceived(ordered-raceWorkers, models.POStatusPending)
+
+	var reopened int64
+	conn.Model(&models.POStatusChange{}).Where("po_id = ? AND \"from\" = ?", po.ID, models.POStatusReceived).Count(&reopened)
+	if reopened != 1 {
+		t.Errorf("PO left RECEIVED %d times, want 1", reopened)
+	}
+}
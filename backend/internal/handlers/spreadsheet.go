@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// sheetData is the sheet of an uploaded supplier file, XLSX or CSV/TSV
+type sheetData struct {
+	Format    string     `json:"format"`              // xlsx | csv
+	Sheet     string     `json:"sheet"`               // Sheet that was read
+	Sheets    []string   `json:"sheets"`              // Every sheet in the file
+	Delimiter string     `json:"delimiter,omitempty"` // CSV only
+	Encoding  string     `json:"encoding,omitempty"`  // CSV only: utf-8 | windows-1252
+	Rows      [][]string `json:"-"`
+	source    []byte     // Original XLSX, for annotated copies
+}
+
+// csvDelimiters are tried in order; the first wins a tie
+var csvDelimiters = []rune{',', ';', '\t', '|'}
+
+// readSheet reads the named sheet (the first one when empty) of an XLSX
+// file, or the rows of a CSV/TSV file, which has a single sheet
+func readSheet(data []byte, fileName, sheet string) (*sheetData, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return readXLSX(data, sheet)
+	}
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if ext == ".xls" {
+		return nil, errors.New("old .xls files are not supported, save as .xlsx or .csv")
+	}
+	return readCSV(data, ext == ".tsv")
+}
+
+func readXLSX(data []byte, sheet string) (*sheetData, error) {
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("failed to read Excel")
+	}
+	defer f.Close()
+
+	s := &sheetData{Format: "xlsx", Sheets: f.GetSheetList(), source: data}
+	s.Sheet = f.GetSheetName(0)
+	if sheet != "" {
+		if idx, err := f.GetSheetIndex(sheet); err != nil || idx < 0 {
+			return nil, fmt.Errorf("sheet %q not found in file (sheets: %s)", sheet, strings.Join(s.Sheets, ", "))
+		}
+		s.Sheet = sheet
+	}
+	if s.Rows, err = f.GetRows(s.Sheet); err != nil {
+		return nil, errors.New("failed to get rows")
+	}
+	return s, nil
+}
+
+func readCSV(data []byte, tab bool) (*sheetData, error) {
+	s := &sheetData{Format: "csv", Sheet: "Sheet1", Sheets: []string{"Sheet1"}, Encoding: "utf-8"}
+
+	// Excel on Windows exports "CSV" in the ANSI code page, a superset of
+	// Latin-1; anything that is not valid UTF-8 is read as that
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		decoded, err := charmap.Windows1252.NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, errors.New("failed to decode CSV")
+		}
+		data = decoded
+		s.Encoding = "windows-1252"
+	}
+
+	delimiter := '\t'
+	if !tab {
+		delimiter = detectDelimiter(data)
+	}
+	s.Delimiter = string(delimiter)
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %v", err)
+	}
+	s.Rows = rows
+	return s, nil
+}
+
+// detectDelimiter picks the candidate found the most times on every one of
+// the first lines; a single-column file falls back to a comma
+func detectDelimiter(data []byte) rune {
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+		if len(lines) == 10 {
+			break
+		}
+	}
+
+	best, bestScore := csvDelimiters[0], 0
+	for _, d := range csvDelimiters {
+		score := -1
+		for _, line := range lines {
+			n := strings.Count(line, string(d))
+			if score < 0 || n < score {
+				score = n
+			}
+		}
+		if score > bestScore {
+			best, bestScore = d, score
+		}
+	}
+	return best
+}
+
+// workbook returns the file as XLSX, converting CSV rows into one sheet
+func (s *sheetData) workbook() []byte {
+	if s.source != nil {
+		return s.source
+	}
+	f := excelize.NewFile()
+	defer f.Close()
+	f.SetSheetName(f.GetSheetName(0), s.Sheet)
+	for i, row := range s.Rows {
+		values := make([]interface{}, len(row))
+		for j, v := range row {
+			values[j] = v
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		f.SetSheetRow(s.Sheet, cell, &values)
+	}
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
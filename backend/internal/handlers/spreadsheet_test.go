@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// TestDetectDelimiter checks that the delimiter found on every line wins
+// over one that only appears inside some values
+func TestDetectDelimiter(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want rune
+	}{
+		{"comma", "sku,qty\nA-1,2\nB-2,3\n", ','},
+		{"semicolon with decimal commas", "sku;qty;cost\nA-1;2;10,50\nB-2;3;7,25\n", ';'},
+		{"tab", "sku\tqty\nA-1\t2\n", '\t'},
+		{"pipe", "sku|qty\nA-1|2\n", '|'},
+		{"comma not on every line", "sku;title\nA-1;Red, large\nB-2;Blue\n", ';'},
+		{"single column", "sku\nA-1\nB-2\n", ','},
+		{"blank lines ignored", "\n\nsku;qty\n\nA-1;2\n", ';'},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectDelimiter([]byte(tt.data)); got != tt.want {
+				t.Errorf("delimiter = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestReadSheetCSV covers the encodings and delimiters of CSV/TSV uploads
+func TestReadSheetCSV(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		fileName  string
+		encoding  string
+		delimiter string
+		rows      [][]string
+	}{
+		{
+			name:      "utf-8 with BOM",
+			data:      "\xef\xbb\xbfCódigo,Cantidad\nA-1,2\n",
+			fileName:  "pedido.csv",
+			encoding:  "utf-8",
+			delimiter: ",",
+			rows:      [][]string{{"Código", "Cantidad"}, {"A-1", "2"}},
+		},
+		{
+			name:      "windows-1252",
+			data:      "C\xf3digo;Descripci\xf3n\nA-1;Ni\xf1o\n",
+			fileName:  "pedido.csv",
+			encoding:  "windows-1252",
+			delimiter: ";",
+			rows:      [][]string{{"Código", "Descripción"}, {"A-1", "Niño"}},
+		},
+		{
+			name:      "tsv by extension",
+			data:      "sku\tqty,extra\nA-1\t2,3\n",
+			fileName:  "pedido.TSV",
+			encoding:  "utf-8",
+			delimiter: "\t",
+			rows:      [][]string{{"sku", "qty,extra"}, {"A-1", "2,3"}},
+		},
+		{
+			name:      "ragged rows and lazy quotes",
+			data:      "sku,title\nA-1,12\" pipe\nB-2\n",
+			fileName:  "pedido.csv",
+			encoding:  "utf-8",
+			delimiter: ",",
+			rows:      [][]string{{"sku", "title"}, {"A-1", "12\" pipe"}, {"B-2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := readSheet([]byte(tt.data), tt.fileName, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Format != "csv" || s.Encoding != tt.encoding || s.Delimiter != tt.delimiter {
+				t.Errorf("format %s, encoding %s, delimiter %q", s.Format, s.Encoding, s.Delimiter)
+			}
+			if len(s.Rows) != len(tt.rows) {
+				t.Fatalf("rows = %q, want %q", s.Rows, tt.rows)
+			}
+			for i := range tt.rows {
+				if strings.Join(s.Rows[i], "|") != strings.Join(tt.rows[i], "|") {
+					t.Errorf("row %d = %q, want %q", i, s.Rows[i], tt.rows[i])
+				}
+			}
+		})
+	}
+}
+
+// TestReadSheetXLSX checks sheet selection and the errors for unknown
+// sheets and old .xls files
+func TestReadSheetXLSX(t *testing.T) {
+	f := excelize.NewFile()
+	f.SetSheetName(f.GetSheetName(0), "Resumen")
+	f.SetCellValue("Resumen", "A1", "Totales")
+	f.NewSheet("Pedido")
+	f.SetSheetRow("Pedido", "A1", &[]interface{}{"SKU", "Qty"})
+	f.SetSheetRow("Pedido", "A2", &[]interface{}{"A-1", 2})
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	s, err := readSheet(data, "pedido.xlsx", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Format != "xlsx" || s.Sheet != "Resumen" || len(s.Sheets) != 2 {
+		t.Errorf("default sheet: format %s, sheet %s, sheets %v", s.Format, s.Sheet, s.Sheets)
+	}
+
+	s, err = readSheet(data, "pedido.xlsx", "Pedido")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Sheet != "Pedido" || len(s.Rows) != 2 || s.Rows[1][0] != "A-1" || s.Rows[1][1] != "2" {
+		t.Errorf("named sheet %s rows %q", s.Sheet, s.Rows)
+	}
+	if string(s.workbook()) != string(data) {
+		t.Error("workbook of an XLSX upload is not the original file")
+	}
+
+	if _, err := readSheet(data, "pedido.xlsx", "Otra"); err == nil || !strings.Contains(err.Error(), "Resumen, Pedido") {
+		t.Errorf("unknown sheet error = %v", err)
+	}
+	if _, err := readSheet([]byte("\xd0\xcf\x11\xe0"), "pedido.xls", ""); err == nil || !strings.Contains(err.Error(), ".xls") {
+		t.Errorf("xls error = %v", err)
+	}
+}
@@ -4,14 +4,15 @@ import (
 	"backroom/internal/db"
 	"backroom/internal/models"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
-
 	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
@@ -22,7 +23,7 @@ func GetSuppliersHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(suppliers)
 }
 
-// GetSupplierHandler - Get single
+// GetSupplierHandler - Get single, with its open Purchase Orders
 func GetSupplierHandler(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	var supplier models.Supplier
@@ -30,7 +31,35 @@ func GetSupplierHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
-	json.NewEncoder(w).Encode(supplier)
+
+	openOrders := []models.PurchaseOrder{}
+	db.DB.Preload("Items").
+		Where("supplier_id = ? AND status IN ?", supplier.ID, []models.POStatus{
+			models.POStatusPending, models.POStatusConfirmed, models.POStatusInTransit,
+		}).
+		Order("created_at desc").Find(&openOrders)
+	for i := range openOrders {
+		openOrders[i].ComputeTotals()
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		models.Supplier
+		OpenOrders []models.PurchaseOrder `json:"open_orders"`
+	}{supplier, openOrders})
+}
+
+// stampMapping marks a mapping sent by a client as the current version;
+// clients write -1 for unmapped columns
+func stampMapping(raw models.JSONB) (models.JSONB, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return raw, nil
+	}
+	var m models.MappingConfig
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	m.Version = models.MappingVersion
+	return json.Marshal(m)
 }
 
 // CreateSupplierHandler - Create new
@@ -40,6 +69,16 @@ func CreateSupplierHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid Body", http.StatusBadRequest)
 		return
 	}
+	currency, err := normalizeCurrency(supplier.Currency)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	supplier.Currency = currency
+	if supplier.MappingConfig, err = stampMapping(supplier.MappingConfig); err != nil {
+		http.Error(w, "Invalid mapping_config", http.StatusBadRequest)
+		return
+	}
 	var count int64
 	db.DB.Model(&models.Supplier{}).Where("name = ?", supplier.Name).Count(&count)
 	if count > 0 {
@@ -72,41 +111,68 @@ func UpdateSupplierHandler(w http.ResponseWriter, r *http.Request) {
 
 	supplier.Name = updateData.Name
 	supplier.Notes = updateData.Notes
+	currency, err := normalizeCurrency(updateData.Currency)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	supplier.Currency = currency
 	supplier.Contacts = updateData.Contacts
-	supplier.MappingConfig = updateData.MappingConfig
+	if supplier.MappingConfig, err = stampMapping(updateData.MappingConfig); err != nil {
+		http.Error(w, "Invalid mapping_config", http.StatusBadRequest)
+		return
+	}
 	// DetectedBrands is usually read-only or system updated, but allowing update here for simplicity
 
-	db.DB.Save(&supplier)
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&supplier).Error; err != nil {
+			return err
+		}
+		// POs keep a copy of the name for display; follow the rename
+		return tx.Model(&models.PurchaseOrder{}).Where("supplier_id = ?", supplier.ID).
+			Update("supplier_name", supplier.Name).Error
+	})
+	if err != nil {
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
 	json.NewEncoder(w).Encode(supplier)
 }
 
-// PreviewExcelHandler - Returns top 10 rows for mapping wizard
+// PreviewExcelHandler - Returns top rows of a sheet for mapping wizard,
+// with the list of sheets in the file and a mapping suggested from the
+// header names. Form: file, optional sheet.
 func PreviewExcelHandler(w http.ResponseWriter, r *http.Request) {
-	file, _, err := r.FormFile("file")
+	file, header, err := r.FormFile("file")
 	if err != nil {
 		http.Error(w, "Invalid file", http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
-
-	// Use Excelize to read stream or file
-	f, err := excelize.OpenReader(file)
+	data, err := io.ReadAll(file)
 	if err != nil {
-		http.Error(w, "Failed to read Excel", http.StatusBadRequest)
+		http.Error(w, "Invalid file", http.StatusBadRequest)
 		return
 	}
-	defer f.Close()
 
-	// Get first sheet
-	sheetName := f.GetSheetName(0)
-	rows, err := f.GetRows(sheetName)
+	// XLSX, CSV or TSV
+	sheet, err := readSheet(data, header.Filename, r.FormValue("sheet"))
 	if err != nil {
-		http.Error(w, "Failed to get rows", http.StatusInternalServerError)
+		http.Error(w, "Invalid file: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	rows := sheet.Rows
+
+	suggestion := suggestMapping(rows)
+	if sheet.Format == "xlsx" {
+		suggestion.Mapping.Sheet = sheet.Sheet
+	}
 
-	// Return top 15
+	// Return top 15, and a few rows past a header found further down
 	limit := 15
+	if suggestion.HeaderRow+5 > limit {
+		limit = suggestion.HeaderRow + 5
+	}
 	if len(rows) < limit {
 		limit = len(rows)
 	}
@@ -116,10 +182,17 @@ func PreviewExcelHandler(w http.ResponseWriter, r *http.Request) {
 		result = [][]string{}
 	}
 
-	json.NewEncoder(w).Encode(result)
+	json.NewEncoder(w).Encode(struct {
+		*sheetData
+		Rows       [][]string        `json:"rows"`
+		Suggestion MappingSuggestion `json:"suggestion"`
+	}{sheet, result, suggestion})
 }
 
-// CatalogUploadHandler - Process Supplier Catalog (Excel/CSV)
+// CatalogUploadHandler - Process Supplier Catalog (Excel/CSV).
+// Invalid rows are rejected with their reason and an annotated copy of the
+// file; the valid ones are written in one transaction. With strict=true any
+// rejected row cancels the whole import.
 func CatalogUploadHandler(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 
@@ -138,20 +211,17 @@ func CatalogUploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse File
-	file, _, err := r.FormFile("file")
+	file, header, err := r.FormFile("file")
 	if err != nil {
 		http.Error(w, "Invalid file", http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
-
-	// Open Excel
-	f, err := excelize.OpenReader(file)
+	source, err := io.ReadAll(file)
 	if err != nil {
-		http.Error(w, "Failed to read Excel", http.StatusBadRequest)
+		http.Error(w, "Invalid file", http.StatusBadRequest)
 		return
 	}
-	defer f.Close()
 
 	// Read Mapping
 	var mapping models.MappingConfig
@@ -162,18 +232,22 @@ func CatalogUploadHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		// Fallback Defaults
-		mapping = models.MappingConfig{HeaderRow: 0, ColSKU: 0, ColQty: 1, ColPrice: 2, ColBrand: 3}
+		mapping = models.MappingConfig{HeaderRow: 0, ColSKU: 0, ColBarcode: -1, ColQty: 1, ColPrice: 2, ColBrand: 3, ColCost: -1}
 	}
 
-	rows, err := f.GetRows(f.GetSheetName(0))
+	// Open Excel or CSV
+	sheet, err := readSheet(source, header.Filename, mapping.Sheet)
 	if err != nil {
-		http.Error(w, "Failed to get rows", http.StatusInternalServerError)
+		http.Error(w, "Invalid file: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	mapping, warnings := resolveMapping(mapping, sheet.Rows)
+	rows := sheet.Rows
 
 	var products []models.Product
-	productMap := make(map[string]models.Product)
+	productRow := make(map[string]int) // SKU -> row it was read from
 	brandSet := make(map[string]struct{})
+	rejected := []skippedRow{}
 
 	// Load existing brands
 	var existingBrands []string
@@ -184,85 +258,57 @@ func CatalogUploadHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	startRow := mapping.HeaderRow + 1
-
-	// Find the maximum index we need to access
-	maxCols := mapping.ColSKU
-	if mapping.ColTitle > maxCols {
-		maxCols = mapping.ColTitle
-	}
-	if mapping.ColPrice > maxCols {
-		maxCols = mapping.ColPrice
-	}
-	if mapping.ColBrand > maxCols {
-		maxCols = mapping.ColBrand
-	}
-	if mapping.ColBarcode > maxCols {
-		maxCols = mapping.ColBarcode
-	}
-	// mapping.ColQty is ignored now, so we don't strictly need it to be padded
-
-	for i := startRow; i < len(rows); i++ {
+	for i := mapping.HeaderRow + 1; i < len(rows); i++ {
 		row := rows[i]
-
-		// Pad row if Excel truncated empty trailing columns
-		for len(row) <= maxCols {
-			row = append(row, "")
+		reject := func(sku, reason string) {
+			rejected = append(rejected, skippedRow{Row: i + 1, SKU: sku, Reason: reason, Error: true})
 		}
 
-		if mapping.ColSKU >= len(row) {
+		sku := cellAt(row, mapping.ColSKU)
+		if sku == "" {
+			if strings.TrimSpace(strings.Join(row, "")) != "" {
+				reject("", "missing SKU")
+			}
 			continue
 		}
-
-		sku := strings.TrimSpace(row[mapping.ColSKU])
-		if sku == "" {
+		if first, ok := productRow[sku]; ok {
+			reject(sku, fmt.Sprintf("duplicate SKU, already on row %d", first))
 			continue
 		}
 
 		// Parse Price
 		price := 0.0
-		if mapping.ColPrice < len(row) {
-			pStr := strings.ReplaceAll(row[mapping.ColPrice], "$", "")
-			pStr = strings.ReplaceAll(pStr, ",", "")
-			if val, err := strconv.ParseFloat(strings.TrimSpace(pStr), 64); err == nil {
-				price = val
+		if raw := cellAt(row, mapping.ColPrice); raw != "" {
+			val, ok := parseAmount(raw)
+			if !ok || val < 0 {
+				reject(sku, fmt.Sprintf("invalid price %q", raw))
+				continue
 			}
+			price = val
 		}
 
 		// Qty is no longer parsed here; SOH is managed by Purchase Orders.
 
-		// Brand
-		brand := ""
-		if mapping.ColBrand < len(row) {
-			brand = strings.TrimSpace(row[mapping.ColBrand])
-			if brand != "" {
-				brandSet[brand] = struct{}{}
-			}
-		}
-
 		// Barcode
 		barcode := ""
-		if mapping.ColBarcode > 0 && mapping.ColBarcode < len(row) {
-			rawBarcode := strings.TrimSpace(row[mapping.ColBarcode])
-			// If excel treats large numbers as scientific notation or floats:
-			if fVal, err := strconv.ParseFloat(rawBarcode, 64); err == nil && strings.Contains(rawBarcode, "E") {
-				// Format large sci-notation to full integer string
-				barcode = strconv.FormatFloat(fVal, 'f', 0, 64)
-			} else {
-				barcode = rawBarcode
-			}
+		if mapping.ColBarcode >= 0 {
+			barcode = parseBarcode(cellAt(row, mapping.ColBarcode))
 		}
 
 		// Title
 		title := "Imported " + sku
-		if mapping.ColTitle >= 0 && mapping.ColTitle < len(row) {
-			titleVal := strings.TrimSpace(row[mapping.ColTitle])
-			if titleVal != "" {
-				title = titleVal
-			}
+		if titleVal := cellAt(row, mapping.ColTitle); titleVal != "" {
+			title = titleVal
 		}
 
-		productMap[sku] = models.Product{
+		// Brand
+		brand := cellAt(row, mapping.ColBrand)
+		if brand != "" {
+			brandSet[brand] = struct{}{}
+		}
+
+		productRow[sku] = i + 1
+		products = append(products, models.Product{
 			SKU:         sku,
 			Barcode:     barcode,
 			SupplierID:  &supplier.ID,
@@ -272,31 +318,19 @@ func CatalogUploadHandler(w http.ResponseWriter, r *http.Request) {
 			Price:       price,
 			StockOnHand: 0,                    // SOH should only be filled by Purchase Orders
 			Status:      models.StatusPending, // PENDING_IMAGE
-		}
-	}
-
-	for _, p := range productMap {
-		products = append(products, p)
+		})
 	}
 
-	if len(products) > 0 {
-		// Bulk UPSERT
-		// Update: Title, Description, Brand, Price.
-		// Ignore: Status, ImagePath, StockOnHand (preserve existing stock from Purchase Orders)
-		err := db.DB.Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "sku"}},
-			DoUpdates: clause.AssignmentColumns([]string{"barcode", "title", "brand", "price", "updated_at"}),
-		}).Create(&products).Error
-
-		if err != nil {
-			log.Printf("Bulk Upsert Error: %v", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error": "Database Error: " + err.Error(),
-			})
-			return
-		}
+	errorReport := saveImportReport(header.Filename, sheet, mapping.HeaderRow, rejected)
+	if len(rejected) > 0 && r.FormValue("strict") == "true" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":        fmt.Sprintf("%d rows were rejected; nothing was imported.", len(rejected)),
+			"rejected":     rejected,
+			"error_report": errorReport,
+		})
+		return
 	}
 
 	// Update Detected Brands
@@ -305,11 +339,38 @@ func CatalogUploadHandler(w http.ResponseWriter, r *http.Request) {
 		newBrands = append(newBrands, b)
 	}
 	brandsJSON, _ := json.Marshal(newBrands)
-	db.DB.Model(&supplier).Update("detected_brands", brandsJSON)
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if len(products) > 0 {
+			// Bulk UPSERT
+			// Update: Title, Description, Brand, Price.
+			// Ignore: Status, ImagePath, StockOnHand (preserve existing stock from Purchase Orders)
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "sku"}},
+				DoUpdates: clause.AssignmentColumns([]string{"barcode", "title", "brand", "price", "updated_at"}),
+			}).Create(&products).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(&supplier).Update("detected_brands", brandsJSON).Error
+	})
+	if err != nil {
+		log.Printf("Bulk Upsert Error: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "Database Error: " + err.Error(),
+		})
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"count":  len(products),
-		"brands": len(newBrands),
+		"count":            len(products),
+		"brands":           len(newBrands),
+		"rejected":         rejected,
+		"mapping_warnings": warnings,
+		"error_report":     errorReport,
 	})
 }
@@ -1,10 +1,24 @@
 package handlers
 
 import (
+	"backroom/internal/channels"
 	"backroom/internal/db"
+	"backroom/internal/inventory"
 	"backroom/internal/models"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // GetSyncStatusHandler returns counts of items ready to sync
@@ -18,11 +32,694 @@ func GetSyncStatusHandler(w http.ResponseWriter, r *http.Request) {
 	// Count orders (e.g., status = 'Pending')
 	db.DB.Model(&models.PurchaseOrder{}).Where("status = ?", models.POStatusPending).Count(&orderCount)
 
+	// Last successful run from the ledger
+	var lastSynced interface{} = "Never"
+	var lastRun *models.SyncRun
+	var run models.SyncRun
+	if err := db.DB.Where("status = ?", models.SyncRunSuccess).Order("finished_at desc").First(&run).Error; err == nil {
+		lastSynced = run.FinishedAt
+		lastRun = &run
+	}
+
+	// Per-channel view for the Sync Hub
+	type channelStatus struct {
+		ID         uint        `json:"id"`
+		Name       string      `json:"name"`
+		Kind       string      `json:"kind"`
+		Enabled    bool        `json:"enabled"`
+		LastSynced interface{} `json:"last_synced"`
+	}
+	var configured []models.SalesChannel
+	db.DB.Order("id asc").Find(&configured)
+	channelList := []channelStatus{}
+	for _, c := range configured {
+		cs := channelStatus{ID: c.ID, Name: c.Name, Kind: string(c.Kind), Enabled: c.Enabled, LastSynced: "Never"}
+		var last models.SyncRun
+		if err := db.DB.Where("channel_id = ? AND status = ?", c.ID, models.SyncRunSuccess).Order("finished_at desc").First(&last).Error; err == nil {
+			cs.LastSynced = last.FinishedAt
+		}
+		channelList = append(channelList, cs)
+	}
+
 	status := map[string]interface{}{
 		"products_ready": productCount,
 		"orders_pending": orderCount,
-		"last_synced":    "Never", // TODO: Store this in DB
+		"last_synced":    lastSynced,
+		"last_run":       lastRun,
+		"channels":       channelList,
 	}
 
 	json.NewEncoder(w).Encode(status)
 }
+
+// GetSyncRunsHandler lists recent sync runs (without items), newest first
+func GetSyncRunsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	query := db.DB.Order("started_at desc").Limit(limit)
+	if direction := r.URL.Query().Get("direction"); direction != "" {
+		query = query.Where("direction = ?", strings.ToUpper(direction))
+	}
+	if channelID := r.URL.Query().Get("channel_id"); channelID != "" {
+		query = query.Where("channel_id = ?", channelID)
+	}
+
+	var runs []models.SyncRun
+	query.Find(&runs)
+	json.NewEncoder(w).Encode(runs)
+}
+
+// GetSyncRunHandler returns a run with its per-item outcomes
+func GetSyncRunHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var run models.SyncRun
+	if err := db.DB.Preload("Items", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("id asc")
+	}).First(&run, "id = ?", id).Error; err != nil {
+		http.Error(w, "Sync run not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(run)
+}
+
+// RetrySyncRunHandler replays only the failed, unresolved items of a run as a new run
+func RetrySyncRunHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var original models.SyncRun
+	if err := db.DB.First(&original, "id = ?", id).Error; err != nil {
+		http.Error(w, "Sync run not found", http.StatusNotFound)
+		return
+	}
+
+	var failed []models.SyncRunItem
+	db.DB.Where("run_id = ? AND success = ? AND resolved = ?", original.ID, false, false).Order("id asc").Find(&failed)
+	if len(failed) == 0 {
+		http.Error(w, "Run has no failed items to retry", http.StatusConflict)
+		return
+	}
+
+	var channel models.SalesChannel
+	if err := db.DB.First(&channel, original.ChannelID).Error; err != nil {
+		http.Error(w, "Sales channel of this run no longer exists", http.StatusConflict)
+		return
+	}
+
+	var retry models.SyncRun
+	var err error
+	switch original.Direction {
+	case models.SyncDirectionDown:
+		retry, err = retrySyncDown(channel, original, failed)
+	case models.SyncDirectionUp:
+		var ch channels.Channel
+		if ch, err = channels.FromModel(channel); err == nil {
+			retry, err = retrySyncUp(channel, ch, original, failed)
+		}
+	default:
+		http.Error(w, "Unknown sync direction", http.StatusInternalServerError)
+		return
+	}
+	if err != nil && retry.ID == 0 {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	// A retry that stopped partway still returns its run, with the error
+	db.DB.Preload("Items").First(&retry, retry.ID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(retry)
+}
+
+// startSyncRun opens a ledger entry in RUNNING state
+func startSyncRun(conn *gorm.DB, channelID uint, direction models.SyncDirection, retryOf *uint) (models.SyncRun, error) {
+	run := models.SyncRun{
+		ChannelID: channelID,
+		Direction: direction,
+		Status:    models.SyncRunRunning,
+		RetryOfID: retryOf,
+		StartedAt: time.Now(),
+	}
+	if err := conn.Create(&run).Error; err != nil {
+		return run, fmt.Errorf("record sync run: %w", err)
+	}
+	return run, nil
+}
+
+// recordSyncItem stores an item outcome and updates the run counters
+func recordSyncItem(tx *gorm.DB, run *models.SyncRun, item models.SyncRunItem) error {
+	item.RunID = run.ID
+	if err := tx.Create(&item).Error; err != nil {
+		return err
+	}
+	run.ItemsTotal++
+	if item.Success {
+		run.ItemsSucceeded++
+	} else {
+		run.ItemsFailed++
+	}
+	return nil
+}
+
+// finishSyncRun closes the ledger entry; runErr marks the whole run FAILED
+func finishSyncRun(conn *gorm.DB, run *models.SyncRun, runErr error) {
+	now := time.Now()
+	run.FinishedAt = &now
+	switch {
+	case runErr != nil:
+		run.Status = models.SyncRunFailed
+		run.Error = runErr.Error()
+	case run.ItemsFailed > 0:
+		run.Status = models.SyncRunPartial
+	default:
+		run.Status = models.SyncRunSuccess
+	}
+	if err := conn.Omit("Items").Save(run).Error; err != nil {
+		log.Printf("Failed to finish sync run %d: %v", run.ID, err)
+	}
+}
+
+// SyncDownResult summarizes one Sync Down pass over a channel
+type SyncDownResult struct {
+	ChannelID     uint      `json:"channel_id"`
+	ChannelName   string    `json:"channel_name"`
+	RunID         uint      `json:"run_id"`
+	OrdersApplied int       `json:"orders_applied"`
+	OrdersSkipped int       `json:"orders_skipped"` // Already fulfilled via webhook or an earlier run
+	UnitsSold     int       `json:"units_sold"`
+	UnmatchedSKUs []string  `json:"unmatched_skus"`
+	HighWaterMark time.Time `json:"high_water_mark"`
+	LastSynced    time.Time `json:"last_synced"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// SyncDownHandler pulls sales from every enabled channel (or ?channel_id=)
+// since its last sync and decrements local stock
+func SyncDownHandler(w http.ResponseWriter, r *http.Request) {
+	configured, err := loadSyncChannels(r.URL.Query().Get("channel_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	results := []SyncDownResult{}
+	failed := false
+	for _, m := range configured {
+		result := SyncDownResult{ChannelID: m.ID, ChannelName: m.Name, UnmatchedSKUs: []string{}}
+		ch, err := channels.FromModel(m)
+		if err == nil {
+			result, err = RunSyncDown(db.DB, m, ch)
+		}
+		if err != nil {
+			log.Printf("Sync Down Error (%s): %v", m.Name, err)
+			result.Error = err.Error()
+			failed = true
+		}
+		results = append(results, result)
+	}
+
+	if failed {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"channels": results})
+}
+
+// syncDownOverlap is how far before the high-water mark Sync Down asks for
+// sales again. Channel timestamps have one-second resolution and orders may
+// become visible after their timestamp, so the window overlaps the previous
+// run; orders seen twice are skipped by their channel order record.
+const syncDownOverlap = 10 * time.Minute
+
+// RunSyncDown fetches sales changed since the channel's stored high-water
+// mark and subtracts their line quantities from StockOnHand, releasing any
+// reservation a webhook made for the same order. Each order is applied in its
+// own transaction together with its ledger items, and the mark only advances
+// past fully applied orders, so a failure halfway can be re-run. Orders
+// already fulfilled are skipped, which makes the overlapping window safe.
+// Lines that match no local product are recorded as failed items for retry.
+func RunSyncDown(conn *gorm.DB, m models.SalesChannel, ch channels.Channel) (result SyncDownResult, err error) {
+	result = SyncDownResult{ChannelID: m.ID, ChannelName: m.Name, UnmatchedSKUs: []string{}}
+	run, err := startSyncRun(conn, m.ID, models.SyncDirectionDown, nil)
+	if err != nil {
+		return result, err
+	}
+	result.RunID = run.ID
+	defer func() { finishSyncRun(conn, &run, err) }()
+
+	key := channels.SyncStateKey(m.ID, models.SyncDirectionDown)
+	var state models.SyncState
+	if err := conn.FirstOrInit(&state, models.SyncState{Key: key}).Error; err != nil {
+		return result, err
+	}
+	result.HighWaterMark = state.HighWaterMark
+
+	since := state.HighWaterMark
+	if !since.IsZero() {
+		since = since.Add(-syncDownOverlap)
+	}
+	sales, err := ch.ListSalesSince(since)
+	if err != nil {
+		return result, err
+	}
+	sort.SliceStable(sales, func(i, j int) bool { return sales[i].ChangedAt().Before(sales[j].ChangedAt()) })
+
+	for _, sale := range sales {
+		var units int
+		var unmatched []string
+		var duplicate bool
+		snapshot := run
+		err = conn.Transaction(func(tx *gorm.DB) error {
+			// Orders already completed through a webhook (or a previous run) are not applied twice
+			order, found, err := lockChannelOrder(tx, m.ID, sale.OrderRef)
+			if err != nil {
+				return err
+			}
+			if found && order.Status.Sold() {
+				duplicate = true
+				return nil
+			}
+
+			ref := inventory.Ref{Type: models.RefSyncRun, ID: strconv.FormatUint(uint64(run.ID), 10)}
+			missing, err := fulfilChannelOrder(tx, m, &order, sale.Lines, ref)
+			if err != nil {
+				return err
+			}
+			for _, line := range orderLines(order) {
+				productID := line.ProductID
+				item := models.SyncRunItem{
+					ProductID: &productID,
+					SKU:       line.SKU,
+					Reference: sale.OrderRef,
+					Action:    "sale",
+					Quantity:  line.Quantity,
+					Success:   true,
+				}
+				if err := recordSyncItem(tx, &run, item); err != nil {
+					return err
+				}
+				units += line.Quantity
+			}
+			for _, line := range missing {
+				item := models.SyncRunItem{
+					SKU:        line.SKU,
+					ExternalID: line.ExternalID,
+					Reference:  sale.OrderRef,
+					Action:     "sale",
+					Quantity:   line.Quantity,
+					Message:    "No local product matches this line",
+				}
+				if err := recordSyncItem(tx, &run, item); err != nil {
+					return err
+				}
+				unmatched = append(unmatched, lineLabel(line))
+			}
+			return nil
+		})
+		if err != nil {
+			run = snapshot // Counters of the rolled back order do not count
+			saveSyncState(conn, &state)
+			result.HighWaterMark = state.HighWaterMark
+			return result, err
+		}
+
+		if duplicate {
+			result.OrdersSkipped++
+		} else {
+			result.OrdersApplied++
+		}
+		result.UnitsSold += units
+		result.UnmatchedSKUs = append(result.UnmatchedSKUs, unmatched...)
+		if sale.ChangedAt().After(state.HighWaterMark) {
+			state.HighWaterMark = sale.ChangedAt()
+		}
+	}
+
+	state.LastSyncedAt = time.Now()
+	if err := saveSyncState(conn, &state); err != nil {
+		return result, err
+	}
+	result.HighWaterMark = state.HighWaterMark
+	result.LastSynced = state.LastSyncedAt
+	return result, nil
+}
+
+// retrySyncDown re-applies failed sale lines from their stored quantities; no
+// remote call is needed. Each item is claimed by flipping its resolved flag
+// before the sale is applied, so concurrent retries of the same run cannot
+// apply it twice; an item claimed by another retry is skipped. The line is
+// applied through the item's channel order, locked like in RunSyncDown, and
+// added to its lines, so webhooks and later runs see it as sold.
+func retrySyncDown(m models.SalesChannel, original models.SyncRun, failed []models.SyncRunItem) (run models.SyncRun, err error) {
+	if run, err = startSyncRun(db.DB, m.ID, models.SyncDirectionDown, &original.ID); err != nil {
+		return run, err
+	}
+	defer func() { finishSyncRun(db.DB, &run, err) }()
+
+	for _, old := range failed {
+		item := models.SyncRunItem{
+			SKU:        old.SKU,
+			ExternalID: old.ExternalID,
+			Reference:  old.Reference,
+			Action:     old.Action,
+			Quantity:   old.Quantity,
+		}
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			claim := tx.Model(&models.SyncRunItem{}).Where("id = ? AND resolved = ?", old.ID, false).Update("resolved", true)
+			if claim.Error != nil {
+				return claim.Error
+			}
+			if claim.RowsAffected == 0 {
+				return nil
+			}
+
+			message, err := retrySale(tx, &run, m, old, &item)
+			if err != nil {
+				return err
+			}
+			if message != "" {
+				// Not applied: leave the original item open for another retry
+				item.Message = message
+				if err := tx.Model(&old).Update("resolved", false).Error; err != nil {
+					return err
+				}
+			}
+			return recordSyncItem(tx, &run, item)
+		})
+		if err != nil {
+			return run, err
+		}
+	}
+	return run, nil
+}
+
+// retrySale applies a failed sale line to its channel order, or returns why
+// it could not be applied yet. A line whose product the order already holds
+// was applied meanwhile and is not taken out of stock again.
+func retrySale(tx *gorm.DB, run *models.SyncRun, m models.SalesChannel, old models.SyncRunItem, item *models.SyncRunItem) (string, error) {
+	order, found, err := lockChannelOrder(tx, m.ID, old.Reference)
+	if err != nil {
+		return "", err
+	}
+	if found && order.Status != models.ChannelOrderFulfilled {
+		return fmt.Sprintf("Channel order is %s", order.Status), nil
+	}
+	product, err := findSaleProduct(tx, m.ID, old.SKU, old.ExternalID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "No local product matches this line", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	item.ProductID = &product.ID
+	item.SKU = product.SKU
+	item.Success = true
+
+	lines := orderLines(order)
+	for _, l := range lines {
+		if l.ProductID == product.ID {
+			item.Message = "Already applied to the channel order"
+			return "", nil
+		}
+	}
+	line := models.ChannelOrderLine{ProductID: product.ID, SKU: product.SKU, Quantity: old.Quantity}
+	ref := inventory.Ref{Type: models.RefSyncRun, ID: strconv.FormatUint(uint64(run.ID), 10)}
+	if err := shiftStock(tx, []models.ChannelOrderLine{line}, -1, 0, m.LocationID, ref); err != nil {
+		return "", err
+	}
+	return "", saveChannelOrder(tx, &order, models.ChannelOrderFulfilled, append(lines, line))
+}
+
+// findSaleProduct matches a sold line on SKU first, then on the channel's product mapping
+func findSaleProduct(tx *gorm.DB, channelID uint, sku, externalID string) (models.Product, error) {
+	var product models.Product
+	err := gorm.ErrRecordNotFound
+	if sku != "" {
+		err = tx.Where("sku = ?", sku).First(&product).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) && externalID != "" {
+		err = tx.Joins("JOIN product_channels pc ON pc.product_id = products.id").
+			Where("pc.channel_id = ? AND pc.external_id = ?", channelID, externalID).
+			First(&product).Error
+	}
+	return product, err
+}
+
+func lineLabel(line channels.SaleLine) string {
+	if line.SKU != "" {
+		return line.SKU
+	}
+	return "external#" + line.ExternalID
+}
+
+func saveSyncState(conn *gorm.DB, state *models.SyncState) error {
+	return conn.Save(state).Error
+}
+
+// SyncUpItem is the per-product outcome of a Sync Up
+type SyncUpItem struct {
+	ProductID  uuid.UUID             `json:"product_id"`
+	SKU        string                `json:"sku"`
+	Action     string                `json:"action"` // create | update | stock | skip
+	ExternalID string                `json:"external_id,omitempty"`
+	Payload    *channels.ProductData `json:"payload,omitempty"` // What was (or would be) sent
+	Success    bool                  `json:"success"`
+	Message    string                `json:"message,omitempty"`
+}
+
+// SyncUpResult summarizes one Sync Up pass over a channel
+type SyncUpResult struct {
+	ChannelID   uint         `json:"channel_id"`
+	ChannelName string       `json:"channel_name"`
+	RunID       uint         `json:"run_id,omitempty"` // Zero for dry runs, which are not recorded
+	DryRun      bool         `json:"dry_run"`
+	Created     int          `json:"created"`
+	Updated     int          `json:"updated"`
+	Skipped     int          `json:"skipped"`
+	Failed      int          `json:"failed"`
+	Results     []SyncUpItem `json:"results"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// RunSyncUp pushes products to a channel. Products without a mapping in the
+// channel are created (title, price, cropped image, status), or linked to the
+// channel's product with the same SKU when it has one; mapped products
+// only receive stock quantity and price, and archived ones are set to zero
+// stock. With dryRun the payloads are computed and returned without calling
+// the store, so ch may be nil. Real runs are recorded in the sync ledger;
+// retryOf links a retry to its origin.
+func RunSyncUp(m models.SalesChannel, ch channels.Channel, products []models.Product, dryRun bool, retryOf *uint) (SyncUpResult, error) {
+	result := SyncUpResult{ChannelID: m.ID, ChannelName: m.Name, DryRun: dryRun, Results: []SyncUpItem{}}
+
+	var mappings []models.ProductChannel
+	if err := db.DB.Where("channel_id = ?", m.ID).Find(&mappings).Error; err != nil {
+		return result, err
+	}
+	externalIDs := make(map[uuid.UUID]string, len(mappings))
+	for _, pc := range mappings {
+		externalIDs[pc.ProductID] = pc.ExternalID
+	}
+
+	var upserts, stockOnly []int // indexes into result.Results
+	for _, p := range products {
+		item := SyncUpItem{ProductID: p.ID, SKU: p.SKU, ExternalID: externalIDs[p.ID]}
+		data := productData(p, item.ExternalID)
+
+		switch {
+		case item.ExternalID != "" && p.Status == models.StatusArchived:
+			data.Stock = 0
+			item.Action = "stock"
+			stockOnly = append(stockOnly, len(result.Results))
+		case item.ExternalID != "":
+			item.Action = "update"
+			upserts = append(upserts, len(result.Results))
+		case p.Status == models.StatusDraft || p.Status == models.StatusPending || p.Status == models.StatusArchived:
+			item.Action = "skip"
+			item.Success = true
+			item.Message = "Not approved for publishing (status " + string(p.Status) + ")"
+			result.Skipped++
+		default:
+			item.Action = "create"
+			upserts = append(upserts, len(result.Results))
+		}
+		if item.Action != "skip" {
+			item.Payload = &data
+		}
+		result.Results = append(result.Results, item)
+	}
+
+	pending := append(append([]int{}, upserts...), stockOnly...)
+	if dryRun {
+		for _, idx := range pending {
+			result.Results[idx].Success = true
+			result.Results[idx].Message = "dry run"
+			if result.Results[idx].Action == "create" {
+				result.Created++
+			} else {
+				result.Updated++
+			}
+		}
+		return result, nil
+	}
+
+	run, err := startSyncRun(db.DB, m.ID, models.SyncDirectionUp, retryOf)
+	if err != nil {
+		return result, err
+	}
+	result.RunID = run.ID
+
+	batch := make([]channels.ProductData, len(upserts))
+	for n, idx := range upserts {
+		batch[n] = *result.Results[idx].Payload
+	}
+	outcomes := channels.UpsertAll(ch, batch)
+	for n, idx := range upserts {
+		applySyncUpOutcome(m.ID, &result, idx, outcomes[n])
+	}
+	for _, idx := range stockOnly {
+		item := result.Results[idx]
+		err := ch.SetStock(item.ExternalID, item.Payload.Stock)
+		applySyncUpOutcome(m.ID, &result, idx, channels.UpsertResult{ExternalID: item.ExternalID, Err: err})
+	}
+
+	for _, idx := range pending {
+		item := result.Results[idx]
+		ledgerItem := models.SyncRunItem{
+			ProductID:  &item.ProductID,
+			SKU:        item.SKU,
+			ExternalID: item.ExternalID,
+			Action:     item.Action,
+			Quantity:   item.Payload.Stock,
+			Success:    item.Success,
+			Message:    item.Message,
+		}
+		if err := recordSyncItem(db.DB, &run, ledgerItem); err != nil {
+			log.Printf("Failed to record sync item %s: %v", item.SKU, err)
+		}
+	}
+	finishSyncRun(db.DB, &run, nil)
+
+	return result, nil
+}
+
+// applySyncUpOutcome stores the mapping of newly created products and tallies the result
+func applySyncUpOutcome(channelID uint, result *SyncUpResult, idx int, outcome channels.UpsertResult) {
+	item := &result.Results[idx]
+	if outcome.Err != nil {
+		item.Message = outcome.Err.Error()
+		result.Failed++
+		return
+	}
+
+	now := time.Now()
+	if item.Action == "create" {
+		mapping := models.ProductChannel{
+			ProductID:    item.ProductID,
+			ChannelID:    channelID,
+			ExternalID:   outcome.ExternalID,
+			LastSyncedAt: &now,
+		}
+		if err := db.DB.Create(&mapping).Error; err != nil {
+			item.Message = "Created in channel but failed to store its ID: " + err.Error()
+			result.Failed++
+			return
+		}
+		item.ExternalID = outcome.ExternalID
+		item.Success = true
+		if outcome.Existing {
+			item.Action = "update"
+			item.Message = "Matched an existing channel product by SKU"
+			result.Updated++
+			return
+		}
+		result.Created++
+		return
+	}
+
+	db.DB.Model(&models.ProductChannel{}).
+		Where("product_id = ? AND channel_id = ?", item.ProductID, channelID).
+		Update("last_synced_at", now)
+	item.Success = true
+	result.Updated++
+}
+
+// retrySyncUp pushes the products of the failed items again, using their
+// current data. The items are claimed (marked resolved) before the push so a
+// concurrent retry of the same run skips them, and released again for the
+// products whose push did not succeed.
+func retrySyncUp(m models.SalesChannel, ch channels.Channel, original models.SyncRun, failed []models.SyncRunItem) (models.SyncRun, error) {
+	var ids []uint
+	for _, item := range failed {
+		if item.ProductID != nil {
+			ids = append(ids, item.ID)
+		}
+	}
+	var claimed []models.SyncRunItem
+	if len(ids) > 0 {
+		err := db.DB.Raw(`UPDATE sync_run_items SET resolved = true
+			WHERE id IN ? AND resolved = false RETURNING *`, ids).Scan(&claimed).Error
+		if err != nil {
+			return models.SyncRun{}, err
+		}
+	}
+	var productIDs []uuid.UUID
+	for _, item := range claimed {
+		productIDs = append(productIDs, *item.ProductID)
+	}
+	var products []models.Product
+	if len(productIDs) > 0 {
+		db.DB.Where("id IN ?", productIDs).Find(&products)
+	}
+
+	result, err := RunSyncUp(m, ch, products, false, &original.ID)
+	pushed := make(map[uuid.UUID]bool)
+	for _, item := range result.Results {
+		if item.Success && item.Action != "skip" {
+			pushed[item.ProductID] = true
+		}
+	}
+	for _, item := range claimed {
+		if !pushed[*item.ProductID] {
+			db.DB.Model(&item).Update("resolved", false)
+		}
+	}
+
+	var run models.SyncRun
+	db.DB.First(&run, result.RunID)
+	return run, err
+}
+
+// productData builds the channel payload for a product
+func productData(p models.Product, externalID string) channels.ProductData {
+	return channels.ProductData{
+		ExternalID: externalID,
+		SKU:        p.SKU,
+		Title:      p.Title,
+		Price:      p.Price,
+		Stock:      publishableStock(p),
+		ImageURL:   publicImageURL(p.ImagePath),
+		Publish:    p.Status == models.StatusPublished,
+	}
+}
+
+// publishableStock is on hand minus reserved, never negative
+func publishableStock(p models.Product) int {
+	if available := p.Available(); available > 0 {
+		return available
+	}
+	return 0
+}
+
+// publicImageURL maps "/media/..." to the externally reachable PUBLIC_MEDIA_URL,
+// since the storefront has to download the cropped image itself.
+func publicImageURL(imagePath string) string {
+	base := strings.TrimRight(os.Getenv("PUBLIC_MEDIA_URL"), "/")
+	if base == "" || imagePath == "" {
+		return ""
+	}
+	return base + strings.TrimPrefix(imagePath, "/media")
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"backroom/internal/channels"
+	"backroom/internal/models"
+	"backroom/internal/woo"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeWoo serves GET /wp-json/wc/v3/orders from an in-memory list, applying
+// modified_after and status the way WooCommerce does
+type fakeWoo struct {
+	mu     sync.Mutex
+	orders []woo.Order
+}
+
+func (f *fakeWoo) set(order woo.Order) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.orders {
+		if f.orders[i].ID == order.ID {
+			f.orders[i] = order
+			return
+		}
+	}
+	f.orders = append(f.orders, order)
+}
+
+func (f *fakeWoo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/wp-json/wc/v3/orders" {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	after, err := time.ParseInLocation("2006-01-02T15:04:05", q.Get("modified_after"), time.UTC)
+	if err != nil {
+		http.Error(w, `{"code":"rest_invalid_param","message":"modified_after"}`, http.StatusBadRequest)
+		return
+	}
+	statuses := strings.Split(q.Get("status"), ",")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := []woo.Order{}
+	for _, o := range f.orders {
+		modified, _ := o.ModifiedAt()
+		if !modified.After(after) {
+			continue
+		}
+		for _, s := range statuses {
+			if s == o.Status {
+				list = append(list, o)
+				break
+			}
+		}
+	}
+	w.Header().Set("X-WP-TotalPages", "1")
+	json.NewEncoder(w).Encode(list)
+}
+
+func wooTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05")
+}
+
+// TestRunSyncDownOverlap checks that orders sharing the high-water mark's
+// second, or turned into sales after their creation time, are applied on
+// the next run, and that orders seen again are not applied twice
+func TestRunSyncDownOverlap(t *testing.T) {
+	conn := testDB(t)
+	product := testProduct(t, conn, 10)
+
+	fake := &fakeWoo{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	m := models.SalesChannel{
+		Name:    "Woo test " + uuid.NewString()[:8],
+		Kind:    models.ChannelKindWooCommerce,
+		BaseURL: srv.URL,
+		APIKey:  "ck",
+		Enabled: true,
+	}
+	if err := conn.Create(&m).Error; err != nil {
+		t.Fatalf("create channel: %v", err)
+	}
+	ch := channels.NewWooCommerce(srv.URL, "ck", "cs")
+
+	base := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	line := func(qty int) []woo.LineItem {
+		return []woo.LineItem{{SKU: product.SKU, Quantity: qty}}
+	}
+	run := func() SyncDownResult {
+		t.Helper()
+		result, err := RunSyncDown(conn, m, ch)
+		if err != nil {
+			t.Fatalf("sync down: %v", err)
+		}
+		return result
+	}
+
+	fake.set(woo.Order{ID: 1, Status: "processing", DateCreatedGMT: wooTime(base), DateModifiedGMT: wooTime(base), LineItems: line(1)})
+	fake.set(woo.Order{ID: 3, Status: "pending", DateCreatedGMT: wooTime(base.Add(-time.Minute)), LineItems: line(4)})
+	if r := run(); r.OrdersApplied != 1 || !r.HighWaterMark.Equal(base) {
+		t.Fatalf("first run: applied %d, mark %v", r.OrdersApplied, r.HighWaterMark)
+	}
+
+	// Created in the same second as the mark, and a pending order paid later
+	fake.set(woo.Order{ID: 2, Status: "processing", DateCreatedGMT: wooTime(base), DateModifiedGMT: wooTime(base), LineItems: line(2)})
+	fake.set(woo.Order{ID: 3, Status: "processing", DateCreatedGMT: wooTime(base.Add(-time.Minute)), DateModifiedGMT: wooTime(base.Add(time.Second)), LineItems: line(4)})
+	if r := run(); r.OrdersApplied != 2 || r.OrdersSkipped != 1 {
+		t.Fatalf("second run: applied %d, skipped %d; want 2 and 1", r.OrdersApplied, r.OrdersSkipped)
+	}
+
+	if r := run(); r.OrdersApplied != 0 {
+		t.Fatalf("third run applied %d orders again", r.OrdersApplied)
+	}
+
+	conn.First(&product, "id = ?", product.ID)
+	if product.StockOnHand != 3 {
+		t.Errorf("stock_on_hand = %d, want 3", product.StockOnHand)
+	}
+	if sold := ledgerTotal(t, conn, product.ID, models.MovementSale); sold != -7 {
+		t.Errorf("sale movements = %d, want -7", sold)
+	}
+}
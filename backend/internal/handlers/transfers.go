@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"backroom/internal/db"
+	"backroom/internal/inventory"
+	"backroom/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errTransferStatus  = errors.New("transfer is not in the required status")
+	errNotOnTransfer   = errors.New("product is not on this transfer")
+	errOverReceive     = errors.New("received quantity would exceed the shipped quantity")
+	errNothingToShip   = errors.New("transfer has no quantities to ship")
+	errSameLocation    = errors.New("source and destination must differ")
+	errTransferNoLines = errors.New("transfer needs at least one line")
+	errShortStock      = errors.New("not enough stock in the source location")
+)
+
+// transferError maps transfer errors to a status code and writes the response
+func transferError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Not Found: "+err.Error(), http.StatusNotFound)
+	case errors.Is(err, errTransferStatus), errors.Is(err, errOverReceive), errors.Is(err, errShortStock):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errNotOnTransfer), errors.Is(err, errNothingToShip),
+		errors.Is(err, errSameLocation), errors.Is(err, errTransferNoLines):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Transfer failed: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// lockTransfer loads a transfer with its lines, locked for the rest of tx
+func lockTransfer(tx *gorm.DB, id string) (models.Transfer, error) {
+	var t models.Transfer
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&t, id).Error
+	if err != nil {
+		return t, err
+	}
+	err = tx.Where("transfer_id = ?", t.ID).Order("sku").Find(&t.Lines).Error
+	return t, err
+}
+
+// GetTransfersHandler lists transfers, newest first; ?status= filters
+func GetTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	var list []models.Transfer
+	query := db.DB.Preload("Lines").Order("created_at desc")
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", strings.ToUpper(status))
+	}
+	if err := query.Find(&list).Error; err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetTransferHandler returns one transfer with its lines
+func GetTransferHandler(w http.ResponseWriter, r *http.Request) {
+	var t models.Transfer
+	if err := db.DB.Preload("Lines").First(&t, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Transfer not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(t)
+}
+
+// CreateTransferHandler creates a DRAFT transfer.
+// Body: {"from_location_id", "to_location_id", "note",
+// "lines": [{"code", "qty", "from_bin", "to_bin"}]}. A location ID that is
+// 0 or omitted means the default location.
+func CreateTransferHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		FromLocationID uint   `json:"from_location_id"`
+		ToLocationID   uint   `json:"to_location_id"`
+		Note           string `json:"note"`
+		Lines          []struct {
+			Code    string `json:"code"`
+			Qty     int    `json:"qty"`
+			FromBin string `json:"from_bin"`
+			ToBin   string `json:"to_bin"`
+		} `json:"lines"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	t := models.Transfer{
+		FromLocationID: payload.FromLocationID,
+		ToLocationID:   payload.ToLocationID,
+		Status:         models.TransferDraft,
+		Note:           payload.Note,
+		CreatedBy:      requestUser(r),
+	}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		// Resolve first: 0 means the default location
+		var err error
+		if t.FromLocationID, err = inventory.ResolveLocation(tx, &payload.FromLocationID); err != nil {
+			return err
+		}
+		if t.ToLocationID, err = inventory.ResolveLocation(tx, &payload.ToLocationID); err != nil {
+			return err
+		}
+		if t.FromLocationID == t.ToLocationID {
+			return errSameLocation
+		}
+		if len(payload.Lines) == 0 {
+			return errTransferNoLines
+		}
+
+		byProduct := make(map[string]int)
+		for _, l := range payload.Lines {
+			if l.Qty <= 0 {
+				return fmt.Errorf("%s: qty must be positive: %w", l.Code, errNothingToShip)
+			}
+			product, err := findProductByCode(tx, l.Code)
+			if err != nil {
+				return fmt.Errorf("product %s: %w", l.Code, err)
+			}
+			if i, ok := byProduct[product.ID.String()]; ok {
+				t.Lines[i].QtyRequested += l.Qty
+				continue
+			}
+			byProduct[product.ID.String()] = len(t.Lines)
+			t.Lines = append(t.Lines, models.TransferLine{
+				ProductID:    product.ID,
+				SKU:          product.SKU,
+				FromBin:      l.FromBin,
+				ToBin:        l.ToBin,
+				QtyRequested: l.Qty,
+			})
+		}
+		return tx.Create(&t).Error
+	})
+	if err != nil {
+		transferError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// TransferPickHandler records a scan at the source while the transfer is a
+// DRAFT. Body: {"code", "qty"} (qty defaults to 1, negative un-picks).
+// Products not on the transfer are added as unplanned lines.
+func TransferPickHandler(w http.ResponseWriter, r *http.Request) {
+	transferScan(w, r, models.TransferDraft)
+}
+
+// TransferReceiveHandler records a scan at the destination while the transfer
+// is IN_TRANSIT and puts the units into the destination location straight
+// away. Body: {"code", "qty", "bin"}.
+func TransferReceiveHandler(w http.ResponseWriter, r *http.Request) {
+	transferScan(w, r, models.TransferInTransit)
+}
+
+// transferScan is the shared pick/receive flow; status selects the side
+func transferScan(w http.ResponseWriter, r *http.Request, status models.TransferStatus) {
+	var payload struct {
+		Code string `json:"code"`
+		Qty  *int   `json:"qty"`
+		Bin  string `json:"bin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+	qty := 1
+	if payload.Qty != nil {
+		qty = *payload.Qty
+	}
+	if qty == 0 || (status == models.TransferInTransit && qty < 0) {
+		http.Error(w, "Invalid qty", http.StatusBadRequest)
+		return
+	}
+
+	product, err := findProductByCode(db.DB, payload.Code)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "not_found",
+			"message": "Product not found in inventory: " + payload.Code,
+		})
+		return
+	}
+
+	response := map[string]interface{}{"product": product}
+	var line models.TransferLine
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		t, err := lockTransfer(tx, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		if t.Status != status {
+			return fmt.Errorf("transfer is %s: %w", t.Status, errTransferStatus)
+		}
+
+		found := false
+		for _, l := range t.Lines {
+			if l.ProductID == product.ID {
+				line, found = l, true
+				break
+			}
+		}
+
+		if status == models.TransferDraft {
+			if !found {
+				line = models.TransferLine{TransferID: t.ID, ProductID: product.ID, SKU: product.SKU}
+				response["warning"] = "Item not requested on this transfer"
+			}
+			line.QtyPicked += qty
+			if line.QtyPicked < 0 {
+				line.QtyPicked = 0
+			}
+			if line.QtyPicked > line.QtyRequested {
+				response["warning"] = "Picked more than requested"
+			}
+			response["status"] = "picked"
+			return tx.Save(&line).Error
+		}
+
+		if !found || line.QtyPicked == 0 {
+			return errNotOnTransfer
+		}
+		if line.QtyReceived+qty > line.QtyPicked {
+			return errOverReceive
+		}
+		line.QtyReceived += qty
+		bin := payload.Bin
+		if bin == "" {
+			bin = line.ToBin
+		}
+		if _, err := inventory.Apply(tx, inventory.Change{
+			ProductID:  product.ID,
+			Delta:      qty,
+			LocationID: &t.ToLocationID,
+			Bin:        bin,
+			Reason:     models.MovementTransfer,
+			Ref:        inventory.Ref{Type: models.RefTransfer, ID: strconv.FormatUint(uint64(t.ID), 10)},
+			User:       requestUser(r),
+			Note:       "Transfer receive " + payload.Code,
+		}); err != nil {
+			return err
+		}
+		response["status"] = "received"
+		return tx.Save(&line).Error
+	})
+	if err != nil {
+		transferError(w, err)
+		return
+	}
+	response["line"] = line
+	json.NewEncoder(w).Encode(response)
+}
+
+// ShipTransferHandler takes the picked quantities out of the source location
+// and marks the transfer IN_TRANSIT. A transfer shipped without any pick
+// scans ships the requested quantities. Shipping more than the source
+// location/bin holds is refused with 409.
+func ShipTransferHandler(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	var t models.Transfer
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		t, err = lockTransfer(tx, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		if t.Status != models.TransferDraft {
+			return fmt.Errorf("transfer is %s: %w", t.Status, errTransferStatus)
+		}
+
+		picked := 0
+		for _, l := range t.Lines {
+			picked += l.QtyPicked
+		}
+		if picked == 0 {
+			for i := range t.Lines {
+				t.Lines[i].QtyPicked = t.Lines[i].QtyRequested
+			}
+		}
+
+		ref := inventory.Ref{Type: models.RefTransfer, ID: strconv.FormatUint(uint64(t.ID), 10)}
+		shipped := 0
+		for i, l := range t.Lines {
+			if l.QtyPicked == 0 {
+				continue
+			}
+			available, err := inventory.LockBinQty(tx, l.ProductID, &t.FromLocationID, l.FromBin)
+			if err != nil {
+				return err
+			}
+			if available < l.QtyPicked {
+				return fmt.Errorf("%s: %d in stock, shipping %d: %w", l.SKU, available, l.QtyPicked, errShortStock)
+			}
+			if _, err := inventory.Apply(tx, inventory.Change{
+				ProductID:  l.ProductID,
+				Delta:      -l.QtyPicked,
+				LocationID: &t.FromLocationID,
+				Bin:        l.FromBin,
+				Reason:     models.MovementTransfer,
+				Ref:        ref,
+				User:       user,
+				Note:       "Transfer ship",
+			}); err != nil {
+				return err
+			}
+			if err := tx.Model(&t.Lines[i]).Update("qty_picked", l.QtyPicked).Error; err != nil {
+				return err
+			}
+			shipped += l.QtyPicked
+		}
+		if shipped == 0 {
+			return errNothingToShip
+		}
+
+		now := time.Now()
+		t.Status = models.TransferInTransit
+		t.ShippedAt = &now
+		return tx.Model(&t).Updates(map[string]interface{}{"status": t.Status, "shipped_at": now}).Error
+	})
+	if err != nil {
+		transferError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(t)
+}
+
+// CompleteTransferHandler closes an IN_TRANSIT transfer as RECEIVED.
+// Units shipped but never received are reported as short; with
+// {"return_shortage": true} they are put back into the source location,
+// otherwise they stay out of stock as lost in transit.
+func CompleteTransferHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ReturnShortage bool `json:"return_shortage"`
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
+			return
+		}
+	}
+	user := requestUser(r)
+
+	type shortLine struct {
+		SKU string `json:"sku"`
+		Qty int    `json:"qty"`
+	}
+	var shortages []shortLine
+	var t models.Transfer
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		t, err = lockTransfer(tx, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+		if t.Status != models.TransferInTransit {
+			return fmt.Errorf("transfer is %s: %w", t.Status, errTransferStatus)
+		}
+
+		for _, l := range t.Lines {
+			short := l.QtyPicked - l.QtyReceived
+			if short <= 0 {
+				continue
+			}
+			shortages = append(shortages, shortLine{SKU: l.SKU, Qty: short})
+			if !payload.ReturnShortage {
+				continue
+			}
+			if _, err := inventory.Apply(tx, inventory.Change{
+				ProductID:  l.ProductID,
+				Delta:      short,
+				LocationID: &t.FromLocationID,
+				Bin:        l.FromBin,
+				Reason:     models.MovementTransfer,
+				Ref:        inventory.Ref{Type: models.RefTransfer, ID: strconv.FormatUint(uint64(t.ID), 10)},
+				User:       user,
+				Note:       "Transfer shortage returned to source",
+			}); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		t.Status = models.TransferReceived
+		t.ReceivedAt = &now
+		return tx.Model(&t).Updates(map[string]interface{}{"status": t.Status, "received_at": now}).Error
+	})
+	if err != nil {
+		transferError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"transfer":          t,
+		"shortages":         shortages,
+		"shortage_returned": payload.ReturnShortage,
+	})
+}
+
+// CancelTransferHandler cancels a DRAFT transfer; nothing has moved yet
+func CancelTransferHandler(w http.ResponseWriter, r *http.Request) {
+	res := db.DB.Model(&models.Transfer{}).
+		Where("id = ? AND status = ?", chi.URLParam(r, "id"), models.TransferDraft).
+		Update("status", models.TransferCancelled)
+	if res.Error != nil {
+		http.Error(w, "DB Error: "+res.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "Transfer not found or no longer a draft", http.StatusConflict)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": string(models.TransferCancelled)})
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"backroom/internal/channels"
+	"backroom/internal/db"
+	"backroom/internal/inventory"
+	"backroom/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ChannelWebhookHandler receives signed order events from a storefront and
+// reserves, releases or fulfils stock accordingly
+func ChannelWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var channel models.SalesChannel
+	if err := db.DB.First(&channel, id).Error; err != nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 5<<20)) // 5MB
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	if channel.WebhookSecret == "" {
+		http.Error(w, "Webhook secret not configured for this channel", http.StatusForbidden)
+		return
+	}
+	header, encoding := channels.SignatureSettings(channel)
+	if !channels.VerifySignature(channel.WebhookSecret, r.Header.Get(header), encoding, body) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	ch, err := channels.FromModel(channel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	parser, ok := ch.(channels.WebhookParser)
+	if !ok {
+		http.Error(w, "Channel does not accept webhooks", http.StatusNotImplemented)
+		return
+	}
+
+	event, ok, err := parser.ParseOrderEvent(r.Header, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ignored"})
+		return
+	}
+
+	var order models.ChannelOrder
+	var unmatched []string
+	var applied bool
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		var missing []channels.SaleLine
+		order, applied, missing, err = applyOrderEvent(tx, channel, event)
+		for _, line := range missing {
+			unmatched = append(unmatched, lineLabel(line))
+		}
+		return err
+	})
+	if err != nil {
+		log.Printf("Webhook Error (%s, order %s): %v", channel.Name, event.OrderRef, err)
+		http.Error(w, "Failed to apply order event: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := "applied"
+	switch {
+	case !applied:
+		status = "duplicate"
+	case order.Status == models.ChannelOrderCancelReview:
+		status = "flagged"
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         status,
+		"event":          event.Type,
+		"order":          order,
+		"unmatched_skus": unmatched,
+	})
+}
+
+// applyOrderEvent moves a channel order through RESERVED / RELEASED / FULFILLED.
+// A cancellation of a FULFILLED order moves it to CANCEL_REVIEW, to be
+// restocked or kept through ReviewChannelOrderHandler. applied is false when
+// the event does not change anything (redelivery, or an order already
+// handled by Sync Down).
+func applyOrderEvent(tx *gorm.DB, channel models.SalesChannel, event channels.OrderEvent) (order models.ChannelOrder, applied bool, unmatched []channels.SaleLine, err error) {
+	order, found, err := lockChannelOrder(tx, channel.ID, event.OrderRef)
+	if err != nil {
+		return order, false, nil, err
+	}
+	ref := inventory.Ref{Type: models.RefChannelOrder, ID: fmt.Sprintf("%d:%s", channel.ID, event.OrderRef)}
+
+	switch event.Type {
+	case channels.OrderCreated:
+		if found {
+			return order, false, nil, nil
+		}
+		lines, missing, err := resolveOrderLines(tx, channel.ID, event.Lines)
+		if err != nil {
+			return order, false, nil, err
+		}
+		if err := shiftStock(tx, lines, 0, 1, channel.LocationID, ref); err != nil {
+			return order, false, nil, err
+		}
+		return order, true, missing, saveChannelOrder(tx, &order, models.ChannelOrderReserved, lines)
+
+	case channels.OrderCancelled:
+		if found && order.Status == models.ChannelOrderFulfilled {
+			// Stock already left: flag it instead of silently ignoring the cancellation
+			return order, true, nil, saveChannelOrder(tx, &order, models.ChannelOrderCancelReview, orderLines(order))
+		}
+		if found && order.Status != models.ChannelOrderReserved {
+			return order, false, nil, nil
+		}
+		if found {
+			if err := shiftStock(tx, orderLines(order), 0, -1, channel.LocationID, ref); err != nil {
+				return order, false, nil, err
+			}
+		}
+		// Unknown orders are recorded as released so a late "created" does not reserve
+		return order, true, nil, saveChannelOrder(tx, &order, models.ChannelOrderReleased, orderLines(order))
+
+	case channels.OrderCompleted:
+		if found && order.Status.Sold() {
+			return order, false, nil, nil
+		}
+		missing, err := fulfilChannelOrder(tx, channel, &order, event.Lines, ref)
+		return order, true, missing, err
+	}
+	return order, false, nil, errors.New("unknown order event " + string(event.Type))
+}
+
+// fulfilChannelOrder takes a sold order out of StockOnHand, from the
+// channel's selling location. Reserved orders use the lines they reserved and
+// release them; others resolve lines afresh.
+func fulfilChannelOrder(tx *gorm.DB, channel models.SalesChannel, order *models.ChannelOrder, saleLines []channels.SaleLine, ref inventory.Ref) ([]channels.SaleLine, error) {
+	var missing []channels.SaleLine
+	lines := orderLines(*order)
+	if order.Status == models.ChannelOrderReserved {
+		if err := shiftStock(tx, lines, -1, -1, channel.LocationID, ref); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if lines, missing, err = resolveOrderLines(tx, channel.ID, saleLines); err != nil {
+			return nil, err
+		}
+		if err := shiftStock(tx, lines, -1, 0, channel.LocationID, ref); err != nil {
+			return nil, err
+		}
+	}
+	return missing, saveChannelOrder(tx, order, models.ChannelOrderFulfilled, lines)
+}
+
+// lockChannelOrder loads an order row FOR UPDATE; found is false when it does not exist yet
+func lockChannelOrder(tx *gorm.DB, channelID uint, ref string) (models.ChannelOrder, bool, error) {
+	order := models.ChannelOrder{ChannelID: channelID, ExternalRef: ref}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("channel_id = ? AND external_ref = ?", channelID, ref).First(&order).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return order, false, nil
+	}
+	return order, err == nil, err
+}
+
+func saveChannelOrder(tx *gorm.DB, order *models.ChannelOrder, status models.ChannelOrderStatus, lines []models.ChannelOrderLine) error {
+	if lines == nil {
+		lines = []models.ChannelOrderLine{}
+	}
+	data, err := json.Marshal(lines)
+	if err != nil {
+		return err
+	}
+	order.Status = status
+	order.Lines = data
+	return tx.Save(order).Error
+}
+
+func orderLines(order models.ChannelOrder) []models.ChannelOrderLine {
+	var lines []models.ChannelOrderLine
+	if len(order.Lines) > 0 {
+		json.Unmarshal(order.Lines, &lines)
+	}
+	return lines
+}
+
+// resolveOrderLines matches sale lines to local products and returns the ones that match nothing
+func resolveOrderLines(tx *gorm.DB, channelID uint, saleLines []channels.SaleLine) ([]models.ChannelOrderLine, []channels.SaleLine, error) {
+	var lines []models.ChannelOrderLine
+	var missing []channels.SaleLine
+	for _, line := range saleLines {
+		if line.Quantity <= 0 {
+			continue
+		}
+		product, err := findSaleProduct(tx, channelID, line.SKU, line.ExternalID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			missing = append(missing, line)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		lines = append(lines, models.ChannelOrderLine{ProductID: product.ID, SKU: product.SKU, Quantity: line.Quantity})
+	}
+	return lines, missing, nil
+}
+
+// shiftStock applies sign*qty of each line to stock_reserved and, through the
+// movement ledger, to stock_on_hand in locationID (nil for the default)
+func shiftStock(tx *gorm.DB, lines []models.ChannelOrderLine, onHandSign, reservedSign int, locationID *uint, ref inventory.Ref) error {
+	for _, line := range lines {
+		if reservedSign != 0 {
+			err := tx.Model(&models.Product{}).Where("id = ?", line.ProductID).
+				Update("stock_reserved", gorm.Expr("GREATEST(stock_reserved + ?, 0)", reservedSign*line.Quantity)).Error
+			if err != nil {
+				return err
+			}
+		}
+		if onHandSign != 0 {
+			_, err := inventory.Apply(tx, inventory.Change{
+				ProductID:  line.ProductID,
+				Delta:      onHandSign * line.Quantity,
+				LocationID: locationID,
+				Reason:     models.MovementSale,
+				Ref:        ref,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
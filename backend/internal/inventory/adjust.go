@@ -0,0 +1,130 @@
+package inventory
+
+import (
+	"backroom/internal/models"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AdjustmentCodes are the accepted reason codes for manual adjustments
+var AdjustmentCodes = []string{
+	models.AdjustDamaged,
+	models.AdjustLost,
+	models.AdjustFound,
+	models.AdjustSample,
+	models.AdjustCorrection,
+}
+
+// ErrAdjustmentSign is returned when a reason code does not allow the
+// direction of an adjustment
+var ErrAdjustmentSign = errors.New("adjustment direction not allowed")
+
+// reasonSigns is the direction each reason code allows: damaged, lost and
+// sample units can only leave stock and found units only enter it.
+// Corrections may go either way.
+var reasonSigns = map[string]int{
+	models.AdjustDamaged: -1,
+	models.AdjustLost:    -1,
+	models.AdjustSample:  -1,
+	models.AdjustFound:   1,
+}
+
+// checkSign rejects a delta that goes against its reason code
+func checkSign(reasonCode string, delta int) error {
+	sign := reasonSigns[reasonCode]
+	if sign == 0 || delta == 0 || (delta > 0) == (sign > 0) {
+		return nil
+	}
+	if sign > 0 {
+		return fmt.Errorf("%w: %s can only add stock", ErrAdjustmentSign, reasonCode)
+	}
+	return fmt.Errorf("%w: %s can only remove stock", ErrAdjustmentSign, reasonCode)
+}
+
+// Adjustment is a manual stock correction. Exactly one of Delta (signed
+// quantity) or Target (absolute stock of the location/bin) must be set.
+type Adjustment struct {
+	ProductID  uuid.UUID
+	LocationID *uint // Nil means the default location
+	Bin        string
+	Delta      *int
+	Target     *int
+	ReasonCode string
+	Note       string
+	User       string
+	Ref        Ref
+}
+
+// Validate checks the quantity mode, the reason code and, for a delta, that
+// the reason allows its direction
+func (a *Adjustment) Validate() error {
+	if (a.Delta == nil) == (a.Target == nil) {
+		return errors.New("provide exactly one of delta or target")
+	}
+	if a.Target != nil && *a.Target < 0 {
+		return errors.New("target cannot be negative")
+	}
+	a.ReasonCode = strings.ToLower(strings.TrimSpace(a.ReasonCode))
+	for _, code := range AdjustmentCodes {
+		if a.ReasonCode == code {
+			if a.Delta != nil {
+				return checkSign(a.ReasonCode, *a.Delta)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("reason_code must be one of %s", strings.Join(AdjustmentCodes, ", "))
+}
+
+// Adjust applies a manual adjustment. For an absolute target the product row
+// is locked first so the delta is computed against the committed balance,
+// and that delta must also go in the direction the reason code allows.
+// A zero delta records nothing and returns a zero movement.
+func Adjust(tx *gorm.DB, a Adjustment) (models.InventoryMovement, error) {
+	if err := a.Validate(); err != nil {
+		return models.InventoryMovement{}, err
+	}
+
+	var delta int
+	if a.Delta != nil {
+		delta = *a.Delta
+	} else {
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").First(&product, "id = ?", a.ProductID).Error; err != nil {
+			return models.InventoryMovement{}, err
+		}
+		locationID, err := ResolveLocation(tx, a.LocationID)
+		if err != nil {
+			return models.InventoryMovement{}, err
+		}
+		current, err := BinQty(tx, a.ProductID, locationID, a.Bin)
+		if err != nil {
+			return models.InventoryMovement{}, err
+		}
+		delta = *a.Target - current
+		if err := checkSign(a.ReasonCode, delta); err != nil {
+			return models.InventoryMovement{}, err
+		}
+	}
+	if delta == 0 {
+		return models.InventoryMovement{}, nil
+	}
+
+	return Apply(tx, Change{
+		ProductID:  a.ProductID,
+		Delta:      delta,
+		LocationID: a.LocationID,
+		Bin:        a.Bin,
+		Reason:     models.MovementAdjustment,
+		ReasonCode: a.ReasonCode,
+		Ref:        a.Ref,
+		User:       a.User,
+		Note:       a.Note,
+	})
+}
@@ -0,0 +1,43 @@
+package inventory
+
+import (
+	"backroom/internal/models"
+	"errors"
+	"testing"
+)
+
+// TestAdjustmentValidate checks the quantity mode, reason codes and the
+// direction each reason allows
+func TestAdjustmentValidate(t *testing.T) {
+	n := func(v int) *int { return &v }
+	tests := []struct {
+		name string
+		adj  Adjustment
+		ok   bool
+		sign bool // Rejected for its direction
+	}{
+		{"delta", Adjustment{Delta: n(-2), ReasonCode: models.AdjustDamaged}, true, false},
+		{"target", Adjustment{Target: n(10), ReasonCode: models.AdjustCorrection}, true, false},
+		{"target zero", Adjustment{Target: n(0), ReasonCode: models.AdjustLost}, true, false},
+		{"reason code case and spaces", Adjustment{Delta: n(3), ReasonCode: " Found "}, true, false},
+		{"neither", Adjustment{ReasonCode: models.AdjustCorrection}, false, false},
+		{"both", Adjustment{Delta: n(1), Target: n(1), ReasonCode: models.AdjustCorrection}, false, false},
+		{"negative target", Adjustment{Target: n(-1), ReasonCode: models.AdjustCorrection}, false, false},
+		{"unknown reason", Adjustment{Delta: n(1), ReasonCode: "gift"}, false, false},
+		{"damaged adds", Adjustment{Delta: n(1), ReasonCode: models.AdjustDamaged}, false, true},
+		{"sample adds", Adjustment{Delta: n(1), ReasonCode: models.AdjustSample}, false, true},
+		{"found removes", Adjustment{Delta: n(-1), ReasonCode: models.AdjustFound}, false, true},
+		{"correction either way", Adjustment{Delta: n(-5), ReasonCode: models.AdjustCorrection}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.adj.Validate()
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if errors.Is(err, ErrAdjustmentSign) != tt.sign {
+				t.Errorf("err = %v, want a direction error %v", err, tt.sign)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package inventory
+
+import (
+	"backroom/internal/models"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Ref identifies the document that caused a stock change
+type Ref struct {
+	Type string // models.RefPurchaseOrder, models.RefSyncRun, ...
+	ID   string
+}
+
+// StockColumns are owned by Apply and the reservation updates. Saving a
+// whole product row must omit them, or it would overwrite concurrent
+// increments with the stale values it read.
+var StockColumns = []string{"stock_on_hand", "stock_reserved"}
+
+// Change describes a single stock mutation. A nil LocationID means the
+// default location.
+type Change struct {
+	ProductID  uuid.UUID
+	Delta      int
+	LocationID *uint
+	Bin        string
+	Reason     models.MovementReason
+	ReasonCode string
+	Ref        Ref
+	User       string
+	Note       string
+}
+
+// Apply adds Delta to the product's StockOnHand and to its location/bin
+// balance with atomic SQL increments, and records the movement in the same
+// transaction. Every stock change must go through here so the ledger,
+// StockOnHand and the balances stay reconciled.
+func Apply(tx *gorm.DB, c Change) (models.InventoryMovement, error) {
+	locationID, err := ResolveLocation(tx, c.LocationID)
+	if err != nil {
+		return models.InventoryMovement{}, err
+	}
+
+	var row struct {
+		SKU         string
+		StockOnHand int
+	}
+	res := tx.Raw(`UPDATE products SET stock_on_hand = stock_on_hand + ?, updated_at = ?
+		WHERE id = ? RETURNING sku, stock_on_hand`, c.Delta, time.Now(), c.ProductID).Scan(&row)
+	if res.Error != nil {
+		return models.InventoryMovement{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return models.InventoryMovement{}, fmt.Errorf("product %s: %w", c.ProductID, gorm.ErrRecordNotFound)
+	}
+	if err := addBalance(tx, c.ProductID, locationID, c.Bin, c.Delta); err != nil {
+		return models.InventoryMovement{}, err
+	}
+
+	movement := models.InventoryMovement{
+		ProductID:    c.ProductID,
+		SKU:          row.SKU,
+		Delta:        c.Delta,
+		BalanceAfter: row.StockOnHand,
+		LocationID:   locationID,
+		Bin:          c.Bin,
+		Reason:       c.Reason,
+		ReasonCode:   c.ReasonCode,
+		RefType:      c.Ref.Type,
+		RefID:        c.Ref.ID,
+		User:         c.User,
+		Note:         c.Note,
+	}
+	err = tx.Create(&movement).Error
+	return movement, err
+}
+
+// Discrepancy is a product whose StockOnHand differs from the sum of its movements
+type Discrepancy struct {
+	ProductID   uuid.UUID `json:"product_id"`
+	SKU         string    `json:"sku"`
+	StockOnHand int       `json:"stock_on_hand"`
+	LedgerTotal int       `json:"ledger_total"`
+	Difference  int       `json:"difference"` // StockOnHand - LedgerTotal
+}
+
+// Discrepancies compares StockOnHand against the movement ledger
+func Discrepancies(tx *gorm.DB) ([]Discrepancy, error) {
+	var list []Discrepancy
+	err := tx.Raw(`
+		SELECT p.id AS product_id, p.sku, p.stock_on_hand,
+			COALESCE(SUM(m.delta), 0) AS ledger_total,
+			p.stock_on_hand - COALESCE(SUM(m.delta), 0) AS difference
+		FROM products p
+		LEFT JOIN inventory_movements m ON m.product_id = p.id
+		GROUP BY p.id
+		HAVING p.stock_on_hand <> COALESCE(SUM(m.delta), 0)
+		ORDER BY p.sku`).Scan(&list).Error
+	return list, err
+}
+
+// Reconcile records an ADJUSTMENT movement for every discrepancy so the ledger
+// explains the current StockOnHand (used for opening balances and for stock
+// changed outside the ledger). StockOnHand itself is left untouched; the
+// part of it missing from the location balances, if any, is added to the
+// default location so the balances sum to it again. That gap is measured
+// rather than assumed to be the difference, since opening stock was already
+// moved into the default location by BootstrapLocations.
+func Reconcile(tx *gorm.DB, user, note string) ([]Discrepancy, error) {
+	list, err := Discrepancies(tx)
+	if err != nil {
+		return nil, err
+	}
+	locationID, err := ResolveLocation(tx, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range list {
+		movement := models.InventoryMovement{
+			ProductID:    d.ProductID,
+			SKU:          d.SKU,
+			Delta:        d.Difference,
+			BalanceAfter: d.StockOnHand,
+			LocationID:   locationID,
+			Reason:       models.MovementAdjustment,
+			User:         user,
+			Note:         note,
+		}
+		if err := tx.Create(&movement).Error; err != nil {
+			return nil, err
+		}
+
+		var balanced int
+		if err := tx.Model(&models.StockBalance{}).Select("COALESCE(SUM(qty), 0)").
+			Where("product_id = ?", d.ProductID).Scan(&balanced).Error; err != nil {
+			return nil, err
+		}
+		if gap := d.StockOnHand - balanced; gap != 0 {
+			if err := addBalance(tx, d.ProductID, locationID, "", gap); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return list, nil
+}
+
+// OpeningBalances records the stock that existed before the movement ledger,
+// one ADJUSTMENT per product. It only does so while the ledger is empty: later
+// drift between StockOnHand and the ledger is a bug to be reported by
+// Discrepancies, not something to paper over at startup.
+func OpeningBalances(tx *gorm.DB) ([]Discrepancy, error) {
+	var movements int64
+	if err := tx.Model(&models.InventoryMovement{}).Count(&movements).Error; err != nil {
+		return nil, err
+	}
+	if movements > 0 {
+		return nil, nil
+	}
+	return Reconcile(tx, "system", "Opening balance")
+}
@@ -0,0 +1,123 @@
+package inventory
+
+import (
+	"backroom/internal/models"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNoDefaultLocation is returned when stock moves without a location and
+// none is marked as default
+var ErrNoDefaultLocation = errors.New("no default location configured")
+
+// ResolveLocation returns id when it names an existing location, or the
+// default location when id is nil.
+func ResolveLocation(tx *gorm.DB, id *uint) (uint, error) {
+	var loc models.Location
+	if id == nil || *id == 0 {
+		if err := tx.Where("is_default = ?", true).First(&loc).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return 0, ErrNoDefaultLocation
+			}
+			return 0, err
+		}
+		return loc.ID, nil
+	}
+	if err := tx.Select("id").First(&loc, *id).Error; err != nil {
+		return 0, fmt.Errorf("location %d: %w", *id, err)
+	}
+	return loc.ID, nil
+}
+
+// LocationQty is the stock of a product in one location, summed across bins.
+// A nil location means the product total (StockOnHand).
+func LocationQty(tx *gorm.DB, productID uuid.UUID, locationID *uint) (int, error) {
+	var qty int
+	if locationID == nil {
+		err := tx.Model(&models.Product{}).Select("stock_on_hand").Where("id = ?", productID).Scan(&qty).Error
+		return qty, err
+	}
+	err := tx.Model(&models.StockBalance{}).Select("COALESCE(SUM(qty), 0)").
+		Where("product_id = ? AND location_id = ?", productID, *locationID).Scan(&qty).Error
+	return qty, err
+}
+
+// BinQty is the stock of a product in a single location/bin
+func BinQty(tx *gorm.DB, productID uuid.UUID, locationID uint, bin string) (int, error) {
+	var qty int
+	err := tx.Model(&models.StockBalance{}).Select("COALESCE(SUM(qty), 0)").
+		Where("product_id = ? AND location_id = ? AND bin = ?", productID, locationID, bin).Scan(&qty).Error
+	return qty, err
+}
+
+// LockBinQty locks the product row, holding off Apply in other transactions,
+// and returns its stock in a location/bin. Callers taking units out check
+// the result first, since Apply itself lets a balance go negative.
+func LockBinQty(tx *gorm.DB, productID uuid.UUID, locationID *uint, bin string) (int, error) {
+	var product models.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").First(&product, "id = ?", productID).Error; err != nil {
+		return 0, err
+	}
+	resolved, err := ResolveLocation(tx, locationID)
+	if err != nil {
+		return 0, err
+	}
+	return BinQty(tx, productID, resolved, bin)
+}
+
+// addBalance adds delta to a location/bin balance, creating it if needed
+func addBalance(tx *gorm.DB, productID uuid.UUID, locationID uint, bin string, delta int) error {
+	return tx.Exec(`INSERT INTO stock_balances (product_id, location_id, bin, qty, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (product_id, location_id, bin)
+		DO UPDATE SET qty = stock_balances.qty + EXCLUDED.qty, updated_at = EXCLUDED.updated_at`,
+		productID, locationID, bin, delta, time.Now()).Error
+}
+
+// BootstrapLocations makes sure a default location exists and, while no
+// balances exist yet, moves the existing single-number stock into it. After
+// that initial backfill a gap between StockOnHand and the balances is a bug
+// and is left alone. The default is named by DEFAULT_LOCATION_NAME,
+// "Backroom" if unset.
+func BootstrapLocations(tx *gorm.DB) (int64, error) {
+	var count int64
+	if err := tx.Model(&models.Location{}).Where("is_default = ?", true).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		name := os.Getenv("DEFAULT_LOCATION_NAME")
+		if name == "" {
+			name = "Backroom"
+		}
+		loc := models.Location{Name: name, Code: "DEFAULT", IsDefault: true}
+		if err := tx.Where(models.Location{Name: name}).Attrs(loc).FirstOrCreate(&loc).Error; err != nil {
+			return 0, err
+		}
+		if err := tx.Model(&loc).Update("is_default", true).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	defaultID, err := ResolveLocation(tx, nil)
+	if err != nil {
+		return 0, err
+	}
+	var balances int64
+	if err := tx.Model(&models.StockBalance{}).Count(&balances).Error; err != nil {
+		return 0, err
+	}
+	if balances > 0 {
+		return 0, nil
+	}
+	res := tx.Exec(`INSERT INTO stock_balances (product_id, location_id, bin, qty, updated_at)
+		SELECT id, ?, '', stock_on_hand, ? FROM products WHERE stock_on_hand <> 0`,
+		defaultID, time.Now())
+	return res.RowsAffected, res.Error
+}
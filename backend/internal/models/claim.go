@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// Discrepancy Types found when receiving a PO
+type DiscrepancyType string
+
+const (
+	DiscrepancyShort      DiscrepancyType = "SHORT"      // Received less than ordered
+	DiscrepancyOver       DiscrepancyType = "OVER"       // Received more than ordered
+	DiscrepancyUnexpected DiscrepancyType = "UNEXPECTED" // Received but not on the PO
+	DiscrepancyDamaged    DiscrepancyType = "DAMAGED"    // Received damaged
+)
+
+// Claim Status
+type ClaimStatus string
+
+const (
+	ClaimOpen     ClaimStatus = "OPEN"
+	ClaimResolved ClaimStatus = "RESOLVED" // Every line has a resolution
+)
+
+// Claim Resolutions
+type ClaimResolution string
+
+const (
+	ClaimCredit      ClaimResolution = "CREDIT"      // Supplier credited the amount
+	ClaimReplacement ClaimResolution = "REPLACEMENT" // Supplier sends the goods again
+	ClaimWrittenOff  ClaimResolution = "WRITTEN_OFF" // Loss absorbed
+)
+
+// SupplierClaim Table
+// A claim raised with a supplier for the discrepancies of one PO
+type SupplierClaim struct {
+	ID           uint        `gorm:"primaryKey" json:"id"`
+	POID         uint        `gorm:"index" json:"po_id"`
+	SupplierID   *uint       `gorm:"index" json:"supplier_id"`
+	SupplierName string      `json:"supplier_name"`
+	Status       ClaimStatus `gorm:"type:varchar(20);default:'OPEN';index" json:"status"`
+	Note         string      `json:"note"`
+	CreditTotal  float64     `json:"credit_total"` // Sum of line credits
+	CreatedBy    string      `json:"created_by"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+	ResolvedAt   *time.Time  `json:"resolved_at"`
+	Lines        []ClaimLine `gorm:"foreignKey:ClaimID" json:"lines,omitempty"`
+}
+
+// ClaimLine Table
+type ClaimLine struct {
+	ID           uint            `gorm:"primaryKey" json:"id"`
+	ClaimID      uint            `gorm:"index" json:"claim_id"`
+	Type         DiscrepancyType `gorm:"type:varchar(20)" json:"type"`
+	SKU          string          `json:"sku"`
+	Qty          int             `json:"qty"`
+	Resolution   ClaimResolution `gorm:"type:varchar(20)" json:"resolution"` // Empty while unresolved
+	CreditAmount float64         `json:"credit_amount"`
+	Note         string          `json:"note"`
+	ResolvedBy   string          `json:"resolved_by"`
+	ResolvedAt   *time.Time      `json:"resolved_at"`
+}
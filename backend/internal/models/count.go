@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Count Session Status
+type CountSessionStatus string
+
+const (
+	CountStatusOpen      CountSessionStatus = "OPEN"
+	CountStatusApproved  CountSessionStatus = "APPROVED"
+	CountStatusCancelled CountSessionStatus = "CANCELLED"
+)
+
+// CountSession Table
+// A partial stock count scoped by brand, supplier or an explicit SKU list.
+// With a LocationID only that location's stock is counted and corrected.
+type CountSession struct {
+	ID         uint               `gorm:"primaryKey" json:"id"`
+	Name       string             `json:"name"`
+	LocationID *uint              `json:"location_id"`
+	Brand      string             `json:"brand"`
+	SupplierID *uint              `json:"supplier_id"`
+	SKUs       JSONB              `gorm:"type:jsonb" json:"skus"` // Explicit scope, if any
+	Status     CountSessionStatus `gorm:"type:varchar(20);default:'OPEN'" json:"status"`
+	CreatedBy  string             `json:"created_by"`
+	ApprovedBy string             `json:"approved_by"`
+	CreatedAt  time.Time          `json:"created_at"`
+	ClosedAt   *time.Time         `json:"closed_at"` // Approved or cancelled
+	Lines      []CountLine        `gorm:"foreignKey:SessionID" json:"lines,omitempty"`
+}
+
+// CountLine Table
+// ExpectedQty is the stock (of the session location, if any) when the
+// session opened. QtyAtCount is the same figure when counting of the line
+// started, so movements that happen while the session is open are not
+// mistaken for variance on approval.
+type CountLine struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	SessionID   uint       `gorm:"uniqueIndex:idx_count_line" json:"session_id"`
+	ProductID   uuid.UUID  `gorm:"type:uuid;uniqueIndex:idx_count_line" json:"product_id"`
+	SKU         string     `json:"sku"`
+	Title       string     `json:"title"`
+	ExpectedQty int        `json:"expected_qty"`
+	Counted     *int       `json:"counted"` // Nil until scanned or keyed
+	QtyAtCount  int        `json:"qty_at_count"`
+	Unplanned   bool       `json:"unplanned"` // Found during the count, outside the scope
+	CountedBy   string     `json:"counted_by"`
+	CountedAt   *time.Time `json:"counted_at"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Location Table
+// A physical place stock is kept in: backroom, storefront, offsite unit...
+type Location struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"uniqueIndex" json:"name"`
+	Code      string    `gorm:"uniqueIndex" json:"code"` // Short code for labels and scanners
+	IsDefault bool      `json:"is_default"`              // Receives stock when no location is given
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StockBalance Table
+// Quantity of a product in one location/bin. Product.StockOnHand is the sum
+// of its balances; both are only changed through inventory.Apply.
+type StockBalance struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	ProductID  uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_stock_balance;index" json:"product_id"`
+	LocationID uint      `gorm:"uniqueIndex:idx_stock_balance" json:"location_id"`
+	Bin        string    `gorm:"uniqueIndex:idx_stock_balance;not null;default:''" json:"bin"`
+	Qty        int       `json:"qty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
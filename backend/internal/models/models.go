@@ -1,6 +1,7 @@
 package models
 
 import (
+	"math"
 	"time"
 
 	"github.com/google/uuid"
@@ -21,10 +22,38 @@ type POStatus string
 
 const (
 	POStatusPending   POStatus = "PENDING"
+	POStatusConfirmed POStatus = "CONFIRMED" // Confirmed with the supplier
 	POStatusInTransit POStatus = "IN_TRANSIT"
 	POStatusReceived  POStatus = "RECEIVED"
+	POStatusClosed    POStatus = "CLOSED" // Closed by hand, possibly short
+	POStatusCancelled POStatus = "CANCELLED"
 )
 
+// poTransitions lists the statuses each status may move to
+var poTransitions = map[POStatus][]POStatus{
+	POStatusPending:   {POStatusConfirmed, POStatusInTransit, POStatusReceived, POStatusClosed, POStatusCancelled},
+	POStatusConfirmed: {POStatusInTransit, POStatusReceived, POStatusClosed, POStatusCancelled},
+	POStatusInTransit: {POStatusReceived, POStatusClosed, POStatusCancelled},
+	POStatusReceived:  {POStatusPending, POStatusConfirmed, POStatusInTransit, POStatusClosed}, // Reopened when a receipt is reversed
+	POStatusClosed:    {POStatusPending, POStatusConfirmed, POStatusInTransit},
+	POStatusCancelled: {POStatusPending, POStatusConfirmed, POStatusInTransit},
+}
+
+// CanTransition reports whether a PO may move from s to next
+func (s POStatus) CanTransition(next POStatus) bool {
+	for _, allowed := range poTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Receivable reports whether goods can still be received against the PO
+func (s POStatus) Receivable() bool {
+	return s == POStatusPending || s == POStatusConfirmed || s == POStatusInTransit
+}
+
 // Product Table
 type Product struct {
 	ID                  uuid.UUID     `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
@@ -32,7 +61,6 @@ type Product struct {
 	Barcode             string        `gorm:"index" json:"barcode"`                            // Scannable code
 	SupplierID          *uint         `json:"supplier_id" gorm:"index"`                        // Link to Supplier
 	Supplier            *Supplier     `json:"supplier,omitempty" gorm:"foreignKey:SupplierID"` // Relation
-	WooID               *int          `json:"woo_id"`                                          // Nullable
 	StockOnHand         int           `gorm:"default:0" json:"stock_on_hand"`
 	StockReserved       int           `gorm:"default:0" json:"stock_reserved"`
 	ImagePath           string        `json:"image_path"`
@@ -48,15 +76,126 @@ type Product struct {
 	ImageRect           string        `json:"image_rect"`             // JSON [x, y, w, h]
 }
 
+// Available is the publishable stock: on hand minus reserved by storefront orders
+func (p Product) Available() int {
+	return p.StockOnHand - p.StockReserved
+}
+
 // PurchaseOrder Table
 type PurchaseOrder struct {
 	ID           uint      `gorm:"primaryKey" json:"id"`
-	SupplierName string    `json:"supplier_name"`
-	FileName     string    `json:"file_name"` // Added
+	SupplierID   *uint     `gorm:"index" json:"supplier_id"`
+	Supplier     *Supplier `gorm:"foreignKey:SupplierID" json:"supplier,omitempty"`
+	SupplierName string    `json:"supplier_name"` // Copy of Supplier.Name, kept in sync on rename
+	FileName     string    `json:"file_name"`     // Added
 	Status       POStatus  `gorm:"type:varchar(20);default:'PENDING'" json:"status"`
+	Revision     int       `gorm:"default:1" json:"revision"` // Bumped by every overwrite or line edit
+	Currency     string    `gorm:"size:3" json:"currency"`    // Default for lines without one
+	Notes        string    `json:"notes"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	Items        []POItem  `gorm:"foreignKey:POID" json:"items"`
+	Totals       []POTotal `gorm:"-" json:"totals,omitempty"` // Set by ComputeTotals
+
+	ExpectedDelivery *time.Time `json:"expected_delivery"`
+
+	// Last time the PO entered each status
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+	ShippedAt   *time.Time `json:"shipped_at"`
+	ReceivedAt  *time.Time `json:"received_at"`
+	ClosedAt    *time.Time `json:"closed_at"`
+	CancelledAt *time.Time `json:"cancelled_at"`
+	ReopenedAt  *time.Time `json:"reopened_at"`
+}
+
+// POStatusChange Table
+// History of every status transition of a PO
+type POStatusChange struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	POID      uint      `gorm:"index" json:"po_id"`
+	From      POStatus  `gorm:"type:varchar(20)" json:"from"`
+	To        POStatus  `gorm:"type:varchar(20)" json:"to"`
+	User      string    `json:"user"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PORevision Table
+// The lines of a PO as of one revision, and how they changed from the
+// previous one. Revision 1 is the PO as first created, except for POs from
+// before revisions were saved at creation: theirs is the lines as found at
+// the first change, and says so in Note.
+type PORevision struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	POID      uint      `gorm:"uniqueIndex:idx_po_revision" json:"po_id"`
+	Revision  int       `gorm:"uniqueIndex:idx_po_revision" json:"revision"`
+	FileName  string    `json:"file_name"`
+	User      string    `json:"user"`
+	Note      string    `json:"note"`
+	Lines     JSONB     `gorm:"type:jsonb" json:"lines"`   // []PORevisionLine
+	Changes   JSONB     `gorm:"type:jsonb" json:"changes"` // []POLineChange
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PORevisionLine is a PO line as stored in a revision
+type PORevisionLine struct {
+	SKU         string  `json:"sku"`
+	QtyOrdered  int     `json:"qty_ordered"`
+	QtyReceived int     `json:"qty_received"`
+	UnitCost    float64 `json:"unit_cost"`
+	DiscountPct float64 `json:"discount_pct"`
+	TaxRate     float64 `json:"tax_rate"`
+	Currency    string  `json:"currency"`
+}
+
+type POLineChangeType string
+
+const (
+	POLineAdded   POLineChangeType = "ADDED"
+	POLineRemoved POLineChangeType = "REMOVED"
+	POLineChanged POLineChangeType = "CHANGED" // Quantity or cost
+	POLineKept    POLineChangeType = "KEPT"    // Missing from the new file but kept for its received qty
+)
+
+// POLineChange is one line of the diff between two revisions
+type POLineChange struct {
+	SKU         string           `json:"sku"`
+	Type        POLineChangeType `json:"type"`
+	QtyBefore   int              `json:"qty_before"`
+	QtyAfter    int              `json:"qty_after"`
+	QtyReceived int              `json:"qty_received"`
+	CostBefore  float64          `json:"unit_cost_before"`
+	CostAfter   float64          `json:"unit_cost_after"`
+}
+
+// POShortage Table
+// Quantity still missing on an item when its PO was closed short
+type POShortage struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	POID        uint      `gorm:"index" json:"po_id"`
+	POItemID    uint      `json:"po_item_id"`
+	SKU         string    `json:"sku"`
+	QtyOrdered  int       `json:"qty_ordered"`
+	QtyReceived int       `json:"qty_received"`
+	QtyShort    int       `json:"qty_short"`
+	User        string    `json:"user"`
+	Voided      bool      `json:"voided"` // The PO was reopened after closing
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// POOverfill Table
+// Quantity received beyond what was ordered on an item when its PO was closed
+type POOverfill struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	POID        uint      `gorm:"index" json:"po_id"`
+	POItemID    uint      `json:"po_item_id"`
+	SKU         string    `json:"sku"`
+	QtyOrdered  int       `json:"qty_ordered"`
+	QtyReceived int       `json:"qty_received"`
+	QtyOver     int       `json:"qty_over"`
+	User        string    `json:"user"`
+	Voided      bool      `json:"voided"` // The PO was reopened after closing
+	CreatedAt   time.Time `json:"created_at"`
 }
 
 // PO Item Status
@@ -78,6 +217,126 @@ type POItem struct {
 	QtyOrdered  int          `json:"qty_ordered"`
 	QtyReceived int          `json:"qty_received"`
 	Status      POItemStatus `gorm:"type:varchar(20);default:'PENDING'" json:"status"`
+	UnitCost    float64      `json:"unit_cost"`
+	DiscountPct float64      `json:"discount_pct"` // 0-100, off the unit cost
+	TaxRate     float64      `json:"tax_rate"`     // Percent, on the discounted amount
+	Currency    string       `gorm:"size:3" json:"currency"`
+}
+
+// NetUnitCost is the unit cost after discount, before tax
+func (i POItem) NetUnitCost() float64 {
+	return i.UnitCost * (1 - i.DiscountPct/100)
+}
+
+// POTotal sums the lines of a PO that share a currency
+type POTotal struct {
+	Currency      string  `json:"currency"`
+	Subtotal      float64 `json:"subtotal"` // Qty ordered x unit cost
+	Discount      float64 `json:"discount"`
+	Tax           float64 `json:"tax"`
+	Total         float64 `json:"total"`          // Subtotal - discount + tax
+	ReceivedValue float64 `json:"received_value"` // Qty received x net unit cost
+}
+
+// ComputeTotals fills Totals from the loaded Items, one entry per currency
+func (po *PurchaseOrder) ComputeTotals() {
+	po.Totals = []POTotal{}
+	index := make(map[string]int)
+	for _, item := range po.Items {
+		currency := item.Currency
+		if currency == "" {
+			currency = po.Currency
+		}
+		i, ok := index[currency]
+		if !ok {
+			i = len(po.Totals)
+			index[currency] = i
+			po.Totals = append(po.Totals, POTotal{Currency: currency})
+		}
+		gross := float64(item.QtyOrdered) * item.UnitCost
+		net := float64(item.QtyOrdered) * item.NetUnitCost()
+		t := &po.Totals[i]
+		t.Subtotal += gross
+		t.Discount += gross - net
+		t.Tax += net * item.TaxRate / 100
+		t.ReceivedValue += float64(item.QtyReceived) * item.NetUnitCost()
+	}
+	for i := range po.Totals {
+		t := &po.Totals[i]
+		t.Subtotal = roundCents(t.Subtotal)
+		t.Discount = roundCents(t.Discount)
+		t.Tax = roundCents(t.Tax)
+		t.Total = roundCents(t.Subtotal - t.Discount + t.Tax)
+		t.ReceivedValue = roundCents(t.ReceivedValue)
+	}
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// RecalculateStatus derives Status from the ordered and received quantities
+func (i *POItem) RecalculateStatus() {
+	switch {
+	case i.QtyReceived <= 0:
+		i.Status = POItemStatusPending
+	case i.QtyReceived < i.QtyOrdered:
+		i.Status = POItemStatusPartial
+	case i.QtyReceived == i.QtyOrdered:
+		i.Status = POItemStatusCompleted
+	default:
+		i.Status = POItemStatusOverfilled
+	}
+}
+
+// Movement Reasons
+type MovementReason string
+
+const (
+	MovementReceipt    MovementReason = "RECEIPT"
+	MovementSale       MovementReason = "SALE"
+	MovementAdjustment MovementReason = "ADJUSTMENT"
+	MovementCount      MovementReason = "COUNT"
+	MovementReturn     MovementReason = "RETURN"
+	MovementTransfer   MovementReason = "TRANSFER"
+)
+
+// Adjustment Reason Codes (required for manual ADJUSTMENT movements)
+const (
+	AdjustDamaged    = "damaged"
+	AdjustLost       = "lost"
+	AdjustFound      = "found"
+	AdjustSample     = "sample"
+	AdjustCorrection = "correction"
+)
+
+// Movement Reference Types (what caused the change)
+const (
+	RefPurchaseOrder = "PO"
+	RefSyncRun       = "SYNC_RUN"
+	RefChannelOrder  = "CHANNEL_ORDER"
+	RefUser          = "USER"
+	RefCountSession  = "COUNT_SESSION"
+	RefTransfer      = "TRANSFER"
+)
+
+// InventoryMovement Table
+// Append-only ledger: every change to Product.StockOnHand writes one row
+type InventoryMovement struct {
+	ID           uint           `gorm:"primaryKey" json:"id"`
+	ProductID    uuid.UUID      `gorm:"type:uuid;index" json:"product_id"`
+	SKU          string         `gorm:"index" json:"sku"`
+	Delta        int            `json:"delta"`
+	BalanceAfter int            `json:"balance_after"` // StockOnHand right after this movement
+	LocationID   uint           `gorm:"index" json:"location_id"`
+	Bin          string         `json:"bin"`
+	Reason       MovementReason `gorm:"type:varchar(20);index" json:"reason"`
+	ReasonCode   string         `gorm:"type:varchar(20)" json:"reason_code,omitempty"` // Manual adjustments: damaged, lost, ...
+	RefType      string         `gorm:"type:varchar(20)" json:"ref_type"`              // PO | SYNC_RUN | CHANNEL_ORDER | USER | COUNT_SESSION | TRANSFER
+	RefID        string         `json:"ref_id"`
+	User         string         `json:"user"`
+	Note         string         `json:"note"`
+	CreatedAt    time.Time      `gorm:"index" json:"created_at"`
 }
 
 // SourceFile Table
@@ -90,22 +349,212 @@ type SourceFile struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// Sales Channel Kinds
+type ChannelKind string
+
+const (
+	ChannelKindWooCommerce ChannelKind = "WOOCOMMERCE"
+	ChannelKindWebhook     ChannelKind = "WEBHOOK" // Generic CSV/JSON endpoint
+)
+
+// SalesChannel Table
+// A storefront or marketplace the Sync Hub pulls sales from and pushes stock to
+type SalesChannel struct {
+	ID         uint        `gorm:"primaryKey" json:"id"`
+	Name       string      `gorm:"uniqueIndex;not null" json:"name"`
+	Kind       ChannelKind `gorm:"type:varchar(20);not null" json:"kind"`
+	BaseURL    string      `json:"base_url"`
+	APIKey     string      `json:"api_key"`
+	APISecret  string      `json:"api_secret,omitempty"` // Blanked in API responses
+	Format     string      `json:"format"`               // Webhook channels: "json" | "csv"
+	LocationID *uint       `json:"location_id"`          // Sales leave (and returns enter) this location; default if nil
+	// Inbound order webhooks are verified with HMAC-SHA256 over the raw body
+	WebhookSecret     string    `json:"webhook_secret,omitempty"` // Blanked in API responses
+	SignatureHeader   string    `json:"signature_header"`         // Defaults per kind, e.g. X-WC-Webhook-Signature
+	SignatureEncoding string    `json:"signature_encoding"`       // "base64" | "hex"
+	Enabled           bool      `json:"enabled"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// ProductChannel Table
+// Maps a local product to its ID in a sales channel (replaces Product.WooID)
+type ProductChannel struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	ProductID    uuid.UUID  `gorm:"type:uuid;uniqueIndex:idx_product_channel" json:"product_id"`
+	ChannelID    uint       `gorm:"uniqueIndex:idx_product_channel;index:idx_channel_external" json:"channel_id"`
+	ExternalID   string     `gorm:"index:idx_channel_external" json:"external_id"`
+	LastSyncedAt *time.Time `json:"last_synced_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// Channel Order Status
+type ChannelOrderStatus string
+
+const (
+	ChannelOrderReserved  ChannelOrderStatus = "RESERVED"  // Placed; units held in StockReserved
+	ChannelOrderReleased  ChannelOrderStatus = "RELEASED"  // Cancelled; reservation returned
+	ChannelOrderFulfilled ChannelOrderStatus = "FULFILLED" // Completed or synced down; StockOnHand decremented
+
+	// A cancellation that arrives after fulfilment is held for review, since
+	// the units may or may not have come back
+	ChannelOrderCancelReview ChannelOrderStatus = "CANCEL_REVIEW"
+	ChannelOrderReturned     ChannelOrderStatus = "RETURNED"    // Reviewed; units put back into StockOnHand
+	ChannelOrderCancelKept   ChannelOrderStatus = "CANCEL_KEPT" // Reviewed; units stay sold
+)
+
+// Sold reports whether the order's units already left StockOnHand, so it
+// must not be fulfilled again
+func (s ChannelOrderStatus) Sold() bool {
+	switch s {
+	case ChannelOrderFulfilled, ChannelOrderCancelReview, ChannelOrderReturned, ChannelOrderCancelKept:
+		return true
+	}
+	return false
+}
+
+// ChannelOrder Table
+// Tracks each storefront order so webhooks and Sync Down apply it exactly once
+type ChannelOrder struct {
+	ID          uint               `gorm:"primaryKey" json:"id"`
+	ChannelID   uint               `gorm:"uniqueIndex:idx_channel_order" json:"channel_id"`
+	ExternalRef string             `gorm:"uniqueIndex:idx_channel_order" json:"external_ref"`
+	Status      ChannelOrderStatus `gorm:"type:varchar(20)" json:"status"`
+	Lines       JSONB              `gorm:"type:jsonb" json:"lines"` // []ChannelOrderLine as applied
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+// ChannelOrderLine is a resolved line of a ChannelOrder (not stored directly)
+type ChannelOrderLine struct {
+	ProductID uuid.UUID `json:"product_id"`
+	SKU       string    `json:"sku"`
+	Quantity  int       `json:"quantity"`
+}
+
+// SyncState Table
+// Stores the high-water marks of the storefront sync, one row per direction
+type SyncState struct {
+	Key           string    `gorm:"primaryKey" json:"key"` // e.g. "channel:1:down"
+	HighWaterMark time.Time `json:"high_water_mark"`       // Newest remote record already applied
+	LastSyncedAt  time.Time `json:"last_synced_at"`        // When the sync last finished
+}
+
+// Sync Run Enums
+type SyncDirection string
+
+const (
+	SyncDirectionDown SyncDirection = "DOWN" // Sales pulled from the storefront
+	SyncDirectionUp   SyncDirection = "UP"   // Products pushed to the storefront
+)
+
+type SyncRunStatus string
+
+const (
+	SyncRunRunning SyncRunStatus = "RUNNING"
+	SyncRunSuccess SyncRunStatus = "SUCCESS"
+	SyncRunPartial SyncRunStatus = "PARTIAL" // Finished, but some items failed
+	SyncRunFailed  SyncRunStatus = "FAILED"  // Aborted by an error
+)
+
+// SyncRun Table
+// One row per Sync Down / Sync Up execution
+type SyncRun struct {
+	ID             uint          `gorm:"primaryKey" json:"id"`
+	ChannelID      uint          `gorm:"index" json:"channel_id"`
+	Direction      SyncDirection `gorm:"type:varchar(10);index" json:"direction"`
+	Status         SyncRunStatus `gorm:"type:varchar(20);index" json:"status"`
+	RetryOfID      *uint         `json:"retry_of_id"` // Set when this run replays failed items of another run
+	StartedAt      time.Time     `json:"started_at"`
+	FinishedAt     *time.Time    `json:"finished_at"`
+	ItemsTotal     int           `json:"items_total"`
+	ItemsSucceeded int           `json:"items_succeeded"`
+	ItemsFailed    int           `json:"items_failed"`
+	Error          string        `json:"error"`
+	Items          []SyncRunItem `gorm:"foreignKey:RunID" json:"items,omitempty"`
+}
+
+// SyncRunItem Table
+// Outcome of a single SKU within a run
+type SyncRunItem struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	RunID      uint       `gorm:"index" json:"run_id"`
+	ProductID  *uuid.UUID `gorm:"type:uuid" json:"product_id"`
+	SKU        string     `gorm:"index" json:"sku"`
+	ExternalID string     `json:"external_id"` // Remote product ID, if known
+	Reference  string     `json:"reference"`   // Remote order ID for sales
+	Action     string     `json:"action"`      // sale | create | update
+	Quantity   int        `json:"quantity"`
+	Success    bool       `json:"success"`
+	Message    string     `json:"message"`
+	Resolved   bool       `json:"resolved"` // Failed item later applied by a retry
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
 func Migrate(db *gorm.DB) error {
 	// Enable UUID extension
 	db.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\";")
 
 	// Migrate tables in order to avoid dependency issues
+	if err := db.AutoMigrate(&Supplier{}); err != nil {
+		return err
+	}
 	if err := db.AutoMigrate(&Product{}); err != nil {
 		return err
 	}
+	linkSuppliers := db.Migrator().HasTable(&PurchaseOrder{}) && !db.Migrator().HasColumn(&PurchaseOrder{}, "SupplierID")
 	if err := db.AutoMigrate(&PurchaseOrder{}); err != nil {
 		return err
 	}
+	// Link POs created before supplier_id existed to the supplier of that
+	// name, once, when the column is added
+	if linkSuppliers {
+		if err := db.Exec(`
+			UPDATE purchase_orders po SET supplier_id = s.id
+			FROM suppliers s
+			WHERE po.supplier_id IS NULL AND s.deleted_at IS NULL
+			AND LOWER(TRIM(s.name)) = LOWER(TRIM(po.supplier_name))`).Error; err != nil {
+			return err
+		}
+	}
 	if err := db.AutoMigrate(&POItem{}); err != nil {
 		return err
 	}
+	if err := db.AutoMigrate(&POStatusChange{}, &POShortage{}, &POOverfill{}, &PORevision{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&SupplierClaim{}, &ClaimLine{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&Location{}, &StockBalance{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&InventoryMovement{}); err != nil {
+		return err
+	}
 	if err := db.AutoMigrate(&SourceFile{}); err != nil {
 		return err
 	}
+	if err := db.AutoMigrate(&SalesChannel{}, &ProductChannel{}, &ChannelOrder{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&SyncState{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&SyncRun{}, &SyncRunItem{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&CountSession{}, &CountLine{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&Transfer{}, &TransferLine{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&ReceivingSession{}, &ReceivingLine{}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&ScanEvent{}); err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,95 @@
+package models
+
+import "testing"
+
+// TestComputeTotals checks discount and tax per line and that lines in
+// another currency get their own total
+func TestComputeTotals(t *testing.T) {
+	po := PurchaseOrder{
+		Currency: "MXN",
+		Items: []POItem{
+			{QtyOrdered: 3, QtyReceived: 2, UnitCost: 10, DiscountPct: 10, TaxRate: 16},
+			{QtyOrdered: 1, UnitCost: 0.335, Currency: "MXN"},
+			{QtyOrdered: 2, QtyReceived: 2, UnitCost: 5, Currency: "USD"},
+		},
+	}
+	po.ComputeTotals()
+	want := []POTotal{
+		{Currency: "MXN", Subtotal: 30.34, Discount: 3, Tax: 4.32, Total: 31.66, ReceivedValue: 18},
+		{Currency: "USD", Subtotal: 10, Total: 10, ReceivedValue: 10},
+	}
+	if len(po.Totals) != len(want) {
+		t.Fatalf("totals = %+v", po.Totals)
+	}
+	for i := range want {
+		if po.Totals[i] != want[i] {
+			t.Errorf("total %d = %+v, want %+v", i, po.Totals[i], want[i])
+		}
+	}
+
+	empty := PurchaseOrder{}
+	empty.ComputeTotals()
+	if empty.Totals == nil || len(empty.Totals) != 0 {
+		t.Errorf("totals of a PO without lines = %#v, want empty", empty.Totals)
+	}
+}
+
+// TestPOStatusTransitions checks the PO state machine: forward moves,
+// reopening, and that nothing leaves a status for itself or goes backwards
+// while the PO is still open
+func TestPOStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from, to POStatus
+		want     bool
+	}{
+		{POStatusPending, POStatusConfirmed, true},
+		{POStatusPending, POStatusReceived, true},
+		{POStatusConfirmed, POStatusInTransit, true},
+		{POStatusInTransit, POStatusConfirmed, false},
+		{POStatusConfirmed, POStatusPending, false},
+		{POStatusInTransit, POStatusCancelled, true},
+		{POStatusReceived, POStatusCancelled, false},
+		{POStatusReceived, POStatusInTransit, true},
+		{POStatusClosed, POStatusPending, true},
+		{POStatusClosed, POStatusReceived, false},
+		{POStatusCancelled, POStatusConfirmed, true},
+		{POStatusPending, POStatusPending, false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.CanTransition(tt.to); got != tt.want {
+			t.Errorf("%s -> %s allowed %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+
+	for _, s := range []POStatus{POStatusPending, POStatusConfirmed, POStatusInTransit} {
+		if !s.Receivable() {
+			t.Errorf("%s is not receivable", s)
+		}
+	}
+	for _, s := range []POStatus{POStatusReceived, POStatusClosed, POStatusCancelled} {
+		if s.Receivable() {
+			t.Errorf("%s is receivable", s)
+		}
+	}
+}
+
+// TestRecalculateStatus derives a line's status from its quantities
+func TestRecalculateStatus(t *testing.T) {
+	tests := []struct {
+		ordered, received int
+		want              POItemStatus
+	}{
+		{5, 0, POItemStatusPending},
+		{5, 3, POItemStatusPartial},
+		{5, 5, POItemStatusCompleted},
+		{5, 6, POItemStatusOverfilled},
+		{0, 1, POItemStatusOverfilled},
+	}
+	for _, tt := range tests {
+		item := POItem{QtyOrdered: tt.ordered, QtyReceived: tt.received}
+		item.RecalculateStatus()
+		if item.Status != tt.want {
+			t.Errorf("%d of %d received: %s, want %s", tt.received, tt.ordered, item.Status, tt.want)
+		}
+	}
+}
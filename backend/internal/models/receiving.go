@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Receiving Session Status
+type ReceivingStatus string
+
+const (
+	ReceivingOpen      ReceivingStatus = "OPEN"
+	ReceivingFinalized ReceivingStatus = "FINALIZED"
+	ReceivingDiscarded ReceivingStatus = "DISCARDED"
+)
+
+// ReceivingSession Table
+// Scans against a PO are staged here and only committed to POItem.QtyReceived
+// and StockOnHand when the operator finalizes the receipt. A PO has at most
+// one OPEN session, which can be resumed until finalized or discarded.
+type ReceivingSession struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	POID        uint            `gorm:"index" json:"po_id"`
+	LocationID  *uint           `json:"location_id"` // Receive into; default location if nil
+	Status      ReceivingStatus `gorm:"type:varchar(20);default:'OPEN';index" json:"status"`
+	CreatedBy   string          `json:"created_by"`
+	FinalizedBy string          `json:"finalized_by"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	ClosedAt    *time.Time      `json:"closed_at"` // Finalized or discarded
+	Lines       []ReceivingLine `gorm:"foreignKey:SessionID" json:"lines,omitempty"`
+}
+
+// ReceivingLine Table
+// Staged quantity of one product in a receiving session
+type ReceivingLine struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	SessionID uint      `gorm:"uniqueIndex:idx_receiving_line" json:"session_id"`
+	ProductID uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_receiving_line" json:"product_id"`
+	SKU       string    `json:"sku"`
+	Bin       string    `json:"bin"`
+	Qty       int       `json:"qty"`
+	OnPO      bool      `json:"on_po"` // False for items scanned but not ordered
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scan Event Kind
+type ScanEventKind string
+
+const (
+	ScanStaged     ScanEventKind = "STAGED"     // Added to a receiving session
+	ScanReceived   ScanEventKind = "RECEIVED"   // Ad-hoc receipt straight into stock
+	ScanCorrection ScanEventKind = "CORRECTION" // Staged line edited by hand; Qty is the change
+)
+
+// ScanEvent Table
+// One row per receiving scan, so a scan can be reversed exactly. ClientScanID
+// is generated on the device and makes retried submissions idempotent.
+type ScanEvent struct {
+	ID           uint          `gorm:"primaryKey" json:"id"`
+	ClientScanID *string       `gorm:"uniqueIndex" json:"client_scan_id"`
+	Code         string        `json:"code"` // As scanned
+	ProductID    uuid.UUID     `gorm:"type:uuid;index" json:"product_id"`
+	SKU          string        `json:"sku"`
+	Qty          int           `json:"qty"`
+	Kind         ScanEventKind `gorm:"type:varchar(20)" json:"kind"`
+	POID         *uint         `gorm:"index" json:"po_id"`
+	SessionID    *uint         `gorm:"index" json:"session_id"` // Receiving session for STAGED scans
+	LocationID   uint          `json:"location_id"`
+	Bin          string        `json:"bin"`
+	User         string        `json:"user"`
+	ScannedAt    *time.Time    `json:"scanned_at"` // Device time, for scans queued offline
+	CreatedAt    time.Time     `gorm:"index" json:"created_at"`
+	ReversedAt   *time.Time    `json:"reversed_at"`
+	ReversedBy   string        `json:"reversed_by"`
+}
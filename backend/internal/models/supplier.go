@@ -2,7 +2,9 @@ package models
 
 import (
 	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -56,6 +58,7 @@ type Supplier struct {
 	DeletedAt      gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
 	Name           string         `json:"name"`
 	Notes          string         `json:"notes"`
+	Currency       string         `gorm:"size:3" json:"currency"`            // Currency of its price lists and POs
 	Contacts       JSONB          `gorm:"type:jsonb" json:"contacts"`        // Array of contact objects
 	MappingConfig  JSONB          `gorm:"type:jsonb" json:"mapping_config"`  // Excel parsing rules
 	DetectedBrands JSONB          `gorm:"type:jsonb" json:"detected_brands"` // List of brands
@@ -68,12 +71,68 @@ type Contact struct {
 	Value string `json:"value"`
 }
 
+// MappingVersion is the MappingConfig format written now: -1 marks an
+// unmapped column. Version 1 (unset) used 0 for unmapped barcode and cost.
+const MappingVersion = 2
+
+// MappingConfig column indexes are 0-based; -1 means not mapped
 type MappingConfig struct {
-	HeaderRow  int `json:"header_row"`
-	ColSKU     int `json:"col_sku"`
-	ColTitle   int `json:"col_title"`
-	ColBarcode int `json:"col_barcode"`
-	ColQty     int `json:"col_qty"`
-	ColPrice   int `json:"col_price"`
-	ColBrand   int `json:"col_brand"`
+	Version    int    `json:"version,omitempty"`
+	Sheet      string `json:"sheet"` // Sheet name; empty = first sheet
+	HeaderRow  int    `json:"header_row"`
+	ColSKU     int    `json:"col_sku"`
+	ColTitle   int    `json:"col_title"`
+	ColBarcode int    `json:"col_barcode"`
+	ColQty     int    `json:"col_qty"`
+	ColPrice   int    `json:"col_price"`
+	ColBrand   int    `json:"col_brand"`
+	ColCost    int    `json:"col_cost"` // Unit cost on PO files
+
+	// Header text of each column by field (sku, title, barcode, qty, price,
+	// cost, brand). When set, columns are found by name and the indexes
+	// above are only a fallback.
+	Columns map[string]string `json:"columns,omitempty"`
+}
+
+// Upgrade converts a mapping of an older version to MappingVersion
+func (m *MappingConfig) Upgrade() {
+	if m.Version >= MappingVersion {
+		return
+	}
+	if m.ColBarcode == 0 {
+		m.ColBarcode = -1
+	}
+	if m.ColCost == 0 {
+		m.ColCost = -1
+	}
+	m.Version = MappingVersion
+}
+
+// UpgradeMappings rewrites stored supplier mappings of an older version, so
+// every consumer can treat -1, and only -1, as unmapped
+func UpgradeMappings(db *gorm.DB) (int, error) {
+	var suppliers []Supplier
+	if err := db.Unscoped().Where("mapping_config IS NOT NULL").Find(&suppliers).Error; err != nil {
+		return 0, err
+	}
+	upgraded := 0
+	for _, s := range suppliers {
+		if len(s.MappingConfig) == 0 || string(s.MappingConfig) == "null" {
+			continue
+		}
+		var m MappingConfig
+		if err := json.Unmarshal(s.MappingConfig, &m); err != nil {
+			return upgraded, fmt.Errorf("supplier %d: %w", s.ID, err)
+		}
+		if m.Version >= MappingVersion {
+			continue
+		}
+		m.Upgrade()
+		data, _ := json.Marshal(m)
+		if err := db.Unscoped().Model(&Supplier{}).Where("id = ?", s.ID).Update("mapping_config", JSONB(data)).Error; err != nil {
+			return upgraded, err
+		}
+		upgraded++
+	}
+	return upgraded, nil
 }
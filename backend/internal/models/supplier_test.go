@@ -0,0 +1,32 @@
+package models
+
+import "testing"
+
+// TestMappingConfigUpgrade checks that only old mappings have their 0
+// barcode and cost columns turned into -1
+func TestMappingConfigUpgrade(t *testing.T) {
+	tests := []struct {
+		name          string
+		in            MappingConfig
+		barcode, cost int
+	}{
+		{"old unmapped", MappingConfig{ColSKU: 0, ColQty: 1}, -1, -1},
+		{"old mapped", MappingConfig{ColBarcode: 4, ColCost: 5}, 4, 5},
+		{"current column 0", MappingConfig{Version: MappingVersion, ColBarcode: 0, ColCost: 0}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.in
+			m.Upgrade()
+			if m.ColBarcode != tt.barcode || m.ColCost != tt.cost {
+				t.Errorf("barcode, cost = %d, %d; want %d, %d", m.ColBarcode, m.ColCost, tt.barcode, tt.cost)
+			}
+			if m.Version != MappingVersion {
+				t.Errorf("version = %d, want %d", m.Version, MappingVersion)
+			}
+			if m.ColSKU != tt.in.ColSKU || m.ColQty != tt.in.ColQty {
+				t.Errorf("other columns changed: %+v", m)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Transfer Status
+type TransferStatus string
+
+const (
+	TransferDraft     TransferStatus = "DRAFT"      // Being picked at the source
+	TransferInTransit TransferStatus = "IN_TRANSIT" // Left the source, not yet received
+	TransferReceived  TransferStatus = "RECEIVED"
+	TransferCancelled TransferStatus = "CANCELLED"
+)
+
+// Transfer Table
+// Moves stock from one location to another. Shipping takes the picked
+// quantities out of the source; receiving scans put them into the destination.
+type Transfer struct {
+	ID             uint           `gorm:"primaryKey" json:"id"`
+	FromLocationID uint           `json:"from_location_id"`
+	ToLocationID   uint           `json:"to_location_id"`
+	Status         TransferStatus `gorm:"type:varchar(20);default:'DRAFT';index" json:"status"`
+	Note           string         `json:"note"`
+	CreatedBy      string         `json:"created_by"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	ShippedAt      *time.Time     `json:"shipped_at"`
+	ReceivedAt     *time.Time     `json:"received_at"`
+	Lines          []TransferLine `gorm:"foreignKey:TransferID" json:"lines,omitempty"`
+}
+
+// TransferLine Table
+type TransferLine struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	TransferID   uint      `gorm:"uniqueIndex:idx_transfer_line" json:"transfer_id"`
+	ProductID    uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_transfer_line" json:"product_id"`
+	SKU          string    `json:"sku"`
+	FromBin      string    `json:"from_bin"`
+	ToBin        string    `json:"to_bin"`
+	QtyRequested int       `json:"qty_requested"`
+	QtyPicked    int       `json:"qty_picked"`
+	QtyReceived  int       `json:"qty_received"`
+}
@@ -0,0 +1,234 @@
+package woo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to the WooCommerce REST API (wc/v3)
+type Client struct {
+	BaseURL        string // Store root, e.g. https://shop.example.com
+	ConsumerKey    string
+	ConsumerSecret string
+	HTTP           *http.Client
+}
+
+// NewClient builds a client for the given store. BaseURL may point at an
+// httptest server when exercising the sync flow locally.
+func NewClient(baseURL, key, secret string) *Client {
+	return &Client{
+		BaseURL:        strings.TrimRight(baseURL, "/"),
+		ConsumerKey:    key,
+		ConsumerSecret: secret,
+		HTTP:           &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// LineItem is a single product line inside a WooCommerce order
+type LineItem struct {
+	ID        int    `json:"id"`
+	ProductID int    `json:"product_id"`
+	SKU       string `json:"sku"`
+	Name      string `json:"name"`
+	Quantity  int    `json:"quantity"`
+}
+
+// Order is the subset of the WooCommerce order resource we use
+type Order struct {
+	ID              int        `json:"id"`
+	Status          string     `json:"status"`
+	DateCreatedGMT  string     `json:"date_created_gmt"`  // "2006-01-02T15:04:05", no zone
+	DateModifiedGMT string     `json:"date_modified_gmt"` // Same format; bumped by status changes
+	LineItems       []LineItem `json:"line_items"`
+}
+
+// CreatedAt parses DateCreatedGMT as UTC
+func (o Order) CreatedAt() (time.Time, error) {
+	return time.ParseInLocation("2006-01-02T15:04:05", o.DateCreatedGMT, time.UTC)
+}
+
+// ModifiedAt parses DateModifiedGMT as UTC, falling back to CreatedAt for
+// orders that were never modified
+func (o Order) ModifiedAt() (time.Time, error) {
+	if o.DateModifiedGMT == "" {
+		return o.CreatedAt()
+	}
+	return time.ParseInLocation("2006-01-02T15:04:05", o.DateModifiedGMT, time.UTC)
+}
+
+// SaleStatuses are the order statuses that represent stock leaving the store
+var SaleStatuses = []string{"processing", "on-hold", "completed"}
+
+// ListOrdersModifiedSince returns every sale order modified strictly after
+// `after`, least recently modified first, following pagination until
+// exhausted. Filtering on the modification date also returns orders that
+// were created earlier and only became a sale (e.g. pending -> processing)
+// later; callers must dedupe by order ID.
+func (c *Client) ListOrdersModifiedSince(after time.Time) ([]Order, error) {
+	var all []Order
+	for page := 1; ; page++ {
+		q := url.Values{}
+		q.Set("modified_after", after.UTC().Format("2006-01-02T15:04:05"))
+		q.Set("dates_are_gmt", "true")
+		q.Set("status", strings.Join(SaleStatuses, ","))
+		q.Set("orderby", "modified")
+		q.Set("order", "asc")
+		q.Set("per_page", "100")
+		q.Set("page", strconv.Itoa(page))
+
+		var orders []Order
+		resp, err := c.do(http.MethodGet, "/orders", q, nil, &orders)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, orders...)
+
+		totalPages, _ := strconv.Atoi(resp.Header.Get("X-WP-TotalPages"))
+		if len(orders) == 0 || page >= totalPages {
+			break
+		}
+	}
+	return all, nil
+}
+
+// APIError is the error envelope returned by the WooCommerce REST API
+type APIError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("woocommerce: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// do performs an authenticated request against /wp-json/wc/v3 and decodes the JSON response into out
+func (c *Client) do(method, path string, query url.Values, body io.Reader, out interface{}) (*http.Response, error) {
+	u := c.BaseURL + "/wp-json/wc/v3" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.ConsumerKey, c.ConsumerSecret)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		return resp, apiErr
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("woocommerce: decoding %s: %w", path, err)
+		}
+	}
+	return resp, nil
+}
+
+// Image references a remote image WooCommerce should sideload
+type Image struct {
+	Src string `json:"src"`
+}
+
+// ProductInput is the payload for creating or updating a product. Pointer and
+// omitempty fields let updates carry only the values that should change.
+type ProductInput struct {
+	ID            int     `json:"id,omitempty"`
+	Name          string  `json:"name,omitempty"`
+	Type          string  `json:"type,omitempty"`
+	Status        string  `json:"status,omitempty"` // draft | publish
+	SKU           string  `json:"sku,omitempty"`
+	RegularPrice  string  `json:"regular_price,omitempty"`
+	ManageStock   *bool   `json:"manage_stock,omitempty"`
+	StockQuantity *int    `json:"stock_quantity,omitempty"`
+	StockStatus   string  `json:"stock_status,omitempty"` // instock | outofstock
+	Images        []Image `json:"images,omitempty"`
+}
+
+// BatchRequest is the body of POST /products/batch
+type BatchRequest struct {
+	Create []ProductInput `json:"create,omitempty"`
+	Update []ProductInput `json:"update,omitempty"`
+}
+
+// BatchItemResult is one entry of a batch response; Error is set when that item failed
+type BatchItemResult struct {
+	ID    int       `json:"id"`
+	SKU   string    `json:"sku"`
+	Error *APIError `json:"error,omitempty"`
+}
+
+// BatchResponse mirrors BatchRequest, in the same order
+type BatchResponse struct {
+	Create []BatchItemResult `json:"create"`
+	Update []BatchItemResult `json:"update"`
+}
+
+// MaxBatchSize is the WooCommerce limit of objects per batch call
+const MaxBatchSize = 100
+
+// BatchProducts creates and updates products in a single call
+func (c *Client) BatchProducts(batch BatchRequest) (BatchResponse, error) {
+	var out BatchResponse
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return out, err
+	}
+	_, err = c.do(http.MethodPost, "/products/batch", nil, bytes.NewReader(body), &out)
+	return out, err
+}
+
+// FindProductIDsBySKU returns the IDs of the store's products with the given
+// SKUs, keyed by SKU. At most MaxBatchSize SKUs can be looked up per call.
+func (c *Client) FindProductIDsBySKU(skus []string) (map[string]int, error) {
+	found := make(map[string]int, len(skus))
+	if len(skus) == 0 {
+		return found, nil
+	}
+	q := url.Values{}
+	q.Set("sku", strings.Join(skus, ","))
+	q.Set("per_page", strconv.Itoa(MaxBatchSize))
+
+	var products []BatchItemResult
+	if _, err := c.do(http.MethodGet, "/products", q, nil, &products); err != nil {
+		return nil, err
+	}
+	for _, p := range products {
+		if p.SKU != "" && p.ID > 0 {
+			found[p.SKU] = p.ID
+		}
+	}
+	return found, nil
+}
+
+// UpdateProduct changes an existing product; only the non-empty fields of in are sent
+func (c *Client) UpdateProduct(id int, in ProductInput) (BatchItemResult, error) {
+	var out BatchItemResult
+	body, err := json.Marshal(in)
+	if err != nil {
+		return out, err
+	}
+	_, err = c.do(http.MethodPut, "/products/"+strconv.Itoa(id), nil, bytes.NewReader(body), &out)
+	return out, err
+}
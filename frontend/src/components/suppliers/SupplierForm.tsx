@@ -7,6 +7,7 @@ interface SupplierFormProps {
 }
 
 interface MappingConfig {
+    sheet?: string;
     header_row: number;
     col_sku: number;
     col_title: number;
@@ -14,17 +15,29 @@ interface MappingConfig {
     col_qty: number;
     col_price: number;
     col_brand: number;
+    col_cost?: number;
+    columns?: Record<string, string>; // Header text per field, matched by name on import
+}
+
+interface MappingSuggestion {
+    header_row: number;
+    header_row_confidence: number;
+    fields: Record<string, { column: number; header: string; confidence: number }>;
+    mapping: MappingConfig;
 }
 
 export default function SupplierForm({ isOpen, onClose, supplierId }: SupplierFormProps) {
     const [name, setName] = useState('');
     const [notes, setNotes] = useState('');
     const [contacts, setContacts] = useState([{ type: 'EMAIL', label: 'Sales', value: '' }]);
-    const [mapping, setMapping] = useState<MappingConfig>({ header_row: 0, col_sku: 0, col_title: 0, col_barcode: 0, col_qty: 1, col_price: 2, col_brand: 3 });
+    const [mapping, setMapping] = useState<MappingConfig>({ header_row: 0, col_sku: 0, col_title: 0, col_barcode: -1, col_qty: 1, col_price: 2, col_brand: 3, col_cost: -1 });
 
     // Wizard State
     const [activeTab, setActiveTab] = useState<'info' | 'mapping'>('info');
     const [previewRows, setPreviewRows] = useState<string[][]>([]);
+    const [sheets, setSheets] = useState<string[]>([]);
+    const [suggestion, setSuggestion] = useState<MappingSuggestion | null>(null);
+    const [templateFile, setTemplateFile] = useState<File | null>(null);
     const fileInputRef = useRef<HTMLInputElement>(null);
 
     // Load supplier data if editing
@@ -47,16 +60,16 @@ export default function SupplierForm({ isOpen, onClose, supplierId }: SupplierFo
             setName('');
             setNotes('');
             setContacts([{ type: 'EMAIL', label: 'Sales', value: '' }]);
-            setMapping({ header_row: 0, col_sku: 0, col_title: 0, col_barcode: 0, col_qty: 1, col_price: 2, col_brand: 3 });
+            setMapping({ header_row: 0, col_sku: 0, col_title: 0, col_barcode: -1, col_qty: 1, col_price: 2, col_brand: 3, col_cost: -1 });
         }
     }, [supplierId, isOpen]);
 
     if (!isOpen) return null;
 
-    const handleFileUpload = async (e: React.ChangeEvent<HTMLInputElement>) => {
-        if (!e.target.files?.[0]) return;
+    const loadPreview = async (file: File, sheet?: string) => {
         const formData = new FormData();
-        formData.append('file', e.target.files[0]);
+        formData.append('file', file);
+        if (sheet) formData.append('sheet', sheet);
 
         try {
             const res = await fetch('/api/suppliers/preview-excel', {
@@ -64,14 +77,29 @@ export default function SupplierForm({ isOpen, onClose, supplierId }: SupplierFo
                 body: formData,
             });
             if (res.ok) {
-                const rows = await res.json();
-                setPreviewRows(rows || []);
+                const data = await res.json();
+                setPreviewRows(data.rows || []);
+                setSheets(data.sheets || []);
+                setSuggestion(data.suggestion || null);
+                // mapping.sheet only changes when a sheet is picked below;
+                // left empty, imports read the first sheet of each file
+            } else if (sheet) {
+                // The saved sheet is not in this file; show its first sheet
+                await loadPreview(file);
+            } else {
+                alert(await res.text());
             }
         } catch (err) {
             console.error(err);
         }
     };
 
+    const handleFileUpload = async (e: React.ChangeEvent<HTMLInputElement>) => {
+        if (!e.target.files?.[0]) return;
+        setTemplateFile(e.target.files[0]);
+        await loadPreview(e.target.files[0], mapping.sheet);
+    };
+
     const handleSubmit = async () => {
         const payload = {
             name,
@@ -142,10 +170,41 @@ export default function SupplierForm({ isOpen, onClose, supplierId }: SupplierFo
                                 <button onClick={() => fileInputRef.current?.click()} className="px-4 py-2 bg-slate-700 hover:bg-slate-600 text-white rounded text-sm font-bold">
                                     Upload Template File
                                 </button>
-                                <input type="file" ref={fileInputRef} hidden accept=".xlsx,.csv" onChange={handleFileUpload} />
+                                <input type="file" ref={fileInputRef} hidden accept=".xlsx,.csv,.tsv,.txt" onChange={handleFileUpload} />
                                 <p className="text-xs text-slate-400">Upload a sample Excel/CSV to configure column mapping visually.</p>
+                                {sheets.length > 1 && templateFile && (
+                                    <select
+                                        className="ml-auto bg-slate-900 border border-slate-700 rounded p-1.5 text-white text-xs"
+                                        value={mapping.sheet || ''}
+                                        onChange={(e) => {
+                                            const sheet = e.target.value;
+                                            setMapping(m => ({ ...m, sheet }));
+                                            loadPreview(templateFile, sheet);
+                                        }}
+                                    >
+                                        <option value="">First sheet ({sheets[0]})</option>
+                                        {sheets.map((sheet) => (
+                                            <option key={sheet} value={sheet}>{sheet}</option>
+                                        ))}
+                                    </select>
+                                )}
                             </div>
 
+                            {suggestion && Object.keys(suggestion.fields).length > 0 && (
+                                <div className="flex items-center gap-3 p-3 bg-slate-800 rounded-lg text-xs text-slate-300">
+                                    <span>
+                                        Detected header on row {suggestion.header_row + 1} ({Math.round(suggestion.header_row_confidence * 100)}% confidence):{' '}
+                                        {Object.entries(suggestion.fields).map(([key, f]) => `${key} → "${f.header}" (${Math.round(f.confidence * 100)}%)`).join(', ')}
+                                    </span>
+                                    <button
+                                        onClick={() => setMapping({ ...suggestion.mapping, sheet: mapping.sheet })}
+                                        className="ml-auto px-3 py-1.5 bg-primary text-white rounded font-bold whitespace-nowrap"
+                                    >
+                                        Use Suggested Mapping
+                                    </button>
+                                </div>
+                            )}
+
                             {previewRows.length > 0 && (
                                 <div className="border border-slate-700 rounded-lg overflow-hidden">
                                     <div className="p-2 bg-slate-800 text-xs text-slate-300 border-b border-slate-700">
@@ -179,6 +238,7 @@ export default function SupplierForm({ isOpen, onClose, supplierId }: SupplierFo
                                     { label: 'Barcode Column', key: 'col_barcode' },
                                     { label: 'Quantity Column', key: 'col_qty' },
                                     { label: 'Price Column', key: 'col_price' },
+                                    { label: 'Cost Column', key: 'col_cost' },
                                     { label: 'Brand Column', key: 'col_brand' }
                                 ].map((field) => (
                                     <div key={field.key}>
@@ -186,10 +246,19 @@ export default function SupplierForm({ isOpen, onClose, supplierId }: SupplierFo
                                         <select
                                             className="w-full bg-slate-900 border border-slate-700 rounded p-1.5 text-white text-xs"
                                             // @ts-ignore
-                                            value={mapping[field.key]}
-                                            // @ts-ignore
-                                            onChange={(e) => setMapping({ ...mapping, [field.key]: parseInt(e.target.value) })}
+                                            value={mapping[field.key] ?? -1}
+                                            onChange={(e) => {
+                                                // Remember the header text too, so moved columns are still found
+                                                const idx = parseInt(e.target.value);
+                                                const header = previewRows[mapping.header_row]?.[idx]?.trim();
+                                                const columns = { ...(mapping.columns || {}) };
+                                                const name = field.key.replace('col_', '');
+                                                if (header) columns[name] = header;
+                                                else delete columns[name];
+                                                setMapping({ ...mapping, [field.key]: idx, columns });
+                                            }}
                                         >
+                                            <option value={-1}>(none)</option>
                                             {previewRows[mapping.header_row]?.map((colName, idx) => (
                                                 <option key={idx} value={idx}>{idx}: {colName || `Col ${idx}`}</option>
                                             )) || <option value={0}>Col 0</option>}